@@ -80,6 +80,8 @@ func main() {
 	stx.Set("LangBash", syntax.LangBash)
 	stx.Set("LangPOSIX", syntax.LangPOSIX)
 	stx.Set("LangMirBSDKorn", syntax.LangMirBSDKorn)
+	stx.Set("LangBats", syntax.LangBats)
+	stx.Set("LangBusyBox", syntax.LangBusyBox)
 	stx.Set("StopAt", func(word string) func(interface{}) {
 		return func(v interface{}) {
 			syntax.StopAt(word)(&v.(*jsParser).Parser)
@@ -157,6 +157,30 @@ var interactiveTests = []struct {
 		},
 		wantErr: "exit status 1",
 	},
+	{
+		pairs: []string{
+			"cat <<EOF\n",
+			"> ",
+			"foo\n",
+			"> ",
+			"EOF\n",
+			"foo\n$ ",
+		},
+	},
+	{
+		pairs: []string{
+			"cat <<A; cat <<B\n",
+			"> ",
+			"foo\n",
+			"> ",
+			"A\n",
+			"> ",
+			"bar\n",
+			"> ",
+			"B\n",
+			"foo\nbar\n$ ",
+		},
+	},
 	{
 		pairs: []string{
 			"(\n",
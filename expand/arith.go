@@ -6,6 +6,7 @@ package expand
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"mvdan.cc/sh/v3/syntax"
 )
@@ -105,10 +106,17 @@ func oneIf(b bool) int {
 	return 0
 }
 
-// atoi is just a shorthand for strconv.Atoi that ignores the error,
-// just like shells do.
+// atoi is just a shorthand for syntax.ArithmInt that ignores the error,
+// just like shells do. It also allows a leading sign, as found in variables.
 func atoi(s string) int {
-	n, _ := strconv.Atoi(s)
+	neg := strings.HasPrefix(s, "-")
+	if neg || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	n, _ := syntax.ArithmInt(s)
+	if neg {
+		return -n
+	}
 	return n
 }
 
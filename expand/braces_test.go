@@ -159,6 +159,10 @@ func TestBraces(t *testing.T) {
 			inBraces := *tc.in
 			syntax.SplitBraces(&inBraces)
 			wantBraceExpParts(t, &inBraces, inStr != wantStr)
+			if splitStr := printWords(&inBraces); splitStr != inStr {
+				t.Fatalf("SplitBraces changed how %q prints: %q",
+					inStr, splitStr)
+			}
 
 			got := Braces(&inBraces)
 			gotStr := printWords(got...)
@@ -26,7 +26,7 @@ type Config struct {
 	// shell expansions. Some special parameters are also expanded via this
 	// interface, such as:
 	//
-	//   * "#", "@", "*", "0"-"9" for the shell's parameters
+	//   * "#", "@", "*", "0", and "1", "2", ... for the shell's parameters
 	//   * "?", "$", "PPID" for the shell's status and process
 	//   * "HOME foo" to retrieve user foo's home directory (if unset,
 	//     os/user.Lookup will be used)
@@ -190,6 +190,23 @@ func Pattern(cfg *Config, word *syntax.Word) (string, error) {
 	return buf.String(), nil
 }
 
+// SglQuoted returns the value of a single-quoted string. If the string is
+// ANSI-C quoted, like $'foo\n', its escape sequences are decoded. These include
+// \n, \t, \xNN, \uNNNN, octal escapes like \0NNN, and control characters
+// like \cA.
+func SglQuoted(sq *syntax.SglQuoted) string {
+	if !sq.Dollar {
+		return sq.Value
+	}
+	return prepareConfig(nil).sglQuoted(sq.Value)
+}
+
+// sglQuoted decodes the escape sequences of an ANSI-C quoted string.
+func (cfg *Config) sglQuoted(s string) string {
+	val, _, _ := cfg.format(s, nil, true)
+	return val
+}
+
 // Format expands a format string with a number of arguments, following the
 // shell's format specifications. These include printf(1), among others.
 //
@@ -199,6 +216,12 @@ func Pattern(cfg *Config, word *syntax.Word) (string, error) {
 // empty config.
 func Format(cfg *Config, format string, args []string) (string, int, error) {
 	cfg = prepareConfig(cfg)
+	return cfg.format(format, args, false)
+}
+
+// format implements Format. If ansiC is true, the escape sequences which are
+// only valid in ANSI-C quoted strings, like \cX, are decoded too.
+func (cfg *Config) format(format string, args []string, ansiC bool) (string, int, error) {
 	buf := cfg.strBuilder()
 	var fmts []byte
 	initialArgs := len(args)
@@ -224,7 +247,7 @@ func Format(cfg *Config, format string, args []string) (string, int, error) {
 		}
 		c := format[i]
 		switch {
-		case c == '\\': // escaped
+		case c == '\\' && i+1 < len(format): // escaped
 			i++
 			switch c = format[i]; c {
 			case 'a': // bell
@@ -245,6 +268,22 @@ func Format(cfg *Config, format string, args []string) (string, int, error) {
 				buf.WriteByte('\v')
 			case '\\', '\'', '"', '?': // just the character
 				buf.WriteByte(c)
+			case 'c': // control character
+				if !ansiC || i+1 >= len(format) {
+					buf.WriteByte('\\')
+					buf.WriteByte(c)
+					break
+				}
+				i++
+				c = format[i]
+				if c == '\\' && i+1 < len(format) && format[i+1] == '\\' {
+					i++ // like in bash, "\c\\" is a control backslash
+				}
+				if c == '?' {
+					buf.WriteByte(0x7f)
+				} else {
+					buf.WriteByte(c & 0x1f)
+				}
 			case '0', '1', '2', '3', '4', '5', '6', '7':
 				digits := readDigits(3, false)
 				// if digits don't fit in 8 bits, 0xff via strconv
@@ -420,17 +459,10 @@ const (
 
 func (cfg *Config) wordField(wps []syntax.WordPart, ql quoteLevel) ([]fieldPart, error) {
 	var field []fieldPart
-	for i, wp := range wps {
+	for _, wp := range wps {
 		switch x := wp.(type) {
 		case *syntax.Lit:
 			s := x.Value
-			if i == 0 && ql == quoteNone {
-				if prefix, rest := cfg.expandUser(s); prefix != "" {
-					// TODO: return two separate fieldParts,
-					// like in wordFields?
-					s = prefix + rest
-				}
-			}
 			if ql == quoteDouble && strings.Contains(s, "\\") {
 				buf := cfg.strBuilder()
 				for i := 0; i < len(s); i++ {
@@ -446,10 +478,16 @@ func (cfg *Config) wordField(wps []syntax.WordPart, ql quoteLevel) ([]fieldPart,
 				s = buf.String()
 			}
 			field = append(field, fieldPart{val: s})
+		case *syntax.Tilde:
+			fp := fieldPart{val: "~" + x.User}
+			if ql == quoteNone {
+				fp = fieldPart{quote: quoteSingle, val: cfg.tilde(x)}
+			}
+			field = append(field, fp)
 		case *syntax.SglQuoted:
 			fp := fieldPart{quote: quoteSingle, val: x.Value}
 			if x.Dollar {
-				fp.val, _, _ = Format(cfg, fp.val, nil)
+				fp.val = cfg.sglQuoted(fp.val)
 			}
 			field = append(field, fp)
 		case *syntax.DblQuoted:
@@ -522,18 +560,10 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 			curField = append(curField, fieldPart{val: field})
 		}
 	}
-	for i, wp := range wps {
+	for _, wp := range wps {
 		switch x := wp.(type) {
 		case *syntax.Lit:
 			s := x.Value
-			if i == 0 {
-				prefix, rest := cfg.expandUser(s)
-				curField = append(curField, fieldPart{
-					quote: quoteSingle,
-					val:   prefix,
-				})
-				s = rest
-			}
 			if strings.Contains(s, "\\") {
 				buf := cfg.strBuilder()
 				for i := 0; i < len(s); i++ {
@@ -547,11 +577,16 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 				s = buf.String()
 			}
 			curField = append(curField, fieldPart{val: s})
+		case *syntax.Tilde:
+			curField = append(curField, fieldPart{
+				quote: quoteSingle,
+				val:   cfg.tilde(x),
+			})
 		case *syntax.SglQuoted:
 			allowEmpty = true
 			fp := fieldPart{quote: quoteSingle, val: x.Value}
 			if x.Dollar {
-				fp.val, _, _ = Format(cfg, fp.val, nil)
+				fp.val = cfg.sglQuoted(fp.val)
 			}
 			curField = append(curField, fp)
 		case *syntax.DblQuoted:
@@ -621,41 +656,58 @@ func (cfg *Config) quotedElemFields(pe *syntax.ParamExp) []string {
 	if pe == nil || pe.Length || pe.Width {
 		return nil
 	}
+	if pe.Exp != nil && pe.Exp.Op == syntax.OtherParamOps && pe.Exp.Word.Lit() == "A" {
+		// the entire array as a single declaration
+		return nil
+	}
 	if pe.Excl {
 		if pe.Names == syntax.NamesPrefixWords {
 			return cfg.namesByPrefix(pe.Param.Value)
 		}
+		if nodeLit(pe.Index) == "@" {
+			return arrayKeys(cfg.Env.Get(pe.Param.Value))
+		}
 		return nil
 	}
 	name := pe.Param.Value
+	index := nodeLit(pe.Index)
 	switch name {
-	case "*":
-		return []string{cfg.ifsJoin(cfg.Env.Get(name).List)}
-	case "@":
-		return cfg.Env.Get(name).List
-	}
-	switch nodeLit(pe.Index) {
-	case "@":
-		if vr := cfg.Env.Get(name); vr.Kind == Indexed {
-			return vr.List
-		}
-	case "*":
-		if vr := cfg.Env.Get(name); vr.Kind == Indexed {
-			return []string{cfg.ifsJoin(vr.List)}
+	case "@", "*":
+		index = name
+	}
+	var elems []string
+	switch vr := cfg.Env.Get(name); {
+	case index != "@" && index != "*":
+		return nil
+	case vr.Kind == Indexed:
+		elems = vr.List
+	case vr.Kind == Associative:
+		elems = arrayValues(vr)
+	default:
+		return nil
+	}
+	if pe.Slice != nil {
+		var err error
+		if elems, err = cfg.sliceElems(pe, elems); err != nil {
+			return nil
 		}
 	}
-	return nil
+	if index == "*" {
+		return []string{cfg.ifsJoin(elems)}
+	}
+	return elems
 }
 
-func (cfg *Config) expandUser(field string) (prefix, rest string) {
-	if len(field) == 0 || field[0] != '~' {
-		return "", field
-	}
-	name := field[1:]
-	if i := strings.Index(name, "/"); i >= 0 {
-		rest = name[i:]
-		name = name[:i]
+// tilde expands a tilde prefix, falling back to its literal form if the home
+// directory is unknown.
+func (cfg *Config) tilde(t *syntax.Tilde) string {
+	if home, ok := cfg.userHome(t.User); ok {
+		return home
 	}
+	return "~" + t.User
+}
+
+func (cfg *Config) userHome(name string) (string, bool) {
 	if name == "" {
 		// Current user; try via "HOME", otherwise fall back to the
 		// system's appropriate home dir env var. Don't use os/user, as
@@ -663,29 +715,29 @@ func (cfg *Config) expandUser(field string) (prefix, rest string) {
 		// to use cfg.Env, and we always want to check "HOME" first.
 
 		if vr := cfg.Env.Get("HOME"); vr.IsSet() {
-			return vr.String(), rest
+			return vr.String(), true
 		}
 
 		if runtime.GOOS == "windows" {
 			if vr := cfg.Env.Get("USERPROFILE"); vr.IsSet() {
-				return vr.String(), rest
+				return vr.String(), true
 			}
 		}
-		return "", field
+		return "", false
 	}
 
 	// Not the current user; try via "HOME <name>", otherwise fall back to
 	// os/user. There isn't a way to lookup user home dirs without cgo.
 
 	if vr := cfg.Env.Get("HOME " + name); vr.IsSet() {
-		return vr.String(), rest
+		return vr.String(), true
 	}
 
 	u, err := user.Lookup(name)
 	if err != nil {
-		return "", field
+		return "", false
 	}
-	return u.HomeDir, rest
+	return u.HomeDir, true
 }
 
 func findAllIndex(pat, name string, n int) [][]int {
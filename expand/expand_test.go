@@ -90,3 +90,25 @@ func TestFieldsIdempotency(t *testing.T) {
 		}
 	}
 }
+
+func TestSglQuoted(t *testing.T) {
+	tests := []struct {
+		dollar bool
+		value  string
+		want   string
+	}{
+		{false, `a\nb`, `a\nb`},
+		{true, `a\nb`, "a\nb"},
+		{true, `\t\x41\u00e9\101\\\'`, "\tA\u00e9A\\'"},
+		{true, `\q`, `\q`},
+		{true, `a\`, `a\`},
+		{true, `\cA\ca\c[\c?\c\\x`, "\x01\x01\x1b\x7f\x1cx"},
+		{true, `a\c`, `a\c`},
+	}
+	for _, tc := range tests {
+		sq := &syntax.SglQuoted{Dollar: tc.dollar, Value: tc.value}
+		if got := SglQuoted(sq); got != tc.want {
+			t.Fatalf("%q: wanted %q, got %q", tc.value, tc.want, got)
+		}
+	}
+}
@@ -61,17 +61,6 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	slicePos := func(n int) int {
-		if n < 0 {
-			n = len(str) + n
-			if n < 0 {
-				n = len(str)
-			}
-		} else if n > len(str) {
-			n = len(str)
-		}
-		return n
-	}
 	elems := []string{str}
 	switch nodeLit(index) {
 	case "@", "*":
@@ -80,6 +69,8 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 			elems = nil
 		case Indexed:
 			elems = vr.List
+		case Associative:
+			elems = arrayValues(vr)
 		}
 	}
 	switch {
@@ -98,38 +89,42 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 			strs = cfg.namesByPrefix(pe.Param.Value)
 		case orig.Kind == NameRef:
 			strs = append(strs, orig.Str)
-		case vr.Kind == Indexed:
-			for i, e := range vr.List {
-				if e != "" {
-					strs = append(strs, strconv.Itoa(i))
-				}
-			}
-		case vr.Kind == Associative:
-			for k := range vr.Map {
-				strs = append(strs, k)
-			}
+		case nodeLit(index) == "@", nodeLit(index) == "*":
+			strs = arrayKeys(vr)
 		case !syntax.ValidName(str):
 			return "", fmt.Errorf("invalid indirect expansion")
 		default:
 			vr = cfg.Env.Get(str)
 			strs = append(strs, vr.String())
 		}
-		sort.Strings(strs)
 		str = strings.Join(strs, " ")
 	case pe.Slice != nil:
-		if pe.Slice.Offset != nil {
-			n, err := Arithm(cfg, pe.Slice.Offset)
-			if err != nil {
-				return "", err
+		switch nodeLit(index) {
+		case "@", "*":
+			if vr.Kind == Indexed || vr.Kind == Associative {
+				elems, err := cfg.sliceElems(pe, elems)
+				if err != nil {
+					return "", err
+				}
+				str = strings.Join(elems, " ")
+				break
 			}
-			str = str[slicePos(n):]
-		}
-		if pe.Slice.Length != nil {
-			n, err := Arithm(cfg, pe.Slice.Length)
-			if err != nil {
-				return "", err
+			fallthrough
+		default:
+			if pe.Slice.Offset != nil {
+				n, err := Arithm(cfg, pe.Slice.Offset)
+				if err != nil {
+					return "", err
+				}
+				str = str[slicePos(n, len(str)):]
+			}
+			if pe.Slice.Length != nil {
+				n, err := Arithm(cfg, pe.Slice.Length)
+				if err != nil {
+					return "", err
+				}
+				str = str[:slicePos(n, len(str))]
 			}
-			str = str[:slicePos(n)]
 		}
 	case pe.Repl != nil:
 		orig, err := Pattern(cfg, pe.Repl.Orig)
@@ -231,9 +226,10 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 				for ri, r := range rs {
 					if rx.MatchString(string(r)) {
 						rs[ri] = caseFunc(r)
-						if !all {
-							break
-						}
+					}
+					if !all {
+						// only the first character
+						break
 					}
 				}
 				elems[i] = string(rs)
@@ -242,7 +238,10 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 		case syntax.OtherParamOps:
 			switch arg {
 			case "Q":
-				str = strconv.Quote(str)
+				for i, elem := range elems {
+					elems[i] = shellQuote(elem)
+				}
+				str = strings.Join(elems, " ")
 			case "E":
 				tail := str
 				var rns []rune
@@ -252,8 +251,23 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 					rns = append(rns, rn)
 				}
 				str = string(rns)
-			case "P", "A", "a":
-				panic(fmt.Sprintf("unhandled @%s param expansion", arg))
+			case "a":
+				str = varAttrs(vr)
+			case "A":
+				val := shellQuote(str)
+				switch {
+				case name == "@", name == "*":
+				case vr.Kind != Indexed && vr.Kind != Associative:
+				case pe.Index == nil, nodeLit(index) == "@", nodeLit(index) == "*":
+					// the entire array, as declared
+					val = arrayExpr(vr)
+				}
+				str = pe.Param.Value + "=" + val
+				if attrs := varAttrs(vr); attrs != "" {
+					str = "declare -" + attrs + " " + str
+				}
+			case "P":
+				return "", fmt.Errorf("unhandled @%s param expansion", arg)
 			default:
 				panic(fmt.Sprintf("unexpected @%s param expansion", arg))
 			}
@@ -262,6 +276,56 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 	return str, nil
 }
 
+// shellQuote quotes a string with single quotes, so that the shell would read
+// it back as the same string.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// arrayExpr returns the elements of an indexed or associative array as an
+// array expression like "([0]='a' [1]='b c')", which the shell would read back
+// as the same array.
+func arrayExpr(vr Variable) string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for i, key := range arrayKeys(vr) {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		var val string
+		if vr.Kind == Indexed {
+			n, _ := strconv.Atoi(key)
+			val = vr.List[n]
+		} else {
+			val = vr.Map[key]
+			if !syntax.ValidName(key) {
+				key = shellQuote(key)
+			}
+		}
+		fmt.Fprintf(&sb, "[%s]=%s", key, shellQuote(val))
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// varAttrs returns the attribute flags of a variable, as used by declare.
+func varAttrs(vr Variable) string {
+	var flags []byte
+	switch vr.Kind {
+	case Indexed:
+		flags = append(flags, 'a')
+	case Associative:
+		flags = append(flags, 'A')
+	}
+	if vr.ReadOnly {
+		flags = append(flags, 'r')
+	}
+	if vr.Exported {
+		flags = append(flags, 'x')
+	}
+	return string(flags)
+}
+
 func removePattern(str, pat string, fromEnd, shortest bool) string {
 	var mode pattern.Mode
 	if shortest {
@@ -301,7 +365,7 @@ func (cfg *Config) varInd(vr Variable, idx syntax.ArithmExpr) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		if n == 0 {
+		if n == 0 || n == -1 {
 			return vr.Str, nil
 		}
 	case Indexed:
@@ -313,17 +377,17 @@ func (cfg *Config) varInd(vr Variable, idx syntax.ArithmExpr) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		if len(vr.List) > 0 {
+		if i < 0 {
+			// negative indices count from the end of the array
+			i += len(vr.List)
+		}
+		if i >= 0 && i < len(vr.List) {
 			return vr.List[i], nil
 		}
 	case Associative:
 		switch lit := nodeLit(idx); lit {
 		case "@", "*":
-			strs := make([]string, 0, len(vr.Map))
-			for _, val := range vr.Map {
-				strs = append(strs, val)
-			}
-			sort.Strings(strs)
+			strs := arrayValues(vr)
 			if lit == "*" {
 				return cfg.ifsJoin(strs), nil
 			}
@@ -346,5 +410,78 @@ func (cfg *Config) namesByPrefix(prefix string) []string {
 		}
 		return true
 	})
+	sort.Strings(names)
 	return names
 }
+
+// slicePos clamps an offset into a string or list of the given size, where a
+// negative offset counts from the end.
+func slicePos(n, size int) int {
+	if n < 0 {
+		n = size + n
+		if n < 0 {
+			n = size
+		}
+	} else if n > size {
+		n = size
+	}
+	return n
+}
+
+// sliceElems applies the slice in ${name[@]:offset:length} to an array's
+// elements. When slicing the positional parameters, $0 is at offset 0.
+// Unlike with strings, a negative length is an error.
+func (cfg *Config) sliceElems(pe *syntax.ParamExp, elems []string) ([]string, error) {
+	switch pe.Param.Value {
+	case "@", "*":
+		elems = append([]string{cfg.envGet("0")}, elems...)
+	}
+	if pe.Slice.Offset != nil {
+		n, err := Arithm(cfg, pe.Slice.Offset)
+		if err != nil {
+			return nil, err
+		}
+		elems = elems[slicePos(n, len(elems)):]
+	}
+	if pe.Slice.Length != nil {
+		n, err := Arithm(cfg, pe.Slice.Length)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%d: substring expression < 0", n)
+		}
+		elems = elems[:slicePos(n, len(elems))]
+	}
+	return elems, nil
+}
+
+// arrayKeys returns the keys of an indexed or associative array. Indexes are
+// in increasing order, and associative keys are sorted.
+func arrayKeys(vr Variable) []string {
+	var keys []string
+	switch vr.Kind {
+	case Indexed:
+		for i, e := range vr.List {
+			if e != "" {
+				keys = append(keys, strconv.Itoa(i))
+			}
+		}
+	case Associative:
+		for k := range vr.Map {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// arrayValues returns the values of an associative array, in the same order
+// as their keys in arrayKeys.
+func arrayValues(vr Variable) []string {
+	vals := make([]string, 0, len(vr.Map))
+	for _, k := range arrayKeys(vr) {
+		vals = append(vals, vr.Map[k])
+	}
+	return vals
+}
@@ -366,6 +366,8 @@ var runTests = []runTest{
 	{"a=世界; echo ${#a}", "2\n"},
 	{"a=(a bcd); echo ${#a} ${#a[@]} ${#a[*]} ${#a[1]}", "1 2 2 3\n"},
 	{"set -- a bc; echo ${#@} ${#*} $#", "2 2 2\n"},
+	{"set -- a b c d e f g h i j k; echo ${10} ${11} ${12}x $10", "j k x a0\n"},
+	{"set -- a b c d e f g h i j; echo ${#10} ${10:-x} ${11:-y}", "1 j y\n"},
 	{
 		"echo ${!a}; echo more",
 		"invalid indirect expansion\nexit status 1 #JUSTERR",
@@ -374,6 +376,10 @@ var runTests = []runTest{
 		"a=b; echo ${!a}; b=c; echo ${!a}",
 		"\nc\n",
 	},
+	{
+		"x=val; a=(x y); declare -A m=([k]=x); echo ${!a[0]} ${!a} ${!m[k]}",
+		"val val val\n",
+	},
 	{
 		"a=foo; echo ${a:1}; echo ${a: -1}; echo ${a: -10}; echo ${a:5}",
 		"oo\no\n\n\n",
@@ -411,6 +417,12 @@ var runTests = []runTest{
 	{"a='[[:wrong:]]'; echo ${a//[[:wrong:]]}", "[[:wrong:]]\n"},
 	{"a='[[:wrong:]]'; echo ${a//[[:}", "[[:wrong:]]\n"},
 	{"a='abcx1y'; echo ${a//x[[:digit:]]y}", "abc\n"},
+	{"a='a b_c'; echo ${a//[[:space:]_]/-}", "a-b-c\n"},
+	{
+		"for a in _x 1x; do case $a in [[:alpha:]_]*) echo $a ;; esac; done",
+		"_x\n",
+	},
+	{"[[ 3 == [![:alpha:]] ]] && echo y", "y\n"},
 	{`a=xyz; echo "${a/y/a  b}"`, "xa  bz\n"},
 	{"a='foo/bar'; echo ${a//o*a/}", "fr\n"},
 	{
@@ -489,6 +501,10 @@ var runTests = []runTest{
 		"a='àÉñ bAr'; echo ${a,?}; echo ${a,,[br]}",
 		"àÉñ bAr\nàÉñ bAr\n",
 	},
+	{
+		"a=hello p=l; echo ${a^$p} ${a^h} ${a^^$p}",
+		"hello Hello heLLo\n",
+	},
 	{
 		"a=(àÉñ bAr); echo ${a[@]^}; echo ${a[*],,}",
 		"ÀÉñ BAr\nàéñ bar\n",
@@ -525,6 +541,22 @@ var runTests = []runTest{
 		`a='"\n'; printf "%s %s" "${a}" "${a@E}"`,
 		"\"\\n \"\n",
 	},
+	{
+		`a="b'c"; e=; echo ${a@Q} ${e@Q} ${a@A}`,
+		`'b'\''c' '' a='b'\''c'` + "\n",
+	},
+	{
+		`declare -r a=b; c=(d 'e f'); echo ${a@a} ${c@a} ${a@A}; echo ${c[@]@Q}`,
+		"r a declare -r a='b'\n'd' 'e f'\n",
+	},
+	{
+		`c=(d 'e f'); i=1; echo "${c@A}"; echo "${c[@]@A}"; echo "${c[i]@A}"; c[3]=g; echo "${c@A}"`,
+		"declare -a c=([0]='d' [1]='e f')\ndeclare -a c=([0]='d' [1]='e f')\ndeclare -a c='e f'\ndeclare -a c=([0]='d' [1]='e f' [3]='g')\n #IGNORE",
+	},
+	{
+		`declare -A m=([x]='y z' [k]=v ['a b']=c); echo "${m@A}"; echo "${m[k]@A}"; e=(); echo "${e@A}"`,
+		"declare -A m=(['a b']='c' [k]='v' [x]='y z')\ndeclare -A m='v'\ndeclare -a e=()\n #IGNORE",
+	},
 
 	// if
 	{
@@ -739,6 +771,9 @@ var runTests = []runTest{
 	{`[[ foo/bar == foo* ]]`, ""},
 	{"[[ a == [ab ]]", "exit status 1"},
 	{`HOME='/*'; echo ~; echo "$HOME"`, "/*\n/*\n"},
+	{`HOME=/h; x=y; echo ~$x \~ "~" a~ ~/a`, "~y ~ ~ a~ /h/a\n"},
+	{`HOME=/h; a=~:~/b:c~:~; echo "$a"`, "/h:/h/b:c~:/h\n"},
+	{`HOME=/h; y=/h/z; echo ${x:-~} "${x:-~}" "${y#~}"`, "/h ~ /z\n"},
 	{`test -d ~`, ""},
 	{`foo=~; test -d $foo`, ""},
 	{`foo=~; test -d "$foo"`, ""},
@@ -954,6 +989,14 @@ var runTests = []runTest{
 		"case foo in '*') echo x ;; f*) echo y ;; esac",
 		"y\n",
 	},
+	{
+		"case a in a) echo 1 ;& b) echo 2 ;& c) echo 3 ;; d) echo 4 ;; esac",
+		"1\n2\n3\n",
+	},
+	{
+		"case ab in a*) echo x ;;& x*) echo y ;;& *b) echo z ;; *) echo w ;; esac",
+		"x\nz\n",
+	},
 
 	// exec
 	{
@@ -1036,6 +1079,10 @@ var runTests = []runTest{
 		"echo foo >f; echo $(<f; echo bar)",
 		"bar\n",
 	},
+	{
+		"echo foo >f; >f; wc -c <f; 2>/dev/null <f && echo ok",
+		"0\nok\n",
+	},
 
 	// pipes
 	{
@@ -1096,6 +1143,18 @@ var runTests = []runTest{
 		"{ echo a; echo b >&2; } &>/dev/null",
 		"",
 	},
+	{
+		"exec {fd}>a",
+		"{fd}: {varname} redirects are not supported\nexit status 1 #JUSTERR",
+	},
+	{
+		"echo foo >a; echo bar >|a; cat a",
+		"bar\n",
+	},
+	{
+		"echo foo >a; cat <>a; cat <>b; wc -c <b",
+		"foo\n0\n",
+	},
 	{
 		"sed 's/o/a/g' <<EOF\nfoo$foo\nEOF",
 		"faa\n",
@@ -1136,6 +1195,14 @@ var runTests = []runTest{
 		"cat <<'EOF'\nfoo\\\nbar\nEOF",
 		"foo\\\nbar\n",
 	},
+	{
+		"cat <<'EOF'\n\\$x \\\\ `y`\nEOF",
+		"\\$x \\\\ `y`\n",
+	},
+	{
+		"cat <<-\\EOF\n\t\\$x\n\tEOF",
+		"\\$x\n",
+	},
 	{
 		"mkdir a; echo foo >a |& grep -q 'is a directory'",
 		" #IGNORE",
@@ -1537,6 +1604,10 @@ var runTests = []runTest{
 		"echo $((1 + 2 - 3))",
 		"0\n",
 	},
+	{
+		"a=2; echo $[a * 3] \"$[1+1]\"",
+		"6 2\n",
+	},
 	{
 		"echo $((-1 * 6 / 2))",
 		"-3\n",
@@ -1585,10 +1656,26 @@ var runTests = []runTest{
 		"echo $((2 ** 3)) $((1234 ** 4567))",
 		"8 0\n",
 	},
+	{
+		"echo $((2 ** 3 ** 2)) $((2 * 3 ** 2)) $((2 ** 3 * 2))",
+		"512 18 16\n",
+	},
+	{
+		"echo $((0xff + 010 + 2#1011 + 64#z)) $((36#Zz)) $((64#_))",
+		"309 1295 63\n",
+	},
+	{
+		"a=010 b=-0x10; echo $((a + b))",
+		"-8\n",
+	},
 	{
 		"echo $((1 ? 2 : 3)) $((0 ? 2 : 3))",
 		"2 3\n",
 	},
+	{
+		"x=-3; echo $((x > 0 ? x : -x)) $((0 ? 1 : 0 ? 2 : 3)) $((1 ? 0 ? 1 : 2 : 3))",
+		"3 3 2\n",
+	},
 	{
 		"((1))",
 		"",
@@ -1597,6 +1684,10 @@ var runTests = []runTest{
 		"((3 == 4))",
 		"exit status 1",
 	},
+	{
+		"i=0; ((i++)) || echo zero; ((i++)) && echo $i",
+		"zero\n2\n",
+	},
 	{
 		"let i=(3+4); let i++; echo $i; let i--; echo $i",
 		"8\n7\n",
@@ -1946,14 +2037,62 @@ set +o pipefail
 		"a=(b c); echo ${a[@]}; echo ${a[*]}",
 		"b c\nb c\n",
 	},
+	{
+		`a=(a b c d); echo ${a[@]:1:2} ${a[*]: -2}; for e in "${a[@]:1:2}"; do echo "[$e]"; done`,
+		"b c c d\n[b]\n[c]\n",
+	},
+	{
+		`set -- x y z; echo ${@:2} ${*:1:2}; for e in "${@:2:1}"; do echo "[$e]"; done`,
+		"y z x y\n[y]\n",
+	},
+	{
+		`set -- x y; for e in "${@:0}"; do [[ $e == "$0" ]] && e=zero; echo -n "$e "; done; s=${*::2}; echo ${s#"$0"} ${@: -1}`,
+		"zero x y x y\n",
+	},
+	{
+		`declare -A m=([x]=a [y]=a [z]=a); echo ${m[@]:1}; for e in "${m[@]:1:1}"; do echo "[$e]"; done`,
+		"a a\n[a]\n",
+	},
+	{
+		"a=(a b c); echo ${a[@]:0:-1}; echo more",
+		"-1: substring expression < 0\nexit status 1 #JUSTERR",
+	},
+	{
+		`set -- a b c; for e in "${@:1:-2}"; do echo "[$e]"; done`,
+		"-2: substring expression < 0\nexit status 1 #JUSTERR",
+	},
+	{
+		"a=abcd; echo ${a:1:-1}",
+		"bc\n",
+	},
 	{
 		"a=(1 2 3); echo ${a[2-1]}; echo $((a[1+1]))",
 		"2\n3\n",
 	},
+	{
+		"a=(x y z w); i=1; echo ${a[-1]} ${a[i*2+1]} ${a[-2]} ${#a[-3]}",
+		"w w z 1\n",
+	},
+	{
+		"a=(1 2 3); echo \"${a[-4]}\" $((a[-1] + a[-3]))",
+		" 4\n",
+	},
+	{
+		"a=(x y z); a[-1]=c; a[-3]+=a; echo ${a[@]}; b=s; echo ${b[-1]}",
+		"xa y c\ns\n",
+	},
+	{
+		"a=(x y z); a[-4]=c; echo ${a[@]}",
+		"a: bad array subscript\nx y z\n",
+	},
 	{
 		"a=(1 2) x=(); a+=b x+=c; echo ${a[@]}; echo ${x[@]}",
 		"1b 2\nc\n",
 	},
+	{
+		"a=(1 2 3); i=0; a[i+1]+=b a[4]+=c; echo ${a[@]}; echo \"${a[3]}\"",
+		"1 2b 3 c\n\n",
+	},
 	{
 		"a=(1 2) x=(); a+=(b c) x+=(d e); echo ${a[@]}; echo ${x[@]}",
 		"1 2 b c\nd e\n",
@@ -2045,6 +2184,13 @@ set +o pipefail
 	{"i=3; a=b; a[i]=x; echo ${a[@]}", "b x\n"},
 	{"i=3; declare a=(b); a[i]=x; echo ${!a[@]}", "0 3\n"},
 	{"i=3; declare -A a=(['x']=b); a[i]=x; for e in ${!a[@]}; do echo $e; done | sort", "i\nx\n"},
+	{"declare -A a=([x]=b [y]=c); a+=([z]=d [x]=e); for e in ${a[@]}; do echo $e; done | sort", "c\nd\ne\n"},
+	{"declare -A a; a+=([x]=b); echo ${a[x]}", "b\n"},
+	{"declare -A a=([x]=b [y]=c); echo ${#a[@]} ${#a[*]}", "2 2\n"},
+	{`declare -A a=([x]='b c' [y]=d); for e in "${a[@]}"; do echo $e; done | sort`, "b c\nd\n"},
+	{`declare -A a=([x]=b ['y z']=c); for k in "${!a[@]}"; do echo $k; done`, "x\ny z\n"},
+	{`a=(b c d e f g h i j k l); echo ${!a[@]}; for i in "${!a[@]}"; do echo -n $i; done`, "0 1 2 3 4 5 6 7 8 9 10\n012345678910"},
+	{`declare -A a=([x]=b [y]=a [z]='c d'); set -- ${!a[@]}; for e in "${a[@]}"; do echo "$1=$e"; shift; done | sort`, "x=b\ny=a\nz=c d\n"},
 
 	// declare
 	{"declare -B foo", "declare: invalid option \"-B\"\nexit status 2 #JUSTERR"},
@@ -2060,6 +2206,8 @@ set +o pipefail
 	{"a='x=b y=c'; declare $a; echo $x $y", "b c\n"},
 	{"declare =bar", "declare: invalid name \"\"\nexit status 1 #JUSTERR"},
 	{"declare $unset=$unset", "declare: invalid name \"\"\nexit status 1 #JUSTERR"},
+	{"a=x; f() { typeset a=b; echo $a; }; f; echo $a", "b\nx\n"},
+	{"typeset -a a=(b c); echo ${a[1]}", "c\n"},
 
 	// export
 	{"declare foo=bar; $ENV_PROG | grep '^foo='", "exit status 1"},
@@ -2171,11 +2319,18 @@ set +o pipefail
 		"declare -n foo=bar; foo=xxx; echo $foo $bar",
 		"xxx xxx\n",
 	},
-	// TODO: figure this one out
-	//{
-	//        "declare -n foo=bar bar=baz; foo=xxx; echo $foo $bar; echo $baz",
-	//        "xxx xxx\nxxx\n",
-	//},
+	{
+		"declare -n foo=bar bar=baz; foo=xxx; echo $foo $bar; echo $baz",
+		"xxx xxx\nxxx\n",
+	},
+	{
+		"a=1; f() { local -rn r=a; r=5; }; f; echo $a",
+		"5\n",
+	},
+	{
+		"readonly bar=x; declare -n foo=bar; foo=y; echo $bar",
+		"bar: readonly variable\nx\n",
+	},
 
 	// read-only vars
 	{"declare -r foo=bar; echo $foo", "bar\n"},
@@ -2346,6 +2501,20 @@ set +o pipefail
 	{"{ time -p; } |& wc", "      3       6      29\n"},
 	{"{ time -p echo -n; } |& wc", "      3       6      29\n"},
 
+	// select
+	{
+		`printf '2\n\n9\n' | { select x in a b; do echo "[$x][$REPLY]"; done; echo st=$?; } 2>&1`,
+		"1) a\n2) b\n#? [b][2]\n#? 1) a\n2) b\n#? [][9]\n#? \nst=1\n",
+	},
+	{
+		`echo 1 | { PS3='> '; select x in a b; do echo "[$x]"; break; done; echo st=$?; } 2>&1`,
+		"1) a\n2) b\n> [a]\nst=0\n",
+	},
+
+	// coproc
+	{"coproc echo foo", "coproc: coprocesses are not supported\nexit status 1 #JUSTERR"},
+	{"coproc name { echo foo; }", "coproc: coprocesses are not supported\nexit status 1 #JUSTERR"},
+
 	// exec
 	{"exec", ""},
 	{
@@ -14,6 +14,7 @@ import (
 	"os"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -423,6 +424,10 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 			if y.InPos.IsValid() {
 				items = r.fields(y.Items...) // for i in ...; do ...
 			}
+			if x.Select {
+				r.selectLoop(ctx, name, items, x.Do)
+				break
+			}
 			for _, field := range items {
 				r.setVarString(name, field)
 				if r.loopStmtsBroken(ctx, x.Do) {
@@ -454,13 +459,21 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 		r.exit = oneIf(val == 0)
 	case *syntax.CaseClause:
 		str := r.literal(x.Word)
+		fallthru := false
 		for _, ci := range x.Items {
-			for _, word := range ci.Patterns {
-				pattern := r.pattern(word)
-				if match(pattern, str) {
-					r.stmts(ctx, ci.Stmts)
-					return
-				}
+			if !fallthru && !r.caseMatches(ci, str) {
+				continue
+			}
+			r.stmts(ctx, ci.Stmts)
+			switch ci.Op {
+			case syntax.Fallthrough:
+				// ;& runs the next body without testing its patterns
+				fallthru = true
+			case syntax.Resume, syntax.ResumeKorn:
+				// ;;& and ;| keep testing the following patterns
+				fallthru = false
+			default:
+				return
 			}
 		}
 	case *syntax.TestClause:
@@ -473,7 +486,7 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 		var modes []string
 		valType := ""
 		switch x.Variant.Value {
-		case "declare":
+		case "declare", "typeset":
 			// When used in a function, "declare" acts as "local"
 			// unless the "-g" option is used.
 			local = r.inFunc
@@ -495,17 +508,20 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 			for _, as := range r.flattenAssign(as) {
 				name := as.Name.Value
 				if strings.HasPrefix(name, "-") {
-					switch name {
-					case "-x", "-r":
-						modes = append(modes, name)
-					case "-a", "-A", "-n":
-						valType = name
-					case "-g":
-						global = true
-					default:
-						r.errf("declare: invalid option %q\n", name)
-						r.exit = 2
-						return
+					// options may be combined, like "-rn"
+					for _, opt := range name[1:] {
+						switch opt := "-" + string(opt); opt {
+						case "-x", "-r":
+							modes = append(modes, opt)
+						case "-a", "-A", "-n":
+							valType = opt
+						case "-g":
+							global = true
+						default:
+							r.errf("declare: invalid option %q\n", name)
+							r.exit = 2
+							return
+						}
 					}
 					continue
 				}
@@ -551,6 +567,17 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 		// TODO: can we do these?
 		r.outf(format, "user", elapsedString(0, x.PosixFormat))
 		r.outf(format, "sys", elapsedString(0, x.PosixFormat))
+	case *syntax.CoprocClause:
+		// TODO: support coprocesses, which need the NAME array to hold
+		// the file descriptors of the coprocess.
+		r.errf("coproc: coprocesses are not supported\n")
+		r.exit = 1
+	case *syntax.TestDecl:
+		r.errf("@test: Bats tests are not supported\n")
+		r.exit = 1
+	case *syntax.BadStmt:
+		r.errf("%v\n", x.Err)
+		r.exit = 2
 	default:
 		panic(fmt.Sprintf("unhandled command node: %T", x))
 	}
@@ -605,8 +632,13 @@ func (r *Runner) stmts(ctx context.Context, stmts []*syntax.Stmt) {
 }
 
 func (r *Runner) hdocReader(rd *syntax.Redirect) io.Reader {
+	document := r.document
+	if rd.HdocQuoted {
+		// the body is taken literally, without any expansions
+		document = (*syntax.Word).Lit
+	}
 	if rd.Op != syntax.DashHdoc {
-		hdoc := r.document(rd.Hdoc)
+		hdoc := document(rd.Hdoc)
 		return strings.NewReader(hdoc)
 	}
 	var buf bytes.Buffer
@@ -615,7 +647,7 @@ func (r *Runner) hdocReader(rd *syntax.Redirect) io.Reader {
 		if buf.Len() > 0 {
 			buf.WriteByte('\n')
 		}
-		buf.WriteString(r.document(&syntax.Word{Parts: cur}))
+		buf.WriteString(document(&syntax.Word{Parts: cur}))
 		cur = cur[:0]
 	}
 	for _, wp := range rd.Hdoc.Parts {
@@ -648,6 +680,15 @@ func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, err
 		case "1":
 		case "2":
 			orig = &r.stderr
+		default:
+			if strings.HasPrefix(rd.N.Value, "{") {
+				// TODO: support {varname} redirects, which need
+				// a table of file descriptors to allocate from.
+				err := fmt.Errorf("%s: {varname} redirects "+
+					"are not supported", rd.N.Value)
+				r.errf("%v\n", err)
+				return nil, err
+			}
 		}
 	}
 	arg := r.literal(rd.Word)
@@ -663,8 +704,8 @@ func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, err
 			*orig = r.stderr
 		}
 		return nil, nil
-	case syntax.RdrIn, syntax.RdrOut, syntax.AppOut,
-		syntax.RdrAll, syntax.AppAll:
+	case syntax.RdrIn, syntax.RdrOut, syntax.AppOut, syntax.ClbOut,
+		syntax.RdrInOut, syntax.RdrAll, syntax.AppAll:
 		// done further below
 	// case syntax.DplIn:
 	default:
@@ -674,17 +715,20 @@ func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, err
 	switch rd.Op {
 	case syntax.AppOut, syntax.AppAll:
 		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
-	case syntax.RdrOut, syntax.RdrAll:
+	case syntax.RdrOut, syntax.ClbOut, syntax.RdrAll:
+		// noclobber isn't supported, so >| is the same as >
 		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case syntax.RdrInOut:
+		mode = os.O_RDWR | os.O_CREATE
 	}
 	f, err := r.open(ctx, arg, mode, 0o644, true)
 	if err != nil {
 		return nil, err
 	}
 	switch rd.Op {
-	case syntax.RdrIn:
+	case syntax.RdrIn, syntax.RdrInOut:
 		r.stdin = f
-	case syntax.RdrOut, syntax.AppOut:
+	case syntax.RdrOut, syntax.AppOut, syntax.ClbOut:
 		*orig = f
 	case syntax.RdrAll, syntax.AppAll:
 		r.stdout = f
@@ -713,6 +757,56 @@ func (r *Runner) loopStmtsBroken(ctx context.Context, stmts []*syntax.Stmt) bool
 	return false
 }
 
+// selectLoop runs the body of a select clause. The menu of items is printed to
+// standard error, and each line read from standard input picks an item by its
+// number, until the input ends or the loop is broken out of.
+func (r *Runner) selectLoop(ctx context.Context, name string, items []string, stmts []*syntax.Stmt) {
+	menu := true
+	for !r.stop(ctx) {
+		if menu {
+			for i, item := range items {
+				r.errf("%d) %s\n", i+1, item)
+			}
+			menu = false
+		}
+		prompt := "#? "
+		if vr := r.lookupVar("PS3"); vr.IsSet() {
+			prompt = vr.String()
+		}
+		r.errf("%s", prompt)
+		line, err := r.readLine(false)
+		if err != nil {
+			r.errf("\n")
+			r.exit = 1
+			return
+		}
+		reply := string(line)
+		r.setVarString("REPLY", reply)
+		if reply == "" {
+			// an empty line shows the menu again
+			menu = true
+			continue
+		}
+		item := ""
+		if n, err := strconv.Atoi(reply); err == nil && n > 0 && n <= len(items) {
+			item = items[n-1]
+		}
+		r.setVarString(name, item)
+		if r.loopStmtsBroken(ctx, stmts) {
+			break
+		}
+	}
+}
+
+func (r *Runner) caseMatches(ci *syntax.CaseItem, str string) bool {
+	for _, word := range ci.Patterns {
+		if match(r.pattern(word), str) {
+			return true
+		}
+	}
+	return false
+}
+
 type returnStatus uint8
 
 func (s returnStatus) Error() string { return fmt.Sprintf("return status %d", s) }
@@ -86,13 +86,14 @@ func (r *Runner) lookupVar(name string) expand.Variable {
 		} else {
 			vr.Str = "gosh"
 		}
-	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
-		vr.Kind = expand.String
-		i := int(name[0] - '1')
-		if i < len(r.Params) {
-			vr.Str = r.Params[i]
-		} else {
-			vr.Str = ""
+	default:
+		// positional parameters, including multi-digit ones like ${10}
+		if n, err := strconv.Atoi(name); err == nil && n > 0 &&
+			'0' <= name[0] && name[0] <= '9' {
+			vr.Kind = expand.String
+			if n <= len(r.Params) {
+				vr.Str = r.Params[n-1]
+			}
 		}
 	}
 	if vr.IsSet() {
@@ -168,15 +169,18 @@ func (r *Runner) setVarInternal(name string, vr expand.Variable) {
 
 func (r *Runner) setVar(name string, index syntax.ArithmExpr, vr expand.Variable) {
 	cur := r.lookupVar(name)
+	if name2, var2 := cur.Resolve(expandEnv{r}); name2 != "" {
+		name = name2
+		cur = var2
+		// the value takes the attributes of the referenced variable,
+		// not those of the nameref itself
+		vr.Local, vr.Exported = cur.Local, cur.Exported
+	}
 	if cur.ReadOnly {
 		r.errf("%s: readonly variable\n", name)
 		r.exit = 1
 		return
 	}
-	if name2, var2 := cur.Resolve(r.Env); name2 != "" {
-		name = name2
-		cur = var2
-	}
 
 	if vr.Kind == expand.String && index == nil {
 		// When assigning a string to an array, fall back to the
@@ -220,6 +224,15 @@ func (r *Runner) setVar(name string, index syntax.ArithmExpr, vr expand.Variable
 		return
 	}
 	k := r.arithm(index)
+	if k < 0 {
+		// negative indices count from the end of the array
+		k += len(list)
+		if k < 0 {
+			r.errf("%s: bad array subscript\n", name)
+			r.exit = 1
+			return
+		}
+	}
 	for len(list) < k+1 {
 		list = append(list, "")
 	}
@@ -251,10 +264,20 @@ func stringIndex(index syntax.ArithmExpr) bool {
 func (r *Runner) assignVal(as *syntax.Assign, valType string) expand.Variable {
 	prev := r.lookupVar(as.Name.Value)
 	if as.Naked {
+		if valType == "-A" && !prev.IsSet() {
+			// "declare -A name" makes an empty associative array
+			prev.Kind = expand.Associative
+		}
 		return prev
 	}
 	if as.Value != nil {
 		s := r.literal(as.Value)
+		if as.Append && as.Index != nil {
+			// a[i]+=s appends to the element, not to the array
+			pe := &syntax.ParamExp{Param: as.Name, Index: as.Index}
+			elem := r.literal(&syntax.Word{Parts: []syntax.WordPart{pe}})
+			return expand.Variable{Kind: expand.String, Str: elem + s}
+		}
 		if !as.Append || !prev.IsSet() {
 			prev.Kind = expand.String
 			if valType == "-n" {
@@ -288,7 +311,9 @@ func (r *Runner) assignVal(as *syntax.Assign, valType string) expand.Variable {
 	elems := as.Array.Elems
 	if valType == "" {
 		valType = "-a" // indexed
-		if len(elems) > 0 && stringIndex(elems[0].Index) {
+		if as.Append && prev.Kind == expand.Associative {
+			valType = "-A"
+		} else if len(elems) > 0 && stringIndex(elems[0].Index) {
 			valType = "-A" // associative
 		}
 	}
@@ -298,12 +323,17 @@ func (r *Runner) assignVal(as *syntax.Assign, valType string) expand.Variable {
 			k := r.literal(elem.Index.(*syntax.Word))
 			amap[k] = r.literal(elem.Value)
 		}
-		if !as.Append {
+		if !as.Append || prev.Kind != expand.Associative {
 			prev.Kind = expand.Associative
 			prev.Map = amap
 			return prev
 		}
-		// TODO
+		for k, v := range prev.Map {
+			if _, ok := amap[k]; !ok {
+				amap[k] = v
+			}
+		}
+		prev.Map = amap
 		return prev
 	}
 	maxIndex := len(elems) - 1
@@ -89,15 +89,6 @@ writeLoop:
 			}
 			buf.WriteString(regexp.QuoteMeta(string(pat[i])))
 		case '[':
-			name, err := charClass(pat[i:])
-			if err != nil {
-				return "", err
-			}
-			if name != "" {
-				buf.WriteString(name)
-				i += len(name) - 1
-				break
-			}
 			if mode&Filenames != 0 {
 				for _, c := range pat[i:] {
 					if c == ']' {
@@ -136,6 +127,18 @@ writeLoop:
 						buf.WriteByte(pat[i])
 					}
 					continue
+				case '[':
+					name, err := charClass(pat[i:])
+					if err != nil {
+						return "", err
+					}
+					if name != "" {
+						// "[" was already written
+						buf.WriteString(name[1:])
+						i += len(name) - 1
+						rangeStart = 0
+						continue
+					}
 				case ']':
 					break loopBracket
 				}
@@ -222,17 +225,21 @@ writeLoop:
 	return buf.String(), nil
 }
 
+// charClass returns the character class at the start of s, such as
+// "[:digit:]", if there is one. It is only meaningful within a bracket
+// expression, where "[:digit:]" may be combined with other characters, like in
+// "[_[:alpha:]]".
 func charClass(s string) (string, error) {
-	if strings.HasPrefix(s, "[[.") || strings.HasPrefix(s, "[[=") {
+	if strings.HasPrefix(s, "[.") || strings.HasPrefix(s, "[=") {
 		return "", fmt.Errorf("collating features not available")
 	}
-	if !strings.HasPrefix(s, "[[:") {
+	if !strings.HasPrefix(s, "[:") {
 		return "", nil
 	}
-	name := s[3:]
-	end := strings.Index(name, ":]]")
+	name := s[2:]
+	end := strings.Index(name, ":]")
 	if end < 0 {
-		return "", fmt.Errorf("[[: was not matched with a closing :]]")
+		return "", fmt.Errorf("[: was not matched with a closing :]")
 	}
 	name = name[:end]
 	switch name {
@@ -241,7 +248,7 @@ func charClass(s string) (string, error) {
 	default:
 		return "", fmt.Errorf("invalid character class: %q", name)
 	}
-	return s[:len(name)+6], nil
+	return s[:len(name)+4], nil
 }
 
 // HasMeta returns whether a string contains any unescaped pattern
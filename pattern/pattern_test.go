@@ -77,6 +77,10 @@ var translateTests = []struct {
 	{pat: `[^-a]`, want: `[^-a]`},
 	{pat: `[a-]`, want: `[a-]`},
 	{pat: `[[:digit:]]`, want: `[[:digit:]]`},
+	{pat: `[[:alpha:]_]*`, want: `[[:alpha:]_].*`},
+	{pat: `[![:digit:]]`, want: `[^[:digit:]]`},
+	{pat: `[a-c[:space:]x]`, want: `[a-c[:space:]x]`},
+	{pat: `[_[:digit`, wantErr: true},
 	{pat: `[[:`, wantErr: true},
 	{pat: `[[:digit`, wantErr: true},
 	{pat: `[[:wrong:]]`, wantErr: true},
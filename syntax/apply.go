@@ -0,0 +1,226 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import "reflect"
+
+// An ApplyFunc is invoked by Apply for each node, before or after its
+// children are traversed. See Apply for the meaning of the return value.
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses a syntax tree recursively, starting with root, and calling
+// pre and post for each node with a Cursor describing it, which can be used to
+// modify the tree as it's being traversed. The children of each node are
+// traversed in the order of their fields. Either of pre and post may be nil.
+//
+// If pre returns false, no children are traversed, and post is not called for
+// that node. If post returns false, the traversal is stopped, and Apply
+// returns immediately.
+//
+// Only the nodes which were in the tree before the traversal reached them are
+// traversed. Nodes added with Replace, InsertBefore or InsertAfter are not,
+// with the exception of the children of a node added with Replace in pre.
+//
+// Apply returns the root node, which may have been replaced.
+func Apply(root Node, pre, post ApplyFunc) (result Node) {
+	a := &application{pre: pre, post: post}
+	defer func() {
+		if r := recover(); r != nil && r != errAbortApply {
+			panic(r)
+		}
+		result = root
+	}()
+	if root != nil {
+		a.apply(nil, "", reflect.ValueOf(&root).Elem(), nil, root)
+	}
+	return root
+}
+
+// errAbortApply is used to stop Apply when post returns false.
+var errAbortApply = new(int)
+
+// A Cursor describes a node encountered during Apply. Information about the
+// node and its parent is available from the Node, Parent, Name, and Index
+// methods.
+//
+// Its methods may only be called within the pre or post function which was
+// given the cursor.
+type Cursor struct {
+	parent Node
+	name   string
+	field  reflect.Value // the field holding node, or the slice it's in
+	iter   *iterator     // valid if field is a slice
+	node   Node
+}
+
+type iterator struct {
+	index, step int
+	deleted     bool // the node at index was deleted
+}
+
+// Node returns the current node.
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the parent of the current node, or nil for the root node.
+func (c *Cursor) Parent() Node { return c.parent }
+
+// Name returns the name of the field holding the current node, such as "Cmd"
+// for the command in a Stmt. Fields of structs which aren't nodes are named
+// after their own field, so the offset in "${a:b}" is named "Offset", and its
+// parent is the ParamExp. The name is empty for the root node.
+func (c *Cursor) Name() string { return c.name }
+
+// Index returns the index of the current node within the slice of its parent,
+// such as the index of a statement in a Block's Stmts. If the node isn't part
+// of a slice, Index returns a negative value.
+func (c *Cursor) Index() int {
+	if c.iter != nil {
+		return c.iter.index
+	}
+	return -1
+}
+
+// Replace replaces the current node with n. The replacement isn't traversed,
+// but its children are if Replace is called from pre.
+//
+// Replace panics if n can't be stored where the current node is, such as a
+// *Word replacing a *Stmt.
+func (c *Cursor) Replace(n Node) {
+	if c.iter != nil {
+		c.field.Index(c.iter.index).Set(c.value(n))
+	} else {
+		c.field.Set(c.value(n))
+	}
+	c.node = n
+}
+
+// Delete deletes the current node from the slice containing it, such as a
+// statement from a list of statements. The following node in the slice is
+// still traversed. If called from pre, the deleted node's children aren't
+// traversed, and post is called with a nil node.
+//
+// Delete panics if the current node isn't part of a slice.
+func (c *Cursor) Delete() {
+	i := c.sliceIndex("Delete")
+	l := c.field.Len()
+	reflect.Copy(c.field.Slice(i, l), c.field.Slice(i+1, l))
+	c.field.Index(l - 1).Set(reflect.Zero(c.field.Type().Elem()))
+	c.field.SetLen(l - 1)
+	c.iter.step--
+	c.iter.deleted = true
+	c.node = nil
+}
+
+// InsertAfter inserts n after the current node in the slice containing it.
+// If the current node was deleted, n is inserted where it was. The inserted
+// node isn't traversed.
+//
+// InsertAfter panics if the current node isn't part of a slice.
+func (c *Cursor) InsertAfter(n Node) {
+	i := c.sliceIndex("InsertAfter") + 1
+	if c.iter.deleted {
+		i-- // the following node is at index already
+	}
+	c.insert(i, n)
+	c.iter.step++
+}
+
+// InsertBefore inserts n before the current node in the slice containing it.
+// The inserted node isn't traversed.
+//
+// InsertBefore panics if the current node isn't part of a slice.
+func (c *Cursor) InsertBefore(n Node) {
+	c.insert(c.sliceIndex("InsertBefore"), n)
+	c.iter.index++
+}
+
+func (c *Cursor) sliceIndex(method string) int {
+	if c.iter == nil {
+		panic("syntax: Cursor." + method + " on a node which isn't part of a slice")
+	}
+	return c.iter.index
+}
+
+func (c *Cursor) insert(i int, n Node) {
+	l := c.field.Len()
+	c.field.Set(reflect.Append(c.field, reflect.Zero(c.field.Type().Elem())))
+	reflect.Copy(c.field.Slice(i+1, l+1), c.field.Slice(i, l))
+	c.field.Index(i).Set(c.value(n))
+}
+
+// value returns the value to store n in the field, or in an element of it if
+// it's a slice. Comments are stored in slices as values, not pointers.
+func (c *Cursor) value(n Node) reflect.Value {
+	typ := c.field.Type()
+	if c.iter != nil {
+		typ = typ.Elem()
+	}
+	if n == nil {
+		return reflect.Zero(typ)
+	}
+	v := reflect.ValueOf(n)
+	if typ.Kind() == reflect.Struct {
+		v = v.Elem()
+	}
+	return v
+}
+
+type application struct {
+	pre, post ApplyFunc
+	cursor    Cursor
+}
+
+func (a *application) apply(parent Node, name string, field reflect.Value, iter *iterator, n Node) {
+	saved := a.cursor
+	a.cursor = Cursor{parent: parent, name: name, field: field, iter: iter, node: n}
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.cursor = saved
+		return
+	}
+	if n := a.cursor.node; n != nil && !reflect.ValueOf(n).IsNil() {
+		a.fields(n, reflect.ValueOf(n).Elem())
+	}
+	if a.post != nil && !a.post(&a.cursor) {
+		panic(errAbortApply)
+	}
+	a.cursor = saved
+}
+
+// fields traverses the nodes held by the fields of the struct x, which is
+// either the parent node itself, or a struct within it.
+func (a *application) fields(parent Node, x reflect.Value) {
+	for i := 0; i < x.NumField(); i++ {
+		if x.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		name, f := x.Type().Field(i).Name, x.Field(i)
+		switch f.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			if f.IsNil() {
+				continue
+			}
+			if n, ok := f.Interface().(Node); ok {
+				a.apply(parent, name, f, nil, n)
+			} else if f.Kind() == reflect.Ptr && f.Elem().Kind() == reflect.Struct {
+				a.fields(parent, f.Elem()) // like ParamExp.Slice
+			}
+		case reflect.Struct:
+			if f.Type() != posType {
+				a.fields(parent, f)
+			}
+		case reflect.Slice:
+			iter := &iterator{}
+			for iter.index = 0; iter.index < f.Len(); iter.index += iter.step {
+				iter.step, iter.deleted = 1, false
+				el := f.Index(iter.index)
+				if el.Kind() == reflect.Struct {
+					el = el.Addr() // like Comment
+				}
+				if n, ok := el.Interface().(Node); ok && !el.IsNil() {
+					a.apply(parent, name, f, iter, n)
+				}
+			}
+		}
+	}
+}
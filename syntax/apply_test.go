@@ -0,0 +1,236 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyReachesAllNodes(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	for i, c := range fileTests {
+		in := c.Strs[0]
+		f, err := parser.Parse(strings.NewReader(in), "")
+		if err != nil {
+			continue // not valid Bash
+		}
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			want := make(map[Node]bool)
+			reachableNodes(reflect.ValueOf(f), want)
+			Apply(f, func(c *Cursor) bool {
+				if c.Parent() == nil && c.Node() != f {
+					t.Errorf("Unexpected nil parent for %T", c.Node())
+				}
+				delete(want, c.Node())
+				return true
+			}, nil)
+			for node := range want {
+				t.Errorf("Apply did not reach %T at %s in %q", node, node.Pos(), in)
+			}
+		})
+	}
+}
+
+var applyTests = []struct {
+	in, want  string
+	pre, post ApplyFunc
+}{
+	{
+		"foo\nbar\nbaz",
+		"foo\nbaz",
+		func(c *Cursor) bool {
+			if s, ok := c.Node().(*Stmt); ok && s.Cmd.(*CallExpr).Args[0].Lit() == "bar" {
+				c.Delete()
+			}
+			return true
+		},
+		nil,
+	},
+	{
+		"foo\nbar\nbaz",
+		"foo\nbefore\nbar\nafter\nbaz",
+		func(c *Cursor) bool {
+			if s, ok := c.Node().(*Stmt); ok && s.Cmd.(*CallExpr).Args[0].Lit() == "bar" {
+				c.InsertBefore(litStmt("before"))
+				c.InsertAfter(litStmt("after"))
+			}
+			return true
+		},
+		nil,
+	},
+	{
+		"if foo; then bar; fi\nbar",
+		"if foo; then\n\tbar\n\tbar\nfi\nbar\nbar",
+		nil,
+		func(c *Cursor) bool {
+			s, ok := c.Node().(*Stmt)
+			if !ok {
+				return true
+			}
+			if call, ok := s.Cmd.(*CallExpr); ok && call.Args[0].Lit() == "bar" {
+				c.InsertAfter(litStmt("bar"))
+			}
+			return true
+		},
+	},
+	{
+		"echo $foo ${foo:-$foo} \"$foo\"",
+		"echo $bar ${bar:-$bar} \"$bar\"",
+		func(c *Cursor) bool {
+			if pe, ok := c.Node().(*ParamExp); ok && pe.Param.Value == "foo" {
+				c.Replace(&ParamExp{
+					Short: pe.Short,
+					Param: &Lit{Value: "bar"},
+					Exp:   pe.Exp,
+				})
+			}
+			return true
+		},
+		nil,
+	},
+	{
+		"foo a b c",
+		"foo a c",
+		func(c *Cursor) bool {
+			if w, ok := c.Node().(*Word); ok && c.Name() == "Args" && w.Lit() == "b" {
+				if c.Index() != 2 {
+					panic(fmt.Sprintf("unexpected index %d", c.Index()))
+				}
+				c.Delete()
+			}
+			return true
+		},
+		nil,
+	},
+	{
+		"a; b; c; d",
+		"a; b; z; visited",
+		func(c *Cursor) bool {
+			if w, ok := c.Node().(*Word); ok && w.Lit() == "d" {
+				c.Replace(litWord("visited"))
+			}
+			s, ok := c.Node().(*Stmt)
+			if !ok {
+				return true
+			}
+			switch s.Cmd.(*CallExpr).Args[0].Lit() {
+			case "c":
+				c.Delete()
+				c.InsertAfter(litStmt("z"))
+			case "z":
+				panic("an inserted node was traversed")
+			}
+			return true
+		},
+		nil,
+	},
+	{
+		"# foo\nfoo # bar",
+		"# changed\nfoo # changed",
+		func(c *Cursor) bool {
+			if _, ok := c.Node().(*Comment); ok {
+				c.Replace(&Comment{Text: " changed"})
+			}
+			return true
+		},
+		nil,
+	},
+	{
+		"foo\nbar\nbaz",
+		"foo\nchanged\nbaz",
+		nil,
+		func(c *Cursor) bool {
+			if w, ok := c.Node().(*Word); ok && w.Lit() == "bar" {
+				c.Replace(litWord("changed"))
+				return false
+			}
+			if w, ok := c.Node().(*Word); ok && w.Lit() == "baz" {
+				panic("traversal was not stopped")
+			}
+			return true
+		},
+	},
+	{
+		"foo; bar",
+		"foo; bar",
+		func(c *Cursor) bool {
+			_, ok := c.Node().(*CallExpr)
+			return !ok
+		},
+		func(c *Cursor) bool {
+			if _, ok := c.Node().(*Word); ok {
+				panic("the children of a node were traversed")
+			}
+			return true
+		},
+	},
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	printer := NewPrinter()
+	for i, tc := range applyTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Apply(f, tc.pre, tc.post); got != f {
+				t.Fatalf("Unexpected root: %v", got)
+			}
+			var sb strings.Builder
+			if err := printer.Print(&sb, f); err != nil {
+				t.Fatal(err)
+			}
+			// new nodes have no positions, so ignore them
+			want, err := parser.Parse(strings.NewReader(tc.want), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Equal(f, want) {
+				t.Fatalf("Apply mismatch in %q:\nwant %q\ngot  %q", tc.in, tc.want, sb.String())
+			}
+		})
+	}
+}
+
+func TestApplyRoot(t *testing.T) {
+	t.Parallel()
+	root := litWord("foo")
+	got := Apply(root, func(c *Cursor) bool {
+		if _, ok := c.Node().(*Word); ok {
+			if c.Parent() != nil || c.Name() != "" || c.Index() >= 0 {
+				t.Fatalf("Unexpected cursor for the root: %q %d", c.Name(), c.Index())
+			}
+			c.Replace(litWord("bar"))
+		}
+		return true
+	}, nil)
+	if got.(*Word).Lit() != "bar" {
+		t.Fatalf("Unexpected root: %v", got)
+	}
+	if root.Lit() != "foo" {
+		t.Fatalf("Replacing the root modified it")
+	}
+	if Apply(nil, nil, nil) != nil {
+		t.Fatalf("Apply of nil is not nil")
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "Delete") {
+			t.Fatalf("Expected a Delete panic, got %v", r)
+		}
+	}()
+	Apply(litStmt("foo"), func(c *Cursor) bool {
+		if _, ok := c.Node().(*CallExpr); ok {
+			c.Delete()
+		}
+		return true
+	}, nil)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import "sort"
+
+// A CallGraph describes which of the functions declared in a file call which,
+// as built by NewCallGraph. Functions are identified by name.
+type CallGraph struct {
+	// Funcs holds the functions declared in the file. If a function is
+	// declared more than once, the last declaration is kept, but the calls
+	// made by all of them are recorded.
+	Funcs map[string]*FuncDecl
+
+	// Calls holds the declared functions called by each function, sorted
+	// by name. Calls to other commands, like builtins, aren't recorded.
+	Calls map[string][]string
+
+	// roots holds the functions called by the code outside of any function.
+	roots []string
+}
+
+// NewCallGraph builds the call graph of the functions declared in a file.
+//
+// A function calls another if any statement within its body calls it by name,
+// as found by QueryCalls. This includes calls within command substitutions and
+// nested statements, but not those within nested function declarations, which
+// are functions of their own. Calls made indirectly, such as in
+// "trap 'cleanup' EXIT" or "$cmd", aren't found.
+func NewCallGraph(f *File) *CallGraph {
+	g := &CallGraph{
+		Funcs: make(map[string]*FuncDecl),
+		Calls: make(map[string][]string),
+	}
+	Walk(f, func(node Node) bool {
+		if fd, ok := node.(*FuncDecl); ok {
+			g.Funcs[fd.Name.Value] = fd
+		}
+		return true
+	})
+	calls := make(map[string]map[string]bool)
+	var visit func(node Node, caller string)
+	visit = func(node Node, caller string) {
+		Walk(node, func(node Node) bool {
+			switch x := node.(type) {
+			case *FuncDecl:
+				visit(x.Body, x.Name.Value)
+				return false
+			case *Stmt:
+				name, ok := callName(x)
+				if !ok || g.Funcs[name] == nil {
+					break
+				}
+				if calls[caller] == nil {
+					calls[caller] = make(map[string]bool)
+				}
+				calls[caller][name] = true
+			}
+			return true
+		})
+	}
+	visit(f, "")
+	for caller, callees := range calls {
+		list := make([]string, 0, len(callees))
+		for name := range callees {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		if caller == "" {
+			g.roots = list
+		} else {
+			g.Calls[caller] = list
+		}
+	}
+	return g
+}
+
+// Roots returns the functions called by the code outside of any function,
+// which are the entry points of the program, sorted by name.
+func (g *CallGraph) Roots() []string { return g.roots }
+
+// Unreachable returns the functions which are never called, directly or
+// indirectly, by the code outside of any function, sorted by name. They are
+// likely dead code, unless they are called indirectly, such as via trap.
+func (g *CallGraph) Unreachable() []string {
+	reached := make(map[string]bool)
+	var reach func(name string)
+	reach = func(name string) {
+		if reached[name] {
+			return
+		}
+		reached[name] = true
+		for _, callee := range g.Calls[name] {
+			reach(callee)
+		}
+	}
+	for _, name := range g.roots {
+		reach(name)
+	}
+	var list []string
+	for name := range g.Funcs {
+		if !reached[name] {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Cycles returns the groups of functions which are recursive, as each of them
+// may end up calling itself. That is, a function calling itself, or functions
+// calling each other in a cycle, like "a" calling "b" and "b" calling "a".
+// Each group is sorted by name, and the groups are sorted by their first name.
+func (g *CallGraph) Cycles() [][]string {
+	// Tarjan's algorithm for strongly connected components.
+	var (
+		index   = make(map[string]int)
+		lowlink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		cycles  [][]string
+	)
+	var connect func(name string)
+	connect = func(name string) {
+		index[name] = len(index)
+		lowlink[name] = index[name]
+		stack = append(stack, name)
+		onStack[name] = true
+		selfCall := false
+		for _, callee := range g.Calls[name] {
+			if callee == name {
+				selfCall = true
+			}
+			if _, ok := index[callee]; !ok {
+				connect(callee)
+				if lowlink[callee] < lowlink[name] {
+					lowlink[name] = lowlink[callee]
+				}
+			} else if onStack[callee] && index[callee] < lowlink[name] {
+				lowlink[name] = index[callee]
+			}
+		}
+		if lowlink[name] != index[name] {
+			return
+		}
+		var group []string
+		for {
+			last := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[last] = false
+			group = append(group, last)
+			if last == name {
+				break
+			}
+		}
+		if len(group) > 1 || selfCall {
+			sort.Strings(group)
+			cycles = append(cycles, group)
+		}
+	}
+	names := make([]string, 0, len(g.Funcs))
+	for name := range g.Funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := index[name]; !ok {
+			connect(name)
+		}
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i][0] < cycles[j][0] })
+	return cycles
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCallGraph(t *testing.T) {
+	t.Parallel()
+	in := `
+main() {
+	x=$(parse "$@")
+	run || usage
+}
+parse() { echo "$1"; }
+run() {
+	helper() { run; }
+	helper
+	'log' running
+}
+log() { echo "$@"; }
+usage() { log usage; }
+fact() { fact $(($1 - 1)); }
+ping() { pong; }
+pong() { ping; }
+unused() { main; }
+trap cleanup EXIT
+cleanup() { :; }
+main "$@"
+echo done
+`
+	f, err := NewParser().Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewCallGraph(f)
+	if len(g.Funcs) != 11 || g.Funcs["helper"] == nil {
+		t.Fatalf("Unexpected functions: %v", g.Funcs)
+	}
+	wantCalls := map[string][]string{
+		"main":   {"parse", "run", "usage"},
+		"run":    {"helper", "log"},
+		"helper": {"run"},
+		"usage":  {"log"},
+		"fact":   {"fact"},
+		"ping":   {"pong"},
+		"pong":   {"ping"},
+		"unused": {"main"},
+	}
+	if !reflect.DeepEqual(g.Calls, wantCalls) {
+		t.Fatalf("Unexpected calls:\nwant %v\ngot  %v", wantCalls, g.Calls)
+	}
+	if want := []string{"main"}; !reflect.DeepEqual(g.Roots(), want) {
+		t.Fatalf("Unexpected roots: want %q, got %q", want, g.Roots())
+	}
+	want := []string{"cleanup", "fact", "ping", "pong", "unused"}
+	if got := g.Unreachable(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected unreachable functions: want %q, got %q", want, got)
+	}
+	wantCycles := [][]string{{"fact"}, {"helper", "run"}, {"ping", "pong"}}
+	if got := g.Cycles(); !reflect.DeepEqual(got, wantCycles) {
+		t.Fatalf("Unexpected cycles: want %q, got %q", wantCycles, got)
+	}
+
+	g = NewCallGraph(&File{})
+	if len(g.Funcs) != 0 || g.Roots() != nil || g.Unreachable() != nil || g.Cycles() != nil {
+		t.Fatalf("Unexpected call graph for an empty file: %v", g)
+	}
+}
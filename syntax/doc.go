@@ -2,5 +2,11 @@
 // See LICENSE for licensing information
 
 // Package syntax implements parsing and formatting of shell programs.
-// It supports POSIX Shell, Bash, and mksh.
+// It supports POSIX Shell, Bash, mksh, Bats and BusyBox's ash.
+//
+// It holds what is needed to work with shell source code: the tokens and
+// the nodes of the syntax tree, the Parser and the Printer, and helpers such
+// as Walk and Simplify. It doesn't depend on the packages that give meaning
+// to the syntax, like expand and interp, so that tools such as formatters and
+// linters can use it on its own.
 package syntax
@@ -0,0 +1,247 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// InsertStmtBefore inserts s before target, in the list of statements
+// containing target within root. An error is returned if target isn't found
+// in a list of statements, like when it's the body of a function.
+//
+// If s has no positions, such as when it was built by hand, it's given
+// positions on a new line before target, and the lines of the nodes after it
+// are shifted, so that printing root places s on its own line. If root is a
+// File, the line offsets used by its OffsetPos and LineStart methods are
+// shifted as well.
+func InsertStmtBefore(root Node, target, s *Stmt) error {
+	c, err := findStmt(root, target, true)
+	if err != nil {
+		return err
+	}
+	if start, _ := stmtRange(target); !s.Pos().IsValid() && start.IsValid() {
+		shiftLines(root, start.offs, 1)
+		setPos(s, Pos{offs: start.offs, line: start.line, col: 1})
+	}
+	c.insert(c.iter.index, s)
+	return nil
+}
+
+// InsertStmtAfter is like InsertStmtBefore, but inserts s after target.
+func InsertStmtAfter(root Node, target, s *Stmt) error {
+	c, err := findStmt(root, target, true)
+	if err != nil {
+		return err
+	}
+	if _, end := stmtRange(target); !s.Pos().IsValid() && end.IsValid() {
+		shiftLines(root, end.offs+1, 1)
+		setPos(s, Pos{offs: end.offs, line: end.line + 1, col: 1})
+	}
+	c.insert(c.iter.index+1, s)
+	return nil
+}
+
+// DeleteStmt deletes target from the list of statements containing it within
+// root. An error is returned if target isn't found in a list of statements.
+//
+// If the statement was on lines of its own, the lines of the nodes after it
+// are shifted back, so that printing root doesn't leave an empty line in its
+// place. As with InsertStmtBefore, so are a File's line offsets. If the list
+// would become empty where that's not valid, such as in the body of an if
+// clause, target is replaced with a ":" command instead.
+func DeleteStmt(root Node, target *Stmt) error {
+	c, err := findStmt(root, target, true)
+	if err != nil {
+		return err
+	}
+	i, l := c.iter.index, c.field.Len()
+	if l == 1 {
+		switch c.parent.(type) {
+		case *File, *CmdSubst, *ProcSubst, *CaseItem:
+		default:
+			pos := target.Position
+			lit := &Lit{ValuePos: pos, ValueEnd: posAddCol(pos, 1), Value: ":"}
+			c.Replace(&Stmt{
+				Position: pos,
+				Cmd:      &CallExpr{Args: []*Word{{Parts: []WordPart{lit}}}},
+			})
+			return nil
+		}
+	}
+	start, end := stmtRange(target)
+	ownLines := start.IsValid()
+	if i > 0 {
+		if _, prevEnd := stmtRange(c.field.Index(i - 1).Interface().(*Stmt)); prevEnd.Line() >= start.Line() {
+			ownLines = false
+		}
+	}
+	if i+1 < l {
+		if nextStart, _ := stmtRange(c.field.Index(i + 1).Interface().(*Stmt)); nextStart.Line() <= end.Line() {
+			ownLines = false
+		}
+	}
+	c.Delete()
+	if ownLines {
+		shiftLines(root, end.offs+1, -int(end.Line()-start.Line()+1))
+	}
+	return nil
+}
+
+// ReplaceStmt replaces target with s within root, wherever target is. The
+// positions missing in s are set to the position of target, so that printing
+// root places s where target was. This can be used along with WrapStmtBlock
+// and the functions like it to wrap a statement in place.
+func ReplaceStmt(root Node, target, s *Stmt) error {
+	c, err := findStmt(root, target, false)
+	if err != nil {
+		return err
+	}
+	setPos(s, target.Pos())
+	c.Replace(s)
+	return nil
+}
+
+// WrapStmtBlock returns a statement which runs s within a block, as in
+// "{ s; }". The comments of s are moved to the new statement, so that they
+// stay outside of the block, and so is the semicolon ending s, if any.
+func WrapStmtBlock(s *Stmt) *Stmt {
+	return wrapStmt(s, &Block{Stmts: []*Stmt{s}})
+}
+
+// WrapStmtSubshell is like WrapStmtBlock, but returns a subshell, as in
+// "( s )".
+func WrapStmtSubshell(s *Stmt) *Stmt {
+	return wrapStmt(s, &Subshell{Stmts: []*Stmt{s}})
+}
+
+// WrapStmtIf is like WrapStmtBlock, but returns an if clause running s if
+// the cond statement succeeds, as in "if cond; then s; fi".
+func WrapStmtIf(cond, s *Stmt) *Stmt {
+	return wrapStmt(s, &IfClause{Cond: []*Stmt{cond}, Then: []*Stmt{s}})
+}
+
+func wrapStmt(s *Stmt, cmd Command) *Stmt {
+	wrap := &Stmt{Comments: s.Comments, Cmd: cmd}
+	s.Comments = nil
+	if !s.Background && !s.Coprocess {
+		wrap.Semicolon, s.Semicolon = s.Semicolon, Pos{}
+	}
+	return wrap
+}
+
+// findStmt returns a cursor for target within root. If inList is true, target
+// must be part of a list of statements.
+func findStmt(root Node, target *Stmt, inList bool) (*Cursor, error) {
+	var found *Cursor
+	Apply(root, func(c *Cursor) bool {
+		if c.Node() == target {
+			found = &Cursor{}
+			*found = *c
+			if c.iter != nil {
+				iter := *c.iter
+				found.iter = &iter
+			}
+			return false
+		}
+		return true
+	}, func(c *Cursor) bool {
+		return found == nil
+	})
+	switch {
+	case found == nil:
+		return nil, fmt.Errorf("statement at %s not found", target.Pos())
+	case inList && found.iter == nil:
+		return nil, fmt.Errorf("statement at %s is not part of a list of statements", target.Pos())
+	}
+	return found, nil
+}
+
+// stmtRange returns the start and end of a statement, including its comments
+// and heredocs. They are not valid if the statement has no positions.
+func stmtRange(s *Stmt) (start, end Pos) {
+	Walk(s, func(node Node) bool {
+		if node == nil {
+			return true
+		}
+		if pos := node.Pos(); pos.IsValid() && (!start.IsValid() || start.After(pos)) {
+			start = pos
+		}
+		if pos := node.End(); pos.After(end) {
+			end = pos
+		}
+		return true
+	})
+	return start, end
+}
+
+// shiftLines adds delta to the line of every position in a syntax tree which is
+// at or after the offset from. If node is a File, its line offsets are updated
+// too, so that OffsetPos keeps agreeing with the lines of the positions.
+func shiftLines(node Node, from uint32, delta int) {
+	mapPos(reflect.ValueOf(node), func(p Pos) Pos {
+		if p.IsValid() && p.offs >= from {
+			p.line = uint16(int(p.line) + delta)
+		}
+		return p
+	})
+	f, ok := node.(*File)
+	if !ok {
+		return
+	}
+	// the number of lines starting at or before from
+	i := sort.Search(len(f.lines), func(i int) bool {
+		return f.lines[i] > from
+	})
+	if delta > 0 {
+		// the new lines are empty, all starting at from
+		added := make([]uint32, delta)
+		for j := range added {
+			added[j] = from
+		}
+		f.lines = append(f.lines[:i], append(added, f.lines[i:]...)...)
+		return
+	}
+	// the lines removed are the last ones starting at or before from
+	j := i + delta
+	if j < 0 {
+		j = 0
+	}
+	f.lines = append(f.lines[:j], f.lines[i:]...)
+}
+
+// setPos sets every position in a syntax tree which isn't valid to pos.
+func setPos(node Node, pos Pos) {
+	mapPos(reflect.ValueOf(node), func(p Pos) Pos {
+		if !p.IsValid() {
+			return pos
+		}
+		return p
+	})
+}
+
+func mapPos(x reflect.Value, fn func(Pos) Pos) {
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !x.IsNil() && (x.Kind() == reflect.Ptr || x.Elem().Type().Implements(nodeType)) {
+			mapPos(x.Elem(), fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < x.Len(); i++ {
+			mapPos(x.Index(i), fn)
+		}
+	case reflect.Struct:
+		if x.Type() == posType {
+			x.Set(reflect.ValueOf(fn(x.Interface().(Pos))))
+			return
+		}
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).PkgPath == "" {
+				mapPos(x.Field(i), fn)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var editStmtTests = []struct {
+	in   string
+	edit func(root Node, target *Stmt) error
+	want string
+}{
+	{
+		"a\nb\nc",
+		func(root Node, target *Stmt) error { return InsertStmtBefore(root, target, litStmt("new")) },
+		"a\nnew\nb\nc\n",
+	},
+	{
+		"a # c1\n\n# doc\nb # c2\nc",
+		func(root Node, target *Stmt) error { return InsertStmtBefore(root, target, litStmt("new")) },
+		"a # c1\n\nnew\n# doc\nb # c2\nc\n",
+	},
+	{
+		"if x; then\n\ta\n\tb\nfi",
+		func(root Node, target *Stmt) error { return InsertStmtAfter(root, target, litStmt("new")) },
+		"if x; then\n\ta\n\tb\n\tnew\nfi\n",
+	},
+	{
+		"a\nb <<EOF\nfoo\nEOF\nc",
+		func(root Node, target *Stmt) error { return InsertStmtAfter(root, target, litStmt("new")) },
+		"a\nb <<EOF\nfoo\nEOF\nnew\nc\n",
+	},
+	{
+		"a; b; c",
+		func(root Node, target *Stmt) error { return InsertStmtAfter(root, target, litStmt("new")) },
+		"a\nb\nnew\nc\n",
+	},
+	{
+		"a\nb\nc",
+		DeleteStmt,
+		"a\nc\n",
+	},
+	{
+		"a\n\n# doc\nb <<EOF\nfoo\nEOF\n\nc",
+		DeleteStmt,
+		"a\n\nc\n",
+	},
+	{
+		"{\n\ta; b\n\tc\n}",
+		DeleteStmt,
+		"{\n\ta\n\tc\n}\n",
+	},
+	{
+		"if x; then\n\tb\nfi",
+		DeleteStmt,
+		"if x; then\n\t:\nfi\n",
+	},
+	{
+		"x=$(b)",
+		DeleteStmt,
+		"x=$()\n",
+	},
+	{
+		"a\n# doc\nb # c\nc",
+		func(root Node, target *Stmt) error { return ReplaceStmt(root, target, WrapStmtBlock(target)) },
+		"a\n# doc\n{ b; } # c\nc\n",
+	},
+	{
+		"a\nb &\nc",
+		func(root Node, target *Stmt) error { return ReplaceStmt(root, target, WrapStmtSubshell(target)) },
+		"a\n(b &)\nc\n",
+	},
+	{
+		"a && b",
+		func(root Node, target *Stmt) error {
+			return ReplaceStmt(root, target, WrapStmtIf(litStmt("cond"), target))
+		},
+		"a && if cond; then b; fi\n",
+	},
+	{
+		"a\nb <<EOF\nfoo\nEOF",
+		func(root Node, target *Stmt) error { return ReplaceStmt(root, target, WrapStmtBlock(target)) },
+		"a\n{ b <<EOF; }\nfoo\nEOF\n",
+	},
+}
+
+// findLitStmt returns the last statement in a file calling the command name.
+func findLitStmt(f *File, name string) *Stmt {
+	var found *Stmt
+	Walk(f, func(node Node) bool {
+		if s, ok := node.(*Stmt); ok {
+			if call, ok := s.Cmd.(*CallExpr); ok && len(call.Args) > 0 && call.Args[0].Lit() == name {
+				found = s
+			}
+		}
+		return true
+	})
+	return found
+}
+
+func TestEditStmt(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	printer := NewPrinter()
+	for i, tc := range editStmtTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := findLitStmt(f, "b")
+			parsed := make(map[Node]bool)
+			Walk(f, func(node Node) bool {
+				parsed[node] = true
+				return true
+			})
+			if err := tc.edit(f, target); err != nil {
+				t.Fatal(err)
+			}
+			// the nodes left from the parser still agree with OffsetPos
+			Walk(f, func(node Node) bool {
+				if node != nil && parsed[node] && node.Pos().IsValid() {
+					if pos := f.OffsetPos(node.Pos().Offset()); pos.Line() != node.Pos().Line() {
+						t.Errorf("OffsetPos of %T at %s gives line %d", node, node.Pos(), pos.Line())
+					}
+				}
+				return true
+			})
+			var sb strings.Builder
+			if err := printer.Print(&sb, f); err != nil {
+				t.Fatal(err)
+			}
+			if got := sb.String(); got != tc.want {
+				t.Fatalf("Edit mismatch in %q:\nwant %q\ngot  %q", tc.in, tc.want, got)
+			}
+			if _, err := parser.Parse(strings.NewReader(sb.String()), ""); err != nil {
+				t.Fatalf("Edit resulted in invalid shell: %v", err)
+			}
+		})
+	}
+}
+
+func TestEditStmtError(t *testing.T) {
+	t.Parallel()
+	f, err := NewParser().Parse(strings.NewReader("a && b\nf() { c; }"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "statement at 1:6 is not part of a list of statements"
+	if err := DeleteStmt(f, findLitStmt(f, "b")); err == nil || err.Error() != want {
+		t.Fatalf("Unexpected error: want %q, got %v", want, err)
+	}
+	if err := InsertStmtAfter(f, f.Stmts[1].Cmd.(*FuncDecl).Body, litStmt("new")); err == nil {
+		t.Fatalf("Expected an error inserting next to a function body")
+	}
+	want = "statement at 0:0 not found"
+	if err := ReplaceStmt(f, litStmt("x"), litStmt("y")); err == nil || err.Error() != want {
+		t.Fatalf("Unexpected error: want %q, got %v", want, err)
+	}
+}
@@ -97,6 +97,45 @@ func ExampleNewParser_options() {
 	// for ((i = 0; i < 5; i++)); do echo $i > f; done
 }
 
+func ExampleParserOption() {
+	src := "echo foo # bar\n$$ not shell"
+
+	p := syntax.NewParser(
+		syntax.Variant(syntax.LangPOSIX),
+		syntax.StopAt("$$"),
+	)
+	// options can also be applied to an existing parser
+	syntax.KeepComments(true)(p)
+
+	f, err := p.Parse(strings.NewReader(src), "")
+	if err != nil {
+		return
+	}
+	syntax.NewPrinter().Print(os.Stdout, f)
+	// Output:
+	// echo foo # bar
+}
+
+func ExampleKeepComments() {
+	src := "# header\nfoo # trailing\n# before bar\nbar\n"
+	f, err := syntax.NewParser(syntax.KeepComments(true)).Parse(strings.NewReader(src), "")
+	if err != nil {
+		return
+	}
+	syntax.Walk(f, func(node syntax.Node) bool {
+		if x, ok := node.(*syntax.Lit); ok {
+			x.Value = strings.ToUpper(x.Value)
+		}
+		return true
+	})
+	syntax.NewPrinter().Print(os.Stdout, f)
+	// Output:
+	// # header
+	// FOO # trailing
+	// # before bar
+	// BAR
+}
+
 func ExampleWalk() {
 	in := strings.NewReader(`echo $foo "and $bar"`)
 	f, err := syntax.NewParser().Parse(in, "")
@@ -114,6 +153,25 @@ func ExampleWalk() {
 	// Output: echo $FOO "and $BAR"
 }
 
+func ExamplePos() {
+	src := "echo 'héllo'\nfoo=$((1 + 2)) bar\n"
+	f, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		return
+	}
+	syntax.Walk(f, func(node syntax.Node) bool {
+		switch node.(type) {
+		case *syntax.SglQuoted, *syntax.ArithmExp:
+			pos, end := node.Pos(), node.End()
+			fmt.Printf("%s-%s %q\n", pos, end, src[pos.Offset():end.Offset()])
+		}
+		return true
+	})
+	// Output:
+	// 1:6-1:14 "'héllo'"
+	// 2:5-2:15 "$((1 + 2))"
+}
+
 func ExampleDebugPrint() {
 	in := strings.NewReader(`echo 'foo'`)
 	f, err := syntax.NewParser().Parse(in, "")
@@ -124,6 +182,9 @@ func ExampleDebugPrint() {
 	// Output:
 	// *syntax.File {
 	// .  Name: ""
+	// .  BOM: false
+	// .  Shebang: nil
+	// .  Variant: 0
 	// .  Stmts: []*syntax.Stmt (len = 1) {
 	// .  .  0: *syntax.Stmt {
 	// .  .  .  Comments: []syntax.Comment (len = 0) {}
@@ -162,3 +223,28 @@ func ExampleDebugPrint() {
 	// .  Last: []syntax.Comment (len = 0) {}
 	// }
 }
+
+func ExampleParser_Arithmetic() {
+	in := strings.NewReader(`let "x = y + 1" i++`)
+	f, err := syntax.NewParser().Parse(in, "")
+	if err != nil {
+		return
+	}
+
+	// Quoted let arguments are kept as words, since the shell only parses
+	// them as arithmetic expressions once they have been expanded.
+	exprs, err := syntax.NewParser().LetExprs(f.Stmts[0].Cmd.(*syntax.LetClause))
+	if err != nil {
+		return
+	}
+	printer := syntax.NewPrinter()
+	for _, expr := range exprs {
+		fmt.Printf("%-22T %s - ", expr, expr.Pos())
+		printer.Print(os.Stdout, expr)
+		fmt.Println()
+	}
+
+	// Output:
+	// *syntax.BinaryArithm   1:6 - x = y + 1
+	// *syntax.UnaryArithm    1:17 - i++
+}
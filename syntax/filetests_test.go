@@ -138,13 +138,21 @@ var fileTests = []testCase{
 		common: litWord(`\`),
 	},
 	{
-		Strs:   []string{`foo\`, "f\\\noo\\"},
+		Strs:   []string{`foo\`},
 		common: litWord(`foo\`),
 	},
 	{
-		Strs:   []string{`foo\a`, "f\\\noo\\a"},
+		Strs:   []string{"f\\\noo\\"},
+		common: word(lit("f"), lit(`oo\`)),
+	},
+	{
+		Strs:   []string{`foo\a`},
 		common: litWord(`foo\a`),
 	},
+	{
+		Strs:   []string{"f\\\noo\\a"},
+		common: word(lit("f"), lit(`oo\a`)),
+	},
 	{
 		Strs: []string{
 			"foo\nbar",
@@ -160,9 +168,27 @@ var fileTests = []testCase{
 		common: litCall("foo", "a", "b"),
 	},
 	{
-		Strs:   []string{"foobar", "foo\\\nbar", "foo\\\nba\\\nr"},
+		Strs:   []string{"foobar"},
 		common: litWord("foobar"),
 	},
+	{
+		Strs:   []string{"foo\\\nbar"},
+		common: word(lit("foo"), lit("bar")),
+	},
+	{
+		Strs:   []string{"foo\\\nba\\\nr", "foo\\\nba\\\n\\\nr"},
+		common: word(lit("foo"), lit("ba"), lit("r")),
+	},
+	{
+		Strs: []string{"foo=bar\\\nbaz etc", "foo=bar\\\nbaz etc\\\n"},
+		common: &CallExpr{
+			Assigns: []*Assign{{
+				Name:  lit("foo"),
+				Value: word(lit("bar"), lit("baz")),
+			}},
+			Args: litWords("etc"),
+		},
+	},
 	{
 		Strs:   []string{"foo", "foo \\\n"},
 		common: litWord("foo"),
@@ -279,6 +305,25 @@ var fileTests = []testCase{
 		}),
 		posix: subshell(stmt(subshell(litStmt("a", "==", "2")))),
 	},
+	{
+		Strs: []string{
+			"(\n\t(a)\n\t(b)\n)",
+			"((a); (b))",
+			"( (a); (b))",
+		},
+		common: subshell(
+			stmt(subshell(litStmt("a"))),
+			stmt(subshell(litStmt("b"))),
+		),
+	},
+	{
+		Strs: []string{"( (a) | b)", "((a) | b)"},
+		common: subshell(stmt(&BinaryCmd{
+			Op: Pipe,
+			X:  stmt(subshell(litStmt("a"))),
+			Y:  litStmt("b"),
+		})),
+	},
 	{
 		Strs: []string{"if (($# > 2)); then b; fi"},
 		bsmk: &IfClause{
@@ -744,9 +789,20 @@ var fileTests = []testCase{
 	{
 		Strs: []string{
 			"function foo() {\n\ta\n\tb\n}",
-			"function foo {\n\ta\n\tb\n}",
 			"function foo() { a; b; }",
 		},
+		bsmk: &FuncDecl{
+			RsrvWord: true,
+			Parens:   true,
+			Name:     lit("foo"),
+			Body:     stmt(block(litStmt("a"), litStmt("b"))),
+		},
+	},
+	{
+		Strs: []string{
+			"function foo {\n\ta\n\tb\n}",
+			"function foo { a; b; }",
+		},
 		bsmk: &FuncDecl{
 			RsrvWord: true,
 			Name:     lit("foo"),
@@ -757,10 +813,22 @@ var fileTests = []testCase{
 		Strs: []string{"function foo() (a)"},
 		bash: &FuncDecl{
 			RsrvWord: true,
+			Parens:   true,
 			Name:     lit("foo"),
 			Body:     stmt(subshell(litStmt("a"))),
 		},
 	},
+	{
+		Strs: []string{"function foo if a; then b; fi"},
+		bash: &FuncDecl{
+			RsrvWord: true,
+			Name:     lit("foo"),
+			Body: stmt(&IfClause{
+				Cond: litStmts("a"),
+				Then: litStmts("b"),
+			}),
+		},
+	},
 	{
 		Strs: []string{"a=b foo=$bar foo=start$bar"},
 		common: &CallExpr{
@@ -790,6 +858,77 @@ var fileTests = []testCase{
 			Args:    litWords("foo"),
 		},
 	},
+	{
+		Strs: []string{"echo ~ ~/foo ~user/bar"},
+		common: call(
+			litWord("echo"),
+			word(&Tilde{}),
+			word(&Tilde{}, lit("/foo")),
+			word(&Tilde{User: "user"}, lit("/bar")),
+		),
+	},
+	{
+		Strs: []string{`echo a~ \~ ~$foo "~"`},
+		common: call(
+			litWord("echo"),
+			litWord("a~"),
+			litWord(`\~`),
+			word(lit("~"), litParamExp("foo")),
+			word(dblQuoted(lit("~"))),
+		),
+	},
+	{
+		Strs: []string{"a=~/foo"},
+		common: &CallExpr{
+			Assigns: []*Assign{{
+				Name:  lit("a"),
+				Value: word(&Tilde{}, lit("/foo")),
+			}},
+		},
+	},
+	{
+		Strs: []string{"a=~:~/bin:b~:~user"},
+		common: &CallExpr{
+			Assigns: []*Assign{{
+				Name: lit("a"),
+				Value: word(
+					&Tilde{},
+					lit(":"),
+					&Tilde{},
+					lit("/bin:b~:"),
+					&Tilde{User: "user"},
+				),
+			}},
+		},
+	},
+	{
+		Strs: []string{`echo ~:~ ${a:-~} "${a:-~}" "${a#~/}"`},
+		common: call(
+			litWord("echo"),
+			word(&Tilde{User: ":~"}),
+			word(&ParamExp{
+				Param: lit("a"),
+				Exp: &Expansion{
+					Op:   DefaultUnsetOrNull,
+					Word: word(&Tilde{}),
+				},
+			}),
+			word(dblQuoted(&ParamExp{
+				Param: lit("a"),
+				Exp: &Expansion{
+					Op:   DefaultUnsetOrNull,
+					Word: litWord("~"),
+				},
+			})),
+			word(dblQuoted(&ParamExp{
+				Param: lit("a"),
+				Exp: &Expansion{
+					Op:   RemSmallPrefix,
+					Word: word(&Tilde{}, lit("/")),
+				},
+			})),
+		),
+	},
 	{
 		Strs: []string{"a=b=c"},
 		common: &CallExpr{
@@ -836,6 +975,21 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{"2>/dev/null <input", "2> /dev/null < input"},
+		common: &Stmt{
+			Redirs: []*Redirect{
+				{Op: RdrOut, N: lit("2"), Word: litWord("/dev/null")},
+				{Op: RdrIn, Word: litWord("input")},
+			},
+		},
+	},
+	{
+		Strs: []string{"{ >a; }", "{\n\t>a\n}"},
+		common: block(&Stmt{Redirs: []*Redirect{
+			{Op: RdrOut, Word: litWord("a")},
+		}}),
+	},
 	{
 		Strs: []string{">a\n>b", ">a; >b"},
 		common: []*Stmt{
@@ -860,7 +1014,7 @@ var fileTests = []testCase{
 		},
 	},
 	{
-		Strs: []string{"foo >bar$(etc)", "foo >b\\\nar`etc`"},
+		Strs: []string{"foo >bar$(etc)", "foo >bar`etc`"},
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{
@@ -871,6 +1025,19 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{"foo >b\\\nar$(etc)", "foo >b\\\nar`etc`"},
+		common: &Stmt{
+			Cmd: litCall("foo"),
+			Redirs: []*Redirect{
+				{Op: RdrOut, Word: word(
+					lit("b"),
+					lit("ar"),
+					cmdSubst(litStmt("etc")),
+				)},
+			},
+		},
+	},
 	{
 		Strs: []string{
 			"a=b c=d foo >x <y",
@@ -1004,6 +1171,44 @@ var fileTests = []testCase{
 			}},
 		},
 	},
+	{
+		Strs: []string{"a <<EOF\n${b:-c} $((1 + 2))\nEOF"},
+		common: &Stmt{
+			Cmd: litCall("a"),
+			Redirs: []*Redirect{{
+				Op:   Hdoc,
+				Word: litWord("EOF"),
+				Hdoc: word(
+					&ParamExp{
+						Param: lit("b"),
+						Exp: &Expansion{
+							Op:   DefaultUnsetOrNull,
+							Word: litWord("c"),
+						},
+					},
+					lit(" "),
+					arithmExp(&BinaryArithm{
+						Op: Add,
+						X:  litWord("1"),
+						Y:  litWord("2"),
+					}),
+					lit("\n"),
+				),
+			}},
+		},
+	},
+	{
+		Strs: []string{"a <<'EOF'\n$b $(c)\nEOF"},
+		common: &Stmt{
+			Cmd: litCall("a"),
+			Redirs: []*Redirect{{
+				Op:         Hdoc,
+				Word:       word(sglQuoted("EOF")),
+				Hdoc:       litWord("$b $(c)\n"),
+				HdocQuoted: true,
+			}},
+		},
+	},
 	{
 		Strs: []string{"a <<EOF\n\\${\nEOF"},
 		common: &Stmt{
@@ -1183,9 +1388,10 @@ var fileTests = []testCase{
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{{
-				Op:   Hdoc,
-				Word: word(dblQuoted(lit("EOF"))),
-				Hdoc: litWord("bar\n"),
+				Op:         Hdoc,
+				Word:       word(dblQuoted(lit("EOF"))),
+				Hdoc:       litWord("bar\n"),
+				HdocQuoted: true,
 			}},
 		},
 	},
@@ -1195,9 +1401,10 @@ var fileTests = []testCase{
 			{
 				Cmd: litCall("foo"),
 				Redirs: []*Redirect{{
-					Op:   Hdoc,
-					Word: word(sglQuoted("EOF")),
-					Hdoc: litWord("EOF_body\n"),
+					Op:         Hdoc,
+					Word:       word(sglQuoted("EOF")),
+					Hdoc:       litWord("EOF_body\n"),
+					HdocQuoted: true,
 				}},
 			},
 			litStmt("foo2"),
@@ -1208,9 +1415,10 @@ var fileTests = []testCase{
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{{
-				Op:   Hdoc,
-				Word: word(sglQuoted("EOF")),
-				Hdoc: litWord("${\n"),
+				Op:         Hdoc,
+				Word:       word(sglQuoted("EOF")),
+				Hdoc:       litWord("${\n"),
+				HdocQuoted: true,
 			}},
 		},
 	},
@@ -1219,8 +1427,9 @@ var fileTests = []testCase{
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{{
-				Op:   Hdoc,
-				Word: word(sglQuoted("EOF")),
+				Op:         Hdoc,
+				Word:       word(sglQuoted("EOF")),
+				HdocQuoted: true,
 			}},
 		},
 	},
@@ -1229,9 +1438,10 @@ var fileTests = []testCase{
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{{
-				Op:   Hdoc,
-				Word: word(dblQuoted(lit("EOF")), lit("2")),
-				Hdoc: litWord("bar\n"),
+				Op:         Hdoc,
+				Word:       word(dblQuoted(lit("EOF")), lit("2")),
+				Hdoc:       litWord("bar\n"),
+				HdocQuoted: true,
 			}},
 		},
 	},
@@ -1240,9 +1450,10 @@ var fileTests = []testCase{
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{{
-				Op:   Hdoc,
-				Word: litWord("\\EOF"),
-				Hdoc: litWord("bar\n"),
+				Op:         Hdoc,
+				Word:       litWord("\\EOF"),
+				Hdoc:       litWord("bar\n"),
+				HdocQuoted: true,
 			}},
 		},
 	},
@@ -1293,9 +1504,10 @@ var fileTests = []testCase{
 		common: &Stmt{
 			Cmd: litCall("foo"),
 			Redirs: []*Redirect{{
-				Op:   DashHdoc,
-				Word: word(sglQuoted("EOF")),
-				Hdoc: litWord("\tbar\n"),
+				Op:         DashHdoc,
+				Word:       word(sglQuoted("EOF")),
+				Hdoc:       litWord("\tbar\n"),
+				HdocQuoted: true,
 			}},
 		},
 	},
@@ -1323,6 +1535,36 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{
+			"cat <<A <<B\nh1\nA\nh2\nB",
+			"cat <<A <<B\nh1\nA\nh2\nB\n",
+		},
+		common: &Stmt{
+			Cmd: litCall("cat"),
+			Redirs: []*Redirect{
+				{Op: Hdoc, Word: litWord("A"), Hdoc: litWord("h1\n")},
+				{Op: Hdoc, Word: litWord("B"), Hdoc: litWord("h2\n")},
+			},
+		},
+	},
+	{
+		Strs: []string{"diff <(x) <<EOF\nh1\nEOF"},
+		bash: &Stmt{
+			Cmd: call(
+				litWord("diff"),
+				word(&ProcSubst{
+					Op:    CmdIn,
+					Stmts: litStmts("x"),
+				}),
+			),
+			Redirs: []*Redirect{{
+				Op:   Hdoc,
+				Word: litWord("EOF"),
+				Hdoc: litWord("h1\n"),
+			}},
+		},
+	},
 	{
 		Strs: []string{
 			"a <<EOF\nfoo\nEOF\nb\nb\nb\nb\nb\nb\nb\nb\nb",
@@ -1460,6 +1702,19 @@ var fileTests = []testCase{
 			}},
 		},
 	},
+	{
+		Strs: []string{
+			`grep foo <<<"$input"`,
+			`grep foo <<< "$input"`,
+		},
+		bsmk: &Stmt{
+			Cmd: litCall("grep", "foo"),
+			Redirs: []*Redirect{{
+				Op:   WordHdoc,
+				Word: word(dblQuoted(litParamExp("input"))),
+			}},
+		},
+	},
 	{
 		Strs: []string{"foo >(foo)"},
 		bash: call(
@@ -1483,6 +1738,33 @@ var fileTests = []testCase{
 			}},
 		},
 	},
+	{
+		Strs: []string{"foo > >(foo)"},
+		bash: &Stmt{
+			Cmd: litCall("foo"),
+			Redirs: []*Redirect{{
+				Op: RdrOut,
+				Word: word(&ProcSubst{
+					Op:    CmdOut,
+					Stmts: litStmts("foo"),
+				}),
+			}},
+		},
+	},
+	{
+		Strs: []string{"diff <(sort a) <(sort b)"},
+		bash: call(
+			litWord("diff"),
+			word(&ProcSubst{
+				Op:    CmdIn,
+				Stmts: []*Stmt{litStmt("sort", "a")},
+			}),
+			word(&ProcSubst{
+				Op:    CmdIn,
+				Stmts: []*Stmt{litStmt("sort", "b")},
+			}),
+		),
+	},
 	{
 		Strs: []string{"a<(b) c>(d)"},
 		bash: call(
@@ -1505,6 +1787,25 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{"exec {logfd}>>log.txt", "exec {logfd}>> log.txt"},
+		bash: &Stmt{
+			Cmd: litCall("exec"),
+			Redirs: []*Redirect{
+				{Op: AppOut, N: lit("{logfd}"), Word: litWord("log.txt")},
+			},
+		},
+	},
+	{
+		Strs: []string{"foo >&$logfd {logfd}>&-"},
+		bash: &Stmt{
+			Cmd: litCall("foo"),
+			Redirs: []*Redirect{
+				{Op: DplOut, Word: word(litParamExp("logfd"))},
+				{Op: DplOut, N: lit("{logfd}"), Word: litWord("-")},
+			},
+		},
+	},
 	{
 		Strs: []string{"! foo"},
 		common: &Stmt{
@@ -1666,6 +1967,19 @@ var fileTests = []testCase{
 			word(cmdSubst(litStmt("x"))),
 		))),
 	},
+	{
+		Strs: []string{
+			"$(echo $(echo $(x)))",
+			"`echo \\`echo \\\\\\`x\\\\\\`\\``",
+		},
+		common: cmdSubst(stmt(call(
+			litWord("echo"),
+			word(cmdSubst(stmt(call(
+				litWord("echo"),
+				word(cmdSubst(litStmt("x"))),
+			)))),
+		))),
+	},
 	{
 		Strs: []string{
 			"$($(foo bar))",
@@ -1685,6 +1999,37 @@ var fileTests = []testCase{
 			}),
 		),
 	},
+	{
+		Strs: []string{
+			"$(\n\t(a)\n\t(b)\n)",
+			"$((a); (b))",
+			"$((a)\n(b))",
+		},
+		bash: cmdSubst(
+			stmt(subshell(litStmt("a"))),
+			stmt(subshell(litStmt("b"))),
+		),
+	},
+	{
+		Strs: []string{"$( (a) | b)", "$((a) | b)", "$((a) | b )"},
+		bash: cmdSubst(
+			stmt(&BinaryCmd{
+				Op: Pipe,
+				X:  stmt(subshell(litStmt("a"))),
+				Y:  litStmt("b"),
+			}),
+		),
+	},
+	{
+		Strs: []string{`"$( (foo))"`, `"$((foo) )"`},
+		bash: dblQuoted(cmdSubst(stmt(
+			subshell(litStmt("foo")),
+		))),
+	},
+	{
+		Strs:   []string{"$(((a)))", "$(( (a) ))"},
+		common: arithmExp(parenArit(litWord("a"))),
+	},
 	{
 		Strs: []string{`"$( (foo))"`},
 		common: dblQuoted(cmdSubst(stmt(
@@ -1706,9 +2051,13 @@ var fileTests = []testCase{
 		common: word(lit("{"), dblQuoted(lit("foo"))),
 	},
 	{
-		Strs:   []string{`foo"bar"`, "fo\\\no\"bar\""},
+		Strs:   []string{`foo"bar"`},
 		common: word(lit("foo"), dblQuoted(lit("bar"))),
 	},
+	{
+		Strs:   []string{"fo\\\no\"bar\""},
+		common: word(lit("fo"), lit("o"), dblQuoted(lit("bar"))),
+	},
 	{
 		Strs:   []string{`!foo`},
 		common: litWord(`!foo`),
@@ -1755,9 +2104,13 @@ var fileTests = []testCase{
 		)))),
 	},
 	{
-		Strs:   []string{"$(foo)", "`fo\\\no`"},
+		Strs:   []string{"$(foo)", "`foo`"},
 		common: cmdSubst(litStmt("foo")),
 	},
+	{
+		Strs:   []string{"$(fo\\\no)", "`fo\\\no`"},
+		common: cmdSubst(stmt(call(word(lit("fo"), lit("o"))))),
+	},
 	{
 		Strs: []string{"foo $(bar)", "foo `bar`"},
 		common: call(
@@ -2116,6 +2469,21 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{`${foo[i * 2 + 1]}`, `${foo[i*2+1]}`},
+		bsmk: &ParamExp{
+			Param: lit("foo"),
+			Index: &BinaryArithm{
+				Op: Add,
+				X: &BinaryArithm{
+					Op: Mul,
+					X:  litWord("i"),
+					Y:  litWord("2"),
+				},
+				Y: litWord("1"),
+			},
+		},
+	},
 	{
 		Strs: []string{`${foo[@]}`},
 		bsmk: &ParamExp{
@@ -2506,6 +2874,18 @@ var fileTests = []testCase{
 			litWord("foo"),
 		)),
 	},
+	{
+		Strs: []string{"$[1 + 3]", "$[1+3]"},
+		bash: arithmExpBr(&BinaryArithm{
+			Op: Add,
+			X:  litWord("1"),
+			Y:  litWord("3"),
+		}),
+	},
+	{
+		Strs: []string{`"$[foo]"`},
+		bash: dblQuoted(arithmExpBr(litWord("foo"))),
+	},
 	{
 		Strs: []string{`$((a)) b`},
 		common: call(
@@ -2604,6 +2984,30 @@ var fileTests = []testCase{
 			Y:  litWord("10"),
 		}),
 	},
+	{
+		Strs: []string{"$((a ** b ** c))", "$((a**b**c))"},
+		common: arithmExp(&BinaryArithm{
+			Op: Pow,
+			X:  litWord("a"),
+			Y: &BinaryArithm{
+				Op: Pow,
+				X:  litWord("b"),
+				Y:  litWord("c"),
+			},
+		}),
+	},
+	{
+		Strs: []string{"$((a * b ** c))"},
+		common: arithmExp(&BinaryArithm{
+			Op: Mul,
+			X:  litWord("a"),
+			Y: &BinaryArithm{
+				Op: Pow,
+				X:  litWord("b"),
+				Y:  litWord("c"),
+			},
+		}),
+	},
 	{
 		Strs: []string{`$(((1) ^ 3))`},
 		common: arithmExp(&BinaryArithm{
@@ -2799,6 +3203,46 @@ var fileTests = []testCase{
 			Y:  litWord("b"),
 		}),
 	},
+	{
+		Strs: []string{"$((0xff + 010 + 2#1011 + 64#z))"},
+		common: arithmExp(&BinaryArithm{
+			Op: Add,
+			X: &BinaryArithm{
+				Op: Add,
+				X: &BinaryArithm{
+					Op: Add,
+					X:  litWord("0xff"),
+					Y:  litWord("010"),
+				},
+				Y: litWord("2#1011"),
+			},
+			Y: litWord("64#z"),
+		}),
+	},
+	{
+		Strs: []string{"$((a *= b))", "$((a*=b))"},
+		common: arithmExp(&BinaryArithm{
+			Op: MulAssgn,
+			X:  litWord("a"),
+			Y:  litWord("b"),
+		}),
+	},
+	{
+		Strs: []string{"((a <<= 1, b |= 2))", "((a<<=1,b|=2))"},
+		bsmk: arithmCmd(&BinaryArithm{
+			Op: Comma,
+			X: &BinaryArithm{
+				Op: ShlAssgn,
+				X:  litWord("a"),
+				Y:  litWord("1"),
+			},
+			Y: &BinaryArithm{
+				Op: OrAssgn,
+				X:  litWord("b"),
+				Y:  litWord("2"),
+			},
+		}),
+	},
 	{
 		Strs: []string{"$((i *= 3))"},
 		common: arithmExp(&BinaryArithm{
@@ -2827,6 +3271,62 @@ var fileTests = []testCase{
 			},
 		}),
 	},
+	{
+		Strs: []string{"$((x > 0 ? x : -x))", "$(( x>0?x:-x ))"},
+		common: arithmExp(&BinaryArithm{
+			Op: TernQuest,
+			X: &BinaryArithm{
+				Op: Gtr,
+				X:  litWord("x"),
+				Y:  litWord("0"),
+			},
+			Y: &BinaryArithm{
+				Op: TernColon,
+				X:  litWord("x"),
+				Y:  &UnaryArithm{Op: Minus, X: litWord("x")},
+			},
+		}),
+	},
+	{
+		Strs: []string{"$((a ? b : c ? d : e))"},
+		common: arithmExp(&BinaryArithm{
+			Op: TernQuest,
+			X:  litWord("a"),
+			Y: &BinaryArithm{
+				Op: TernColon,
+				X:  litWord("b"),
+				Y: &BinaryArithm{
+					Op: TernQuest,
+					X:  litWord("c"),
+					Y: &BinaryArithm{
+						Op: TernColon,
+						X:  litWord("d"),
+						Y:  litWord("e"),
+					},
+				},
+			},
+		}),
+	},
+	{
+		Strs: []string{"$((a ? b ? c : d : e))"},
+		common: arithmExp(&BinaryArithm{
+			Op: TernQuest,
+			X:  litWord("a"),
+			Y: &BinaryArithm{
+				Op: TernColon,
+				X: &BinaryArithm{
+					Op: TernQuest,
+					X:  litWord("b"),
+					Y: &BinaryArithm{
+						Op: TernColon,
+						X:  litWord("c"),
+						Y:  litWord("d"),
+					},
+				},
+				Y: litWord("e"),
+			},
+		}),
+	},
 	{
 		Strs: []string{`$((a <= (1 || 2)))`},
 		common: arithmExp(&BinaryArithm{
@@ -2894,6 +3394,17 @@ var fileTests = []testCase{
 			lit(" c"),
 		),
 	},
+	{
+		Strs: []string{`$"a $(echo "b") c"`},
+		bsmk: dblDQuoted(
+			lit("a "),
+			cmdSubst(stmt(call(
+				litWord("echo"),
+				word(dblQuoted(lit("b"))),
+			))),
+			lit(" c"),
+		),
+	},
 	{
 		Strs: []string{"$'f\\'oo\n'"},
 		bsmk: sglDQuoted("f\\'oo\n"),
@@ -2997,6 +3508,25 @@ var fileTests = []testCase{
 			},
 		},
 	},
+	{
+		Strs: []string{
+			"case $i in [[:alpha:]_]*) foo ;; [![:digit:]] | x[[:space:]]) ;; esac",
+		},
+		common: &CaseClause{
+			Word: word(litParamExp("i")),
+			Items: []*CaseItem{
+				{
+					Op:       Break,
+					Patterns: litWords("[[:alpha:]_]*"),
+					Stmts:    litStmts("foo"),
+				},
+				{
+					Op:       Break,
+					Patterns: litWords("[![:digit:]]", "x[[:space:]]"),
+				},
+			},
+		},
+	},
 	{
 		Strs: []string{"case i in 1) a ;& 2) ;; esac"},
 		bsmk: &CaseClause{
@@ -3315,6 +3845,14 @@ var fileTests = []testCase{
 			Y:  word(lit("("), litParamExp("foo"), lit(")")),
 		}},
 	},
+	{
+		Strs: []string{`[[ $s =~ ^[0-9]+$ ]]`},
+		bash: &TestClause{X: &BinaryTest{
+			Op: TsReMatch,
+			X:  word(litParamExp("s")),
+			Y:  word(lit("^[0-9]+"), lit("$")),
+		}},
+	},
 	{
 		Strs: []string{`[[ a =~ b\ c|d ]]`},
 		bash: &TestClause{X: &BinaryTest{
@@ -3414,6 +3952,18 @@ var fileTests = []testCase{
 			Y:  parenTest(litWord("b")),
 		}},
 	},
+	{
+		Strs: []string{"[[ -f $x && $y == foo* ]]"},
+		bsmk: &TestClause{X: &BinaryTest{
+			Op: AndTest,
+			X:  &UnaryTest{Op: TsRegFile, X: word(litParamExp("x"))},
+			Y: &BinaryTest{
+				Op: TsMatch,
+				X:  word(litParamExp("y")),
+				Y:  litWord("foo*"),
+			},
+		}},
+	},
 	{
 		Strs: []string{"[[ (a && b) || -f c ]]"},
 		bsmk: &TestClause{X: &BinaryTest{
@@ -3619,6 +4169,35 @@ var fileTests = []testCase{
 		},
 		posix: litStmt("export", "bar"),
 	},
+	{
+		Strs: []string{"export FOO=bar BAZ"},
+		bsmk: &DeclClause{
+			Variant: lit("export"),
+			Args: []*Assign{
+				{Name: lit("FOO"), Value: litWord("bar")},
+				{Naked: true, Name: lit("BAZ")},
+			},
+		},
+	},
+	{
+		Strs: []string{"readonly x=1"},
+		bsmk: &DeclClause{
+			Variant: lit("readonly"),
+			Args: []*Assign{
+				{Name: lit("x"), Value: litWord("1")},
+			},
+		},
+	},
+	{
+		Strs: []string{"typeset -i n=0"},
+		bsmk: &DeclClause{
+			Variant: lit("typeset"),
+			Args: []*Assign{
+				{Naked: true, Value: litWord("-i")},
+				{Name: lit("n"), Value: litWord("0")},
+			},
+		},
+	},
 	{
 		Strs: []string{"readonly -n"},
 		bsmk: &DeclClause{
@@ -3823,6 +4402,17 @@ var fileTests = []testCase{
 		Strs: []string{"time foo"},
 		bsmk: &TimeClause{Stmt: litStmt("foo")},
 	},
+	{
+		Strs: []string{"time -p foo | bar"},
+		bsmk: &TimeClause{
+			PosixFormat: true,
+			Stmt: stmt(&BinaryCmd{
+				Op: Pipe,
+				X:  litStmt("foo"),
+				Y:  litStmt("bar"),
+			}),
+		},
+	},
 	{
 		Strs: []string{"time { foo; }"},
 		bsmk: &TimeClause{Stmt: stmt(block(litStmt("foo")))},
@@ -4232,6 +4822,18 @@ var fileTests = []testCase{
 			lit("bar"),
 		)),
 	},
+	{
+		Strs: []string{"rm !(keep.txt)"},
+		bsmk: call(litWord("rm"), word(
+			&ExtGlob{Op: GlobExcept, Pattern: lit("keep.txt")},
+		)),
+	},
+	{
+		Strs: []string{"ls !(*.txt|*.md)"},
+		bsmk: call(litWord("ls"), word(
+			&ExtGlob{Op: GlobExcept, Pattern: lit("*.txt|*.md")},
+		)),
+	},
 	{
 		Strs: []string{"echo $a@(b)$c?(d)$e*(f)$g+(h)$i!(j)$k"},
 		bsmk: call(litWord("echo"), word(
@@ -4260,14 +4862,6 @@ var fileTestsNoPrint = []testCase{
 		Strs:  []string{`"$[foo]"`},
 		posix: dblQuoted(lit("$"), lit("[foo]")),
 	},
-	{
-		Strs: []string{`"$[1 + 3]"`},
-		bash: dblQuoted(arithmExpBr(&BinaryArithm{
-			Op: Add,
-			X:  litWord("1"),
-			Y:  litWord("3"),
-		})),
-	},
 }
 
 func fullProg(v interface{}) *File {
@@ -4360,6 +4954,14 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 	}
 	switch x := v.(type) {
 	case *File:
+		if x.Shebang != nil {
+			if end := int(x.Shebang.End().Offset()); end > len(src) ||
+				(end < len(src) && src[end] != '\n' && src[end] != '\r') {
+				tb.Fatalf("Unexpected Shebang.End() %d in %q", end, src)
+			}
+			setPos(&x.Shebang.Hash, "#!")
+		}
+		x.lines = nil
 		recurse(x.Stmts)
 		recurse(x.Last)
 		checkPos(x)
@@ -4382,6 +4984,8 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 				// ended by whitespace
 			case regOps(rune(end)):
 				// ended by end character
+			case strings.HasPrefix(strings.TrimLeft(src[endOff:], "\\"), "`"):
+				// ended by an escaped backquote
 			case endOff > 0 && src[endOff-1] == ';':
 				// ended by semicolon
 			case endOff > 0 && src[endOff-1] == '&':
@@ -4645,7 +5249,7 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 		}
 	case *ArithmExp:
 		if x.Bracket {
-			// deprecated $(( form
+			// deprecated $[ form
 			setPos(&x.Left, "$[")
 			setPos(&x.Right, "]")
 		} else {
@@ -4666,8 +5270,8 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 			setPos(&x.Left, "${|")
 			setPos(&x.Right, "}")
 		case x.Backquotes:
-			setPos(&x.Left, "`", "\\`")
-			setPos(&x.Right, "`", "\\`")
+			setPos(&x.Left, "`", "\\`", "\\\\\\`")
+			setPos(&x.Right, "`", "\\`", "\\\\\\`")
 			// Zero out Backquotes, to not duplicate all the test
 			// cases. The printer ignores the field anyway.
 			x.Backquotes = false
@@ -4712,6 +5316,10 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 		if x.Stmt != nil {
 			recurse(x.Stmt)
 		}
+	case *TestDecl:
+		setPos(&x.Position, "@test")
+		recurse(x.Description)
+		recurse(x.Body)
 	case *CoprocClause:
 		setPos(&x.Coproc, "coproc")
 		if x.Name != nil {
@@ -4736,6 +5344,8 @@ func clearPosRecurse(tb testing.TB, src string, v interface{}) {
 		if x.Value != nil {
 			recurse(x.Value)
 		}
+	case *Tilde:
+		setPos(&x.ValuePos, "~"+x.User)
 	case *ExtGlob:
 		setPos(&x.OpPos, x.Op.String())
 		checkSrc(posAddCol(x.End(), -1), ")")
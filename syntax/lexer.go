@@ -6,6 +6,8 @@ package syntax
 import (
 	"bytes"
 	"io"
+	"math/bits"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -55,6 +57,21 @@ func bquoteEscaped(b byte) bool {
 	return false
 }
 
+// bquoteRun returns how many backslashes are at the start of bs, if they are
+// followed by a backquote. Otherwise, it returns zero.
+func bquoteRun(bs []byte) int {
+	for i, b := range bs {
+		switch b {
+		case '`':
+			return i
+		case '\\':
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
 const escNewl rune = utf8.RuneSelf + 1
 
 func (p *Parser) rune() rune {
@@ -63,8 +80,15 @@ func (p *Parser) rune() rune {
 		// character positions don't have col 0.
 		p.npos.line++
 		p.npos.col = 0
+		if p.f != nil && len(p.aliasFrames) == 0 {
+			p.f.addLine(p.offs + p.offsShift + p.bsp)
+		}
 	}
 	p.npos.col += p.w
+	if len(p.aliasFrames) > 0 {
+		p.endAliases()
+	}
+	p.crlfNewl = false
 	bquotes := 0
 retry:
 	if p.bsp < len(p.bs) {
@@ -72,12 +96,31 @@ retry:
 			p.bsp++
 			if b == '\\' {
 				if p.r != '\\' && p.peekByte('\n') {
+					p.escNewlOffs = p.offs + p.offsShift + p.bsp - 1
 					p.bsp++
 					p.w, p.r = 1, escNewl
 					return escNewl
 				}
-				if p.openBquotes > 0 && bquotes < p.openBquotes &&
-					p.bsp < len(p.bs) && bquoteEscaped(p.bs[p.bsp]) {
+				if p.r != '\\' && p.crlf && p.peekCRLF() {
+					p.escNewlOffs = p.offs + p.offsShift + p.bsp - 1
+					p.bsp += 2
+					p.w, p.r = 1, escNewl
+					return escNewl
+				}
+				if p.openBquotes > 0 && bquotes == 0 && p.bsp > 0 {
+					// Each level of backquotes escapes the
+					// backslashes of the levels nested within, so a
+					// backquote n levels deep needs 2^n-1 of them.
+					n := bquoteRun(p.bs[p.bsp-1:])
+					if esc := bits.Len(uint(n)); n > 1 &&
+						n == 1<<esc-1 && esc <= p.openBquotes {
+						p.bsp += n
+						b, bquotes = '`', esc
+					}
+				}
+				if b == '\\' && p.openBquotes > 0 &&
+					bquotes < p.openBquotes && p.bsp < len(p.bs) &&
+					bquoteEscaped(p.bs[p.bsp]) {
 					bquotes++
 					goto retry
 				}
@@ -85,13 +128,23 @@ retry:
 			if b == '`' {
 				p.lastBquoteEsc = bquotes
 			}
+			if b == '\r' && p.crlf && p.peekByte('\n') {
+				// drop the '\r', treating the line ending as "\n"
+				// which starts where the '\r' was
+				p.bsp++
+				b = '\n'
+				p.crlfNewl = true
+			}
 			if p.litBs != nil {
 				p.litBs = append(p.litBs, b)
 			}
 			p.w, p.r = 1, rune(b)
+			if p.histExp && len(p.aliasFrames) == 0 {
+				p.histExpand(b)
+			}
 			return p.r
 		}
-		if !utf8.FullRune(p.bs[p.bsp:]) {
+		for !utf8.FullRune(p.bs[p.bsp:]) && p.readErr == nil {
 			// we need more bytes to read a full non-ascii rune
 			p.fill()
 		}
@@ -101,7 +154,7 @@ retry:
 			p.litBs = append(p.litBs, p.bs[p.bsp:p.bsp+w]...)
 		}
 		p.bsp += w
-		if p.r == utf8.RuneError && w == 1 {
+		if p.r == utf8.RuneError && w == 1 && p.requireUTF8 {
 			p.posErr(p.npos, "invalid UTF-8 encoding")
 		}
 		p.w = uint16(w)
@@ -118,17 +171,44 @@ retry:
 	return p.r
 }
 
+// read reads from the input src into b, unless the context set with the
+// Context option is done. At most one byte past MaxBytes is read, to tell
+// whether the input is larger than that.
+//
+// With KeepSource, the bytes read are also added to the file's source.
+func (p *Parser) read(b []byte) (n int, err error) {
+	if p.ctx != nil {
+		if err := p.ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+	if p.keepSource {
+		defer func() { p.f.src = append(p.f.src, b[:n]...) }()
+	}
+	if p.maxBytes <= 0 {
+		return p.src.Read(b)
+	}
+	if left := p.maxBytes - p.nbytes + 1; len(b) > left {
+		b = b[:left]
+	}
+	n, err = p.src.Read(b)
+	if p.nbytes += n; p.nbytes > p.maxBytes {
+		return n - 1, errMaxBytes
+	}
+	return n, err
+}
+
 // fill reads more bytes from the input src into readBuf. Any bytes that
 // had not yet been used at the end of the buffer are slid into the
 // beginning of the buffer.
 func (p *Parser) fill() {
 	p.offs += p.bsp
 	left := len(p.bs) - p.bsp
-	copy(p.readBuf[:left], p.readBuf[p.bsp:])
+	copy(p.readBuf[:left], p.bs[p.bsp:])
 readAgain:
 	n, err := 0, p.readErr
 	if err == nil {
-		n, err = p.src.Read(p.readBuf[left:])
+		n, err = p.read(p.readBuf[left:])
 		p.readErr = err
 	}
 	if n == 0 {
@@ -136,7 +216,9 @@ readAgain:
 			goto readAgain
 		}
 		// don't use p.errPass as we don't want to overwrite p.tok
-		if err != io.EOF {
+		if err == errMaxBytes {
+			p.err = LimitError{Filename: p.f.Name, Pos: p.npos, Limit: LimitBytes, Max: p.maxBytes}
+		} else if err != io.EOF {
 			p.err = err
 		}
 		if left > 0 {
@@ -150,6 +232,119 @@ readAgain:
 	p.bsp = 0
 }
 
+// peekFill reads more bytes from the input src into readBuf, like fill, but
+// without discarding the bytes of the current rune nor any bytes that haven't
+// been consumed yet. readBuf is grown if needed, up to maxPeekBuf bytes. This
+// allows the lexer to look ahead a number of bytes. It reports whether any
+// bytes were read; false is also returned once readBuf can't grow further.
+//
+// Note that the bytes read count towards MaxBytes as usual, as they go through
+// read.
+func (p *Parser) peekFill() bool {
+	if p.readErr != nil {
+		return false
+	}
+	// keep a few bytes before bsp, as p.r might be read from them
+	if keep := p.bsp - utf8.UTFMax; keep > 0 {
+		p.offs += keep
+		n := copy(p.readBuf, p.bs[keep:])
+		p.bs = p.readBuf[:n]
+		p.bsp -= keep
+	}
+	if len(p.bs) == len(p.readBuf) {
+		if len(p.readBuf) >= maxPeekBuf {
+			return false
+		}
+		buf := make([]byte, 2*len(p.readBuf))
+		copy(buf, p.bs)
+		p.readBuf = buf
+		p.bs = buf[:len(p.bs)]
+	}
+	n, err := p.read(p.readBuf[len(p.bs):])
+	p.readErr = err
+	p.bs = p.readBuf[:len(p.bs)+n]
+	return n > 0 || err == nil
+}
+
+type aliasFrame struct {
+	name  string
+	blank bool // replacement text ends with a blank
+
+	namePos Pos // position of the replaced name
+	end     int // offset in the source where the replacement text ends
+	shift   int // offsShift to use once the replacement text ends
+	nextPos Pos // position of the text following the name
+}
+
+// expandAlias replaces the current literal word with its alias replacement
+// text by inserting it in the source being read, reporting whether it did so.
+func (p *Parser) expandAlias() bool {
+	text, ok := p.aliases[p.val]
+	if !ok || p.openBquotes > 0 {
+		return false
+	}
+	for _, fr := range p.aliasFrames {
+		if fr.name == p.val {
+			return false // already being expanded
+		}
+	}
+	// p.r has already been read, so it must be inserted again.
+	var rest []byte
+	rOffs := p.offs + p.bsp - int(p.w)
+	switch p.r {
+	case utf8.RuneSelf:
+	case escNewl:
+		rest = append(rest, '\\', '\n')
+		rOffs--
+	default:
+		rest = append(rest, p.bs[p.bsp-int(p.w):p.bsp]...)
+	}
+	if p.bsp < len(p.bs) {
+		rest = append(rest, p.bs[p.bsp:]...)
+	}
+	start := p.offs + p.bsp
+	end := start + len(text)
+	delta := end - rOffs
+	for i := range p.aliasFrames {
+		p.aliasFrames[i].end += delta
+	}
+	nextPos := p.npos
+	nextPos.offs = uint32(rOffs + p.offsShift)
+	p.aliasFrames = append(p.aliasFrames, aliasFrame{
+		name:    p.val,
+		blank:   strings.TrimRight(text, " \t") != text,
+		namePos: p.pos,
+		end:     end,
+		shift:   p.offsShift - delta,
+		nextPos: nextPos,
+	})
+	var src io.Reader = strings.NewReader(text + string(rest))
+	if p.readErr == nil {
+		src = io.MultiReader(src, p.src)
+	}
+	p.src, p.readErr = src, nil
+	p.bs, p.bsp, p.offs = nil, 0, start
+	p.r, p.w = 0, 0
+	p.aliasBlank = false
+	p.rune()
+	p.next()
+	return true
+}
+
+// endAliases pops the replacement texts which have been read entirely.
+func (p *Parser) endAliases() {
+	for len(p.aliasFrames) > 0 {
+		fr := p.aliasFrames[len(p.aliasFrames)-1]
+		if p.offs+p.bsp < fr.end {
+			break
+		}
+		p.aliasFrames = p.aliasFrames[:len(p.aliasFrames)-1]
+		p.offsShift = fr.shift
+		p.npos = fr.nextPos
+		p.aliasBlank = fr.blank
+	}
+}
+
 func (p *Parser) nextKeepSpaces() {
 	r := p.r
 	p.pos = p.getPos()
@@ -192,7 +387,59 @@ func (p *Parser) nextKeepSpaces() {
 	}
 }
 
+// next lexes the next token, reporting it to the function set by
+// Parser.Tokens, if any. Newlines and comments are reported by lex itself, so
+// that they come before any heredoc bodies or tokens which follow them.
 func (p *Parser) next() {
+	p.lex()
+	if p.tokFn == nil {
+		return
+	}
+	switch p.tok {
+	case _EOF, _Newl:
+	case _Lit, _LitWord, _LitRedir:
+		p.emitTok(LitToken, p.pos, p.getPos(), p.val)
+	default:
+		val := p.tok.String()
+		p.emitTok(OpToken, p.pos, posAddCol(p.pos, len(val)), val)
+	}
+}
+
+// emitTok reports a token to the function set by Parser.Tokens. The token is
+// held back until the next one is found, so that the parser can mark how it
+// used the token via markTok.
+func (p *Parser) emitTok(kind TokenKind, pos, end Pos, val string) {
+	if p.tokFn == nil || p.err != nil {
+		return
+	}
+	p.flushTok()
+	p.heldTok = Token{Kind: kind, Pos: pos, End: end, Value: val, quote: p.quote}
+	p.holdingTok = true
+}
+
+// markTok records that the current token was used in a certain way, if it is
+// still held back by emitTok.
+func (p *Parser) markTok(use tokenUse) {
+	if p.holdingTok && p.heldTok.Pos == p.pos {
+		p.heldTok.use |= use
+	}
+}
+
+// flushTok reports the token held back by emitTok, if any. If the function set
+// by Parser.Tokens returns false, the parser is stopped.
+func (p *Parser) flushTok() {
+	if !p.holdingTok || p.tokFn == nil {
+		return
+	}
+	p.holdingTok = false
+	if !p.tokFn(p.heldTok) {
+		p.tokFn = nil
+		p.errPass(errStopTokens)
+	}
+}
+
+func (p *Parser) lex() {
+	p.litBreaks = p.litBreaks[:0]
 	if p.r == utf8.RuneSelf {
 		p.tok = _EOF
 		return
@@ -225,6 +472,10 @@ skipSpace:
 			}
 			p.spaced = true
 			p.tok = _Newl
+			if p.tokFn != nil {
+				pos := p.getPos()
+				p.emitTok(NewlineToken, pos, posAddCol(pos, 1), "\n")
+			}
 			if p.quote != hdocWord && len(p.heredocs) > p.buriedHdocs {
 				p.doHeredocs()
 			}
@@ -234,8 +485,8 @@ skipSpace:
 		}
 	}
 	if p.stopAt != nil && (p.spaced || p.tok == illegalTok || stopToken(p.tok)) {
-		w := utf8.RuneLen(r)
-		if bytes.HasPrefix(p.bs[p.bsp-w:], p.stopAt) {
+		if p.peekStopAt() {
+			p.stopPos = p.getPos()
 			p.r = utf8.RuneSelf
 			p.w = 1
 			p.tok = _EOF
@@ -258,15 +509,21 @@ skipSpace:
 				}
 				r = p.rune()
 			}
+			text := p.endLit()
+			if p.f != nil && p.atFileStart() && strings.HasPrefix(text, "!") {
+				p.f.Shebang = newShebang(p.pos, text[1:])
+				if p.variant == LangAuto {
+					p.lang = shebangLang(p.f.Shebang)
+				}
+			}
+			p.emitTok(CommentToken, p.pos, p.getPos(), "#"+text)
 			if p.keepComments {
 				*p.curComs = append(*p.curComs, Comment{
 					Hash: p.pos,
-					Text: p.endLit(),
+					Text: text,
 				})
-			} else {
-				p.litBs = nil
 			}
-			p.next()
+			p.lex()
 		case '[', '=':
 			if p.quote == arrayElems {
 				p.tok = p.paramToken(r)
@@ -337,6 +594,120 @@ func (p *Parser) peekByte(b byte) bool {
 	return p.bsp < len(p.bs) && p.bs[p.bsp] == b
 }
 
+// peekArithm reports whether the "((" or "$((" being lexed, with p.r being its
+// second parenthesis, starts an arithmetic command or expansion. Otherwise, it
+// starts nested subshells or a command substitution beginning with a subshell,
+// such as "((a); (b))" or "$((a); (b))".
+//
+// Like Bash, the input is scanned ahead; the parentheses within arithmetic
+// are balanced up to its closing "))". If the input ends before the scan is
+// done, or the scan goes past maxPeekBuf bytes, arithmetic is assumed.
+func (p *Parser) peekArithm() bool {
+	i := p.bsp
+	next := func() (byte, bool) {
+		for i >= len(p.bs) {
+			start := p.bsp
+			if !p.peekFill() {
+				return 0, false
+			}
+			i += p.bsp - start // peekFill may move the buffered bytes
+		}
+		i++
+		return p.bs[i-1], true
+	}
+	depth := 0
+	var quote byte
+	for {
+		b, ok := next()
+		switch {
+		case !ok:
+			return true
+		case quote == '\'':
+			if b == '\'' {
+				quote = 0
+			}
+		case b == '\\':
+			// skip the escaped byte
+			if _, ok := next(); !ok {
+				return true
+			}
+		case quote == '"':
+			if b == '"' {
+				quote = 0
+			}
+		case b == '\'', b == '"':
+			quote = b
+		case b == '(':
+			depth++
+		case b == ')':
+			if depth > 0 {
+				depth--
+				break
+			}
+			b, ok := next()
+			return !ok || b == ')'
+		}
+	}
+}
+
+// histExpand follows the quoting of the input byte by byte like Bash's history
+// expansion does, reporting an error at any "!" which would start a history
+// expansion. See HistoryExpansion.
+func (p *Parser) histExpand(b byte) {
+	prev := p.histPrev
+	p.histPrev = [2]byte{prev[1], b}
+	switch {
+	case p.histEsc:
+		p.histEsc = false
+	case b == '\\' && p.histQuote != '\'':
+		p.histEsc = true
+	case b == '\'' && p.histQuote != '"':
+		p.histQuote ^= '\'' // toggle between 0 and '\''
+	case b == '"' && p.histQuote != '\'':
+		p.histQuote ^= '"' // toggle between 0 and '"'
+	case b == '\n':
+		// Like Bash, start each line with the parser's quoting state,
+		// as quotes within comments and heredocs don't count.
+		if p.tok != sglQuote && p.tok != dollSglQuote && p.quote != dblQuotes {
+			p.histQuote = 0
+		}
+	case b == '!' && p.histQuote != '\'':
+		if prev[1] == '$' || prev[1] == '[' || prev == [2]byte{'$', '{'} {
+			return
+		}
+		for p.bsp >= len(p.bs) {
+			if !p.peekFill() {
+				return // end of input
+			}
+		}
+		switch p.bs[p.bsp] {
+		case ' ', '\t', '\n', '\r', '=', '(':
+			return
+		case '"':
+			if p.histQuote == '"' {
+				return
+			}
+		}
+		p.posErr(p.getPos(), `"!" would start a history expansion`)
+	}
+}
+
+// peekStopAt reports whether the word set with StopAt starts at p.r. More
+// bytes are read if needed, as the word may be split between reads.
+func (p *Parser) peekStopAt() bool {
+	for len(p.bs)-p.bsp+int(p.w) < len(p.stopAt) && p.peekFill() {
+	}
+	return bytes.HasPrefix(p.bs[p.bsp-int(p.w):], p.stopAt)
+}
+
+// peekCRLF reports whether the next two bytes are "\r\n".
+func (p *Parser) peekCRLF() bool {
+	if p.bsp+1 >= len(p.bs) {
+		p.fill()
+	}
+	return p.bsp+1 < len(p.bs) && p.bs[p.bsp] == '\r' && p.bs[p.bsp+1] == '\n'
+}
+
 func (p *Parser) regToken(r rune) token {
 	switch r {
 	case '\'':
@@ -360,7 +731,10 @@ func (p *Parser) regToken(r rune) token {
 			p.rune()
 			return andAnd
 		case '>':
-			if p.lang == LangPOSIX {
+			if !p.lang.in(LangBash, LangMirBSDKorn, LangBusyBox) {
+				if p.strictPOSIX {
+					p.langErr(p.pos, "&> redirects", LangBash, LangMirBSDKorn, LangBusyBox)
+				}
 				break
 			}
 			if p.rune() == '>' {
@@ -376,7 +750,7 @@ func (p *Parser) regToken(r rune) token {
 			p.rune()
 			return orOr
 		case '&':
-			if p.lang == LangPOSIX {
+			if !p.lang.in(LangBash, LangMirBSDKorn) {
 				break
 			}
 			p.rune()
@@ -386,13 +760,19 @@ func (p *Parser) regToken(r rune) token {
 	case '$':
 		switch p.rune() {
 		case '\'':
-			if p.lang == LangPOSIX {
+			if !p.lang.in(LangBash, LangMirBSDKorn, LangBusyBox) {
+				if p.strictPOSIX {
+					p.langErr(p.pos, `"$''" strings`, LangBash, LangMirBSDKorn, LangBusyBox)
+				}
 				break
 			}
 			p.rune()
 			return dollSglQuote
 		case '"':
-			if p.lang == LangPOSIX {
+			if !p.lang.in(LangBash, LangMirBSDKorn) {
+				if p.strictPOSIX {
+					p.langErr(p.pos, `"$\"\"" strings`, LangBash)
+				}
 				break
 			}
 			p.rune()
@@ -401,14 +781,14 @@ func (p *Parser) regToken(r rune) token {
 			p.rune()
 			return dollBrace
 		case '[':
-			if p.lang != LangBash || p.quote == paramExpName {
+			if !p.lang.in(LangBash) || p.quote == paramExpName {
 				// latter to not tokenise ${$[@]} as $[
 				break
 			}
 			p.rune()
 			return dollBrack
 		case '(':
-			if p.rune() == '(' {
+			if p.rune() == '(' && p.peekArithm() {
 				p.rune()
 				return dollDblParen
 			}
@@ -416,7 +796,8 @@ func (p *Parser) regToken(r rune) token {
 		}
 		return dollar
 	case '(':
-		if p.rune() == '(' && p.lang != LangPOSIX && p.quote != testExpr {
+		if p.rune() == '(' && p.lang.in(LangBash, LangMirBSDKorn) && p.quote != testExpr &&
+			p.peekArithm() {
 			p.rune()
 			return dblLeftParen
 		}
@@ -427,19 +808,19 @@ func (p *Parser) regToken(r rune) token {
 	case ';':
 		switch p.rune() {
 		case ';':
-			if p.rune() == '&' && p.lang == LangBash {
+			if p.rune() == '&' && p.lang.in(LangBash) {
 				p.rune()
 				return dblSemiAnd
 			}
 			return dblSemicolon
 		case '&':
-			if p.lang == LangPOSIX {
+			if !p.lang.in(LangBash, LangMirBSDKorn) {
 				break
 			}
 			p.rune()
 			return semiAnd
 		case '|':
-			if p.lang != LangMirBSDKorn {
+			if !p.lang.in(LangMirBSDKorn) {
 				break
 			}
 			p.rune()
@@ -452,7 +833,7 @@ func (p *Parser) regToken(r rune) token {
 			if r = p.rune(); r == '-' {
 				p.rune()
 				return dashHdoc
-			} else if r == '<' && p.lang != LangPOSIX {
+			} else if r == '<' && p.lang.in(LangBash, LangMirBSDKorn, LangBusyBox) {
 				p.rune()
 				return wordHdoc
 			}
@@ -464,7 +845,7 @@ func (p *Parser) regToken(r rune) token {
 			p.rune()
 			return dplIn
 		case '(':
-			if p.lang != LangBash {
+			if !p.lang.in(LangBash) {
 				break
 			}
 			p.rune()
@@ -483,7 +864,7 @@ func (p *Parser) regToken(r rune) token {
 			p.rune()
 			return clbOut
 		case '(':
-			if p.lang != LangBash {
+			if !p.lang.in(LangBash) {
 				break
 			}
 			p.rune()
@@ -508,13 +889,13 @@ func (p *Parser) dqToken(r rune) token {
 			p.rune()
 			return dollBrace
 		case '[':
-			if p.lang != LangBash {
+			if !p.lang.in(LangBash) {
 				break
 			}
 			p.rune()
 			return dollBrack
 		case '(':
-			if p.rune() == '(' {
+			if p.rune() == '(' && p.peekArithm() {
 				p.rune()
 				return dollDblParen
 			}
@@ -749,7 +1130,9 @@ func (p *Parser) newLit(r rune) {
 		p.litBs = p.litBuf[:1]
 		p.litBs[0] = byte(r)
 	case r > escNewl:
-		w := utf8.RuneLen(r)
+		// not utf8.RuneLen, as r may be utf8.RuneError from an
+		// invalid byte
+		w := int(p.w)
 		p.litBs = append(p.litBuf[:0], p.bs[p.bsp-w:p.bsp]...)
 	default:
 		// don't let r == utf8.RuneSelf go to the second case as RuneLen
@@ -823,7 +1206,7 @@ loop:
 				break loop
 			}
 		case '[', ']':
-			if p.lang != LangPOSIX && p.quote&allArithmExpr != 0 {
+			if p.lang.in(LangBash, LangMirBSDKorn) && p.quote&allArithmExpr != 0 {
 				break loop
 			}
 			fallthrough
@@ -846,6 +1229,8 @@ func (p *Parser) advanceLitNone(r rune) {
 loop:
 	for p.newLit(r); r != utf8.RuneSelf; r = p.rune() {
 		switch r {
+		case escNewl:
+			p.addLitBreak()
 		case ' ', '\t', '\n', '\r', '&', '|', ';', '(', ')':
 			break loop
 		case '\\': // escaped byte follows
@@ -875,7 +1260,9 @@ loop:
 				p.eqlOffs = len(p.litBs) - 1
 			}
 		case '[':
-			if p.lang != LangPOSIX && len(p.litBs) > 1 && p.litBs[0] != '[' {
+			// "a[" may start an array index, but "a[[:alpha:]]" can
+			// only be a bracket expression with a character class.
+			if p.lang.in(LangBash, LangMirBSDKorn) && len(p.litBs) > 1 && p.litBs[0] != '[' && !p.peekCharClass() {
 				tok = _Lit
 				break loop
 			}
@@ -884,6 +1271,47 @@ loop:
 	p.tok, p.val = tok, p.endLit()
 }
 
+// peekCharClass reports whether the '[' just read starts a bracket expression
+// with a character class, like in "a[[:alpha:]]", or the class itself.
+func (p *Parser) peekCharClass() bool {
+	if p.litBs[len(p.litBs)-2] == '[' {
+		return p.peekByte(':')
+	}
+	// like peekCRLF, but the reader may give us a single byte at a time
+	for p.bsp+1 >= len(p.bs) && p.readErr == nil {
+		p.fill()
+	}
+	return p.bsp+1 < len(p.bs) && p.bs[p.bsp] == '[' && p.bs[p.bsp+1] == ':'
+}
+
+// litBreak is a line continuation within a literal token.
+type litBreak struct {
+	offs int // offset within the literal's value
+	end  Pos // position of the backslash
+	pos  Pos // position of the literal's next character
+}
+
+// addLitBreak records the line continuation just read as part of the current
+// literal, so that the parser can keep it by splitting the literal.
+func (p *Parser) addLitBreak() {
+	if len(p.aliasFrames) > 0 {
+		return // all positions are the alias name's
+	}
+	p.litBreaks = append(p.litBreaks, litBreak{
+		offs: len(p.litBs),
+		end: Pos{
+			offs: uint32(p.escNewlOffs),
+			line: p.npos.line,
+			col:  p.npos.col,
+		},
+		pos: Pos{
+			offs: uint32(p.offs + p.offsShift + p.bsp),
+			line: p.npos.line + 1,
+			col:  1,
+		},
+	})
+}
+
 func (p *Parser) advanceLitDquote(r rune) {
 	tok := _LitWord
 loop:
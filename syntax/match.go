@@ -0,0 +1,162 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A Matcher matches statements against a pattern, which is a statement
+// written in shell with wildcards, such as "rm -rf $_/".
+//
+// Wildcards are parameter expansions in their short form whose name starts
+// with an underscore, like "$_" or "$_dir". A wildcard making up an entire
+// word matches any word, or any expression where an arithmetic or test
+// expression is expected, as in "$(($_ + 1))". A wildcard which is part of a
+// word matches any single word part. For example, "rm -rf $_/" matches
+// "rm -rf $dir/" and "rm -rf "$HOME"/", but not "rm -rf /tmp/" or
+// "rm -rf $a$b/".
+//
+// The wildcard "$_" matches anything, while others like "$_dir" bind what
+// they matched to their name without the underscore, such as "dir". A named
+// wildcard used more than once must match equal nodes each time, as per
+// Equal.
+//
+// Everything else in the pattern must match exactly, ignoring positions and
+// comments. For example, "foo bar" doesn't match "foo  bar  baz", nor
+// "foo bar &".
+type Matcher struct {
+	pattern *Stmt
+}
+
+// NewMatcher parses a pattern made up of a single statement with the given
+// parser options, returning a Matcher for it. See Matcher for the syntax of
+// wildcards.
+func NewMatcher(pattern string, options ...ParserOption) (*Matcher, error) {
+	f, err := NewParser(options...).Parse(strings.NewReader(pattern), "")
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Stmts) != 1 {
+		return nil, fmt.Errorf("pattern must be a single statement, found %d", len(f.Stmts))
+	}
+	return &Matcher{pattern: f.Stmts[0]}, nil
+}
+
+// A Match is a statement matched by a Matcher, along with the nodes matched by
+// its named wildcards.
+type Match struct {
+	Stmt  *Stmt
+	Binds map[string]Node
+}
+
+// Match reports whether the node is a statement matching the pattern. If so,
+// the nodes matched by the named wildcards are returned too.
+func (m *Matcher) Match(node Node) (binds map[string]Node, ok bool) {
+	s, ok := node.(*Stmt)
+	if !ok {
+		return nil, false
+	}
+	mt := &matcher{binds: make(map[string]Node)}
+	if !mt.value(reflect.ValueOf(m.pattern), reflect.ValueOf(s)) {
+		return nil, false
+	}
+	return mt.binds, true
+}
+
+// FindAll returns all the statements matching the pattern in a syntax tree,
+// in the order that Walk finds them in. Statements within a matched statement
+// are matched too.
+func (m *Matcher) FindAll(root Node) []Match {
+	var matches []Match
+	Walk(root, func(node Node) bool {
+		if binds, ok := m.Match(node); ok {
+			matches = append(matches, Match{Stmt: node.(*Stmt), Binds: binds})
+		}
+		return true
+	})
+	return matches
+}
+
+type matcher struct {
+	binds map[string]Node
+}
+
+var commentType = reflect.TypeOf(Comment{})
+
+// value is like equalValue, but with x being part of the pattern.
+func (m *matcher) value(x, y reflect.Value) bool {
+	if x.Type() != y.Type() {
+		return false
+	}
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			return x.IsNil() == y.IsNil()
+		}
+		if name, ok := wildcard(x.Interface()); ok {
+			return m.bind(name, y.Interface().(Node))
+		}
+		if x.Kind() == reflect.Interface && !x.Elem().Type().Implements(nodeType) {
+			return reflect.DeepEqual(x.Interface(), y.Interface())
+		}
+		return m.value(x.Elem(), y.Elem())
+	case reflect.Slice:
+		if x.Type().Elem() == commentType {
+			return true
+		}
+		if x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !m.value(x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if x.Type() == posType {
+			return true
+		}
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if !m.value(x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return x.Interface() == y.Interface()
+}
+
+func (m *matcher) bind(name string, node Node) bool {
+	if name == "" {
+		return true
+	}
+	if prev, ok := m.binds[name]; ok {
+		return Equal(prev, node)
+	}
+	m.binds[name] = node
+	return true
+}
+
+// wildcard reports whether x is a wildcard in a pattern, either a word or a
+// word part, returning its name without the leading underscore.
+func wildcard(x interface{}) (string, bool) {
+	if w, ok := x.(*Word); ok {
+		if len(w.Parts) != 1 {
+			return "", false
+		}
+		x = w.Parts[0]
+	}
+	pe, ok := x.(*ParamExp)
+	if !ok || !pe.Short || !strings.HasPrefix(pe.Param.Value, "_") {
+		return "", false
+	}
+	return pe.Param.Value[1:], true
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var matchTests = []struct {
+	pattern, in string
+	want        bool
+	binds       map[string]string
+}{
+	{"foo", "foo", true, nil},
+	{"foo", "bar", false, nil},
+	{"foo bar", "foo  bar", true, nil},
+	{"foo bar", "foo bar baz", false, nil},
+	{"foo bar", "foo bar &", false, nil},
+	{"foo bar", "# doc\nfoo bar # trailing", true, nil},
+	{"rm -rf $_/", "rm -rf $dir/", true, nil},
+	{"rm -rf $_/", `rm -rf "$HOME"/`, true, nil},
+	{"rm -rf $_/", "rm -rf /tmp/", false, nil},
+	{"rm -rf $_/", "rm -rf $a$b/", false, nil},
+	{"rm -rf $_", "rm -rf $a$b/", true, nil},
+	{"rm -rf $_dir", "rm -rf $a$b/", true, map[string]string{"dir": "$a$b/"}},
+	{"$_cmd $_arg", "foo bar", true, map[string]string{"cmd": "foo", "arg": "bar"}},
+	{"$_x $_x", "foo foo", true, map[string]string{"x": "foo"}},
+	{"$_x $_x", "foo bar", false, nil},
+	{`echo "$_v"`, `echo "$(date)"`, true, map[string]string{"v": "$(date)"}},
+	{"curl $_ | sh", "curl -fsSL example.com | sh", false, nil},
+	{"curl $_ $_ | sh", "curl -fsSL example.com | sh", true, nil},
+	{"if $_cond; then $_; fi", "if [ -f x ]; then foo; fi", false, nil},
+	{"if $_cond; then $_; fi", "if ok; then foo; fi", true, map[string]string{"cond": "ok"}},
+	{"$(($_ + 1))", "$((x + 1))", true, nil},
+	{"$(($_ + 1))", "$((x * 2 + 1))", true, nil},
+	{"$(($_ + 1))", "$((x * (2 + 1)))", false, nil},
+	{"[[ $_x && -f $_ ]]", "[[ -n $a || $b && -f c ]]", false, nil},
+	{"[[ $_x && -f $_ ]]", "[[ ( -n $a || $b ) && -f c ]]", true, map[string]string{"x": "( -n $a || $b )"}},
+	{"$_", "foo bar", false, nil},
+	{"$_", "{ foo; }", false, nil},
+}
+
+func TestMatcher(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	for i, tc := range matchTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			m, err := NewMatcher(tc.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			binds, got := m.Match(f.Stmts[0])
+			if got != tc.want {
+				t.Fatalf("Match of %q against %q got %t, want %t", tc.pattern, tc.in, got, tc.want)
+			}
+			if len(binds) != len(tc.binds) {
+				t.Fatalf("Unexpected binds: %v", binds)
+			}
+			for name, want := range tc.binds {
+				if got := NodeText([]byte(tc.in), binds[name]); got != want {
+					t.Fatalf("Bind %q mismatch: want %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMatcherFindAll(t *testing.T) {
+	t.Parallel()
+	m, err := NewMatcher("rm -rf $_dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := "rm -rf a\nfoo() {\n\trm -rf $b\n}\nx=$(rm -rf c)\nrm -f d"
+	f, err := NewParser().Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, match := range m.FindAll(f) {
+		got = append(got, fmt.Sprintf("%s %s", match.Stmt.Pos(), NodeText([]byte(in), match.Binds["dir"])))
+	}
+	want := "1:1 a, 3:2 $b, 5:5 c"
+	if strings.Join(got, ", ") != want {
+		t.Fatalf("FindAll mismatch: want %q, got %q", want, got)
+	}
+	if _, ok := m.Match(f); ok {
+		t.Fatalf("Match of a File should fail")
+	}
+
+	for _, pattern := range []string{"", "foo; bar", "foo)"} {
+		if _, err := NewMatcher(pattern); err == nil {
+			t.Fatalf("Expected an error for the pattern %q", pattern)
+		}
+	}
+}
@@ -5,6 +5,8 @@ package syntax
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -23,13 +25,131 @@ type Node interface {
 type File struct {
 	Name string
 
+	// BOM is whether the file started with a UTF-8 byte order mark. The
+	// mark is skipped when parsing, but it still counts towards offsets.
+	// The printer writes it back.
+	BOM bool
+
+	// Shebang is the interpreter line at the very start of the file, if
+	// any. It is set whether or not comments are kept.
+	Shebang *Shebang
+
+	// Variant is the shell language variant the file was parsed as. With
+	// LangAuto, it is the one picked from the shebang.
+	Variant LangVariant
+
 	Stmts []*Stmt
 	Last  []Comment
+
+	// lines holds the offsets at which each line after the first starts,
+	// as recorded by the parser.
+	lines []uint32
+
+	// src holds the input source, if the parser was given KeepSource.
+	src []byte
 }
 
 func (f *File) Pos() Pos { return stmtsPos(f.Stmts, f.Last) }
 func (f *File) End() Pos { return stmtsEnd(f.Stmts, f.Last) }
 
+func (f *File) addLine(offs int) {
+	if n := len(f.lines); n > 0 && f.lines[n-1] >= uint32(offs) {
+		return // already seen, e.g. when recovering from an error
+	}
+	f.lines = append(f.lines, uint32(offs))
+}
+
+// LineCount returns the number of lines in the file, as seen by the parser.
+// A file built without the parser counts as a single line.
+func (f *File) LineCount() uint { return uint(len(f.lines)) + 1 }
+
+// LineStart returns the position at which a line starts, with line numbers
+// starting at 1. It returns an invalid position if the line is not in the
+// file.
+func (f *File) LineStart(line uint) Pos {
+	switch {
+	case line == 0 || line > f.LineCount():
+		return Pos{}
+	case line == 1:
+		return Pos{line: 1, col: 1}
+	}
+	return Pos{offs: f.lines[line-2], line: uint16(line), col: 1}
+}
+
+// OffsetPos returns the position of a byte offset in the file, such that
+// f.OffsetPos(pos.Offset()) has the same line as any pos in the file. Its
+// column counts the bytes from the start of the line, which is the same as
+// the parser's columns except where those skip escaping backslashes, like
+// within backquotes. Together with LineStart, it avoids having to keep the
+// source around to translate between offsets and lines.
+func (f *File) OffsetPos(offset uint) Pos {
+	// the number of lines starting at or before offset
+	i := sort.Search(len(f.lines), func(i int) bool {
+		return uint(f.lines[i]) > offset
+	})
+	start := uint(0)
+	if i > 0 {
+		start = uint(f.lines[i-1])
+	}
+	return Pos{offs: uint32(offset), line: uint16(i + 1), col: uint16(offset - start + 1)}
+}
+
+// Source returns the exact source text of a node in the file, from its
+// position up to its end, if the file was parsed with KeepSource. Otherwise, or
+// if the node has no valid positions within the file, an empty string is
+// returned. See NodeText to use a source kept elsewhere.
+//
+// Note that a statement's source doesn't include its comments.
+func (f *File) Source(node Node) string {
+	if f.src == nil {
+		return ""
+	}
+	return NodeText(f.src, node)
+}
+
+// Replace returns a copy of the file's source with the source text of a node
+// replaced by text, leaving the rest of the source untouched. Like Source, it
+// requires the file to be parsed with KeepSource. If the source isn't
+// available, or if the node has no valid positions within the file, nil is
+// returned.
+func (f *File) Replace(node Node, text string) []byte {
+	start, end, ok := sourceRange(f.src, node)
+	if f.src == nil || !ok {
+		return nil
+	}
+	src := make([]byte, 0, len(f.src)-int(end-start)+len(text))
+	src = append(src, f.src[:start]...)
+	src = append(src, text...)
+	return append(src, f.src[end:]...)
+}
+
+// NodeText returns the source text of a node, given the entire source it was
+// parsed from, using the offsets of the node's position and end. This is
+// useful to quote the exact source in diagnostics, without printing the node.
+// If the node has no valid positions within src, an empty string is returned.
+func NodeText(src []byte, node Node) string {
+	start, end, ok := sourceRange(src, node)
+	if !ok {
+		return ""
+	}
+	return string(src[start:end])
+}
+
+func sourceRange(src []byte, node Node) (start, end uint, ok bool) {
+	if node == nil {
+		return 0, 0, false
+	}
+	pos, endPos := node.Pos(), node.End()
+	if !pos.IsValid() || !endPos.IsValid() {
+		return 0, 0, false
+	}
+	start, end = pos.Offset(), endPos.Offset()
+	if start > end || end > uint(len(src)) {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
 func stmtsPos(stmts []*Stmt, last []Comment) Pos {
 	if len(stmts) > 0 {
 		s := stmts[0]
@@ -64,7 +184,9 @@ func stmtsEnd(stmts []*Stmt, last []Comment) Pos {
 	return Pos{}
 }
 
-// Pos is a position within a shell source file.
+// Pos is a position within a shell source file. Since it includes the byte
+// offset from the start of the file, the source of any node can be sliced as
+// src[node.Pos().Offset():node.End().Offset()].
 type Pos struct {
 	offs      uint32
 	line, col uint16
@@ -78,7 +200,8 @@ func (p Pos) Offset() uint { return uint(p.offs) }
 func (p Pos) Line() uint { return uint(p.line) }
 
 // Col returns the column number of the position, starting at 1. It counts in
-// bytes.
+// bytes, not characters, so "é" takes up two columns. The column in characters
+// can be found by counting the runes in the line before Offset.
 func (p Pos) Col() uint { return uint(p.col) }
 
 func (p Pos) String() string {
@@ -115,9 +238,32 @@ type Comment struct {
 func (c *Comment) Pos() Pos { return c.Hash }
 func (c *Comment) End() Pos { return posAddCol(c.Hash, 1+len(c.Text)) }
 
+// Shebang represents the interpreter line at the start of a file, such as
+// "#!/usr/bin/env bash". Text would be "/usr/bin/env bash", which is split
+// into Path "/usr/bin/env" and Args ["bash"].
+type Shebang struct {
+	Hash Pos
+	Text string
+	Path string
+	Args []string
+}
+
+func (s *Shebang) Pos() Pos { return s.Hash }
+func (s *Shebang) End() Pos { return posAddCol(s.Hash, 2+len(s.Text)) }
+
 // Stmt represents a statement, also known as a "complete command". It is
 // compromised of a command and other components that may come before or after
 // it.
+//
+// Comments holds the comments attached to the statement when parsing with
+// KeepComments. They are the ones before it, which usually document it, and
+// the one at the end of its line, if any. Since they are part of the node,
+// moving the statement elsewhere moves its comments with it. See
+// LeadingComments and TrailingComments.
+//
+// Semicolon is the position of the operator terminating the statement, if
+// any. Operators joining statements, such as "&&" or "|", are instead found
+// in BinaryCmd.OpPos, as the statements they join form a BinaryCmd.
 type Stmt struct {
 	Comments   []Comment
 	Cmd        Command
@@ -127,6 +273,9 @@ type Stmt struct {
 	Background bool // stmt &
 	Coprocess  bool // mksh's |&
 
+	// Redirs holds the statement's redirections in the order they appear
+	// in. Their positions tell where they are among the assignments and
+	// arguments of a CallExpr, as in "a=b >f cmd <g arg".
 	Redirs []*Redirect // stmt >a <b
 }
 
@@ -152,12 +301,53 @@ func (s *Stmt) End() Pos {
 	return end
 }
 
+// LeadingComments returns the comments attached to the statement which are
+// placed before it, such as "# doc" in "# doc\nfoo".
+func (s *Stmt) LeadingComments() []Comment {
+	for i, c := range s.Comments {
+		if c.Pos().After(s.Position) {
+			return s.Comments[:i]
+		}
+	}
+	return s.Comments
+}
+
+// TrailingComments returns the comments attached to the statement which are
+// placed after its start, such as "# bar" in "foo # bar".
+func (s *Stmt) TrailingComments() []Comment {
+	return s.Comments[len(s.LeadingComments()):]
+}
+
+// BlankLines returns how many empty lines separate two consecutive statements
+// in a list, prev and next, in the source they were parsed from. The lines of
+// comments attached to next aren't empty. Statements without positions, such
+// as those built by hand, are not separated by any empty lines.
+func BlankLines(prev, next *Stmt) int {
+	prevEnd, nextPos := prev.End(), next.Pos()
+	if !prevEnd.IsValid() || !nextPos.IsValid() {
+		return 0
+	}
+	n := int(nextPos.Line()) - int(prevEnd.Line()) - 1
+	lastLine := prevEnd.Line()
+	for _, c := range next.LeadingComments() {
+		if line := c.Pos().Line(); line > lastLine && line < nextPos.Line() {
+			n--
+			lastLine = line
+		}
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
 // Command represents all nodes that are simple or compound commands, including
 // function declarations.
 //
 // These are *CallExpr, *IfClause, *WhileClause, *ForClause, *CaseClause,
 // *Block, *Subshell, *BinaryCmd, *FuncDecl, *ArithmCmd, *TestClause,
-// *DeclClause, *LetClause, *TimeClause, and *CoprocClause.
+// *DeclClause, *LetClause, *TimeClause, *CoprocClause, *TestDecl, and
+// *BadStmt.
 type Command interface {
 	Node
 	commandNode()
@@ -178,6 +368,8 @@ func (*DeclClause) commandNode()   {}
 func (*LetClause) commandNode()    {}
 func (*TimeClause) commandNode()   {}
 func (*CoprocClause) commandNode() {}
+func (*TestDecl) commandNode()     {}
+func (*BadStmt) commandNode()      {}
 
 // Assign represents an assignment to a variable.
 //
@@ -223,12 +415,21 @@ func (a *Assign) End() Pos {
 }
 
 // Redirect represents an input/output redirection.
+//
+// For here-documents, Hdoc holds the body as it appears in the source. With
+// DashHdoc, the leading tabs are kept in the body, so that it may be printed
+// back as-is; they are only removed when the here-document is expanded.
+//
+// If any part of the here-document word was quoted, HdocQuoted is set and
+// Hdoc is a single literal which must not be expanded.
 type Redirect struct {
 	OpPos Pos
 	Op    RedirOperator
 	N     *Lit  // fd>, or {varname}> in Bash
 	Word  *Word // >word
 	Hdoc  *Word // here-document body
+
+	HdocQuoted bool
 }
 
 func (r *Redirect) Pos() Pos {
@@ -269,6 +470,33 @@ func (c *CallExpr) End() Pos {
 	return c.Args[len(c.Args)-1].End()
 }
 
+// ScriptArgs returns the arguments of a call which are shell programs to be
+// parsed and run by the shell later on, such as the single argument in
+// "eval 'foo; bar'" or the action in "trap 'cleanup' EXIT". Only simple uses
+// of the eval and trap builtins are recognised. To parse the arguments, see
+// Parser.Reparse.
+func (c *CallExpr) ScriptArgs() []*Word {
+	if len(c.Args) == 0 {
+		return nil
+	}
+	args := c.Args[1:]
+	if len(args) > 0 && args[0].Lit() == "--" {
+		args = args[1:]
+	}
+	switch c.Args[0].Lit() {
+	case "eval":
+		if len(args) == 1 {
+			return args
+		}
+	case "trap":
+		// "trap -p", "trap - SIG", and "trap SIG" don't set an action
+		if len(args) > 1 && !strings.HasPrefix(args[0].Lit(), "-") {
+			return args[:1]
+		}
+	}
+	return nil
+}
+
 // Subshell represents a series of commands that should be executed in a nested
 // shell environment.
 type Subshell struct {
@@ -381,8 +609,16 @@ func (c *CStyleLoop) Pos() Pos { return c.Lparen }
 func (c *CStyleLoop) End() Pos { return posAddCol(c.Rparen, 2) }
 
 // BinaryCmd represents a binary expression between two statements.
+//
+// Pipelines such as "a | b | c" are represented as nested BinaryCmd nodes with
+// the Pipe or PipeAll operators, the leftmost commands being the deepest. The
+// attributes of a pipeline as a whole are recorded on the statement holding
+// the outermost BinaryCmd, such as Stmt.Negated for "! a | b", or on its parent
+// TimeClause for "time a | b". See Pipeline to obtain its commands in order.
+//
+// And-or lists such as "a && b || c" are nested in the same way. See AndOr.
 type BinaryCmd struct {
-	OpPos Pos
+	OpPos Pos // position of the operator, such as "&&" or "|"
 	Op    BinCmdOperator
 	X, Y  *Stmt
 }
@@ -390,10 +626,49 @@ type BinaryCmd struct {
 func (b *BinaryCmd) Pos() Pos { return b.X.Pos() }
 func (b *BinaryCmd) End() Pos { return b.Y.End() }
 
+// Pipeline returns the statements making up the pipeline that b is the root
+// of, in order. For example, it returns three statements for "a | b |& c". If
+// b is not a pipe, Pipeline returns nil.
+func (b *BinaryCmd) Pipeline() []*Stmt {
+	if !b.isPipe() {
+		return nil
+	}
+	stmts, _ := b.flatten((*BinaryCmd).isPipe)
+	return stmts
+}
+
+// AndOr returns the statements making up the and-or list that b is the root
+// of, in evaluation order, along with the BinaryCmd nodes holding the operators
+// and their positions. ops[i] is the operator between stmts[i] and
+// stmts[i+1]. For example, "a && b || c" results in three statements and two
+// operators. Pipelines within the list are kept as single statements. If b is
+// not an AndStmt or OrStmt, AndOr returns nil slices.
+func (b *BinaryCmd) AndOr() (stmts []*Stmt, ops []*BinaryCmd) {
+	if b.isPipe() {
+		return nil, nil
+	}
+	return b.flatten(func(b *BinaryCmd) bool { return !b.isPipe() })
+}
+
+// flatten returns the statements in the left-nested chain of BinaryCmd nodes
+// rooted at b for which the match func returns true.
+func (b *BinaryCmd) flatten(match func(*BinaryCmd) bool) (stmts []*Stmt, ops []*BinaryCmd) {
+	if x, ok := b.X.Cmd.(*BinaryCmd); ok && match(x) &&
+		!b.X.Negated && !b.X.Background && len(b.X.Redirs) == 0 {
+		stmts, ops = x.flatten(match)
+	} else {
+		stmts = append(stmts, b.X)
+	}
+	return append(stmts, b.Y), append(ops, b)
+}
+
+func (b *BinaryCmd) isPipe() bool { return b.Op == Pipe || b.Op == PipeAll }
+
 // FuncDecl represents the declaration of a function.
 type FuncDecl struct {
 	Position Pos
-	RsrvWord bool // non-posix "function f()" style
+	RsrvWord bool // non-posix "function f" style
+	Parens   bool // with () parentheses, only meaningful with RsrvWord=true
 	Name     *Lit
 	Body     *Stmt
 }
@@ -411,12 +686,12 @@ type Word struct {
 func (w *Word) Pos() Pos { return w.Parts[0].Pos() }
 func (w *Word) End() Pos { return w.Parts[len(w.Parts)-1].End() }
 
-// Lit returns the word as a literal value, if the word consists of *Lit nodes
-// only. An empty string is returned otherwise. Words with multiple literals,
-// which can appear in some edge cases, are handled properly.
+// Lit returns the word as a literal value, if the word consists of *Lit and
+// *Tilde nodes only. An empty string is returned otherwise. Words with multiple
+// literals, which can appear in some edge cases, are handled properly.
 //
-// For example, the word "foo" will return "foo", but the word "foo${bar}" will
-// return "".
+// For example, the words "foo" and "~/foo" will return "foo" and "~/foo", but
+// the word "foo${bar}" will return "".
 func (w *Word) Lit() string {
 	// In the usual case, we'll have either a single part that's a literal,
 	// or one of the parts being a non-literal. Using strings.Join instead
@@ -424,25 +699,29 @@ func (w *Word) Lit() string {
 	// part is a shortcut, and many parts don't incur string copies.
 	lits := make([]string, 0, 1)
 	for _, part := range w.Parts {
-		lit, ok := part.(*Lit)
-		if !ok {
+		switch x := part.(type) {
+		case *Lit:
+			lits = append(lits, x.Value)
+		case *Tilde:
+			lits = append(lits, "~"+x.User)
+		default:
 			return ""
 		}
-		lits = append(lits, lit.Value)
 	}
 	return strings.Join(lits, "")
 }
 
 // WordPart represents all nodes that can form part of a word.
 //
-// These are *Lit, *SglQuoted, *DblQuoted, *ParamExp, *CmdSubst, *ArithmExp,
-// *ProcSubst, and *ExtGlob.
+// These are *Lit, *Tilde, *SglQuoted, *DblQuoted, *ParamExp, *CmdSubst,
+// *ArithmExp, *ProcSubst, *ExtGlob, and *BraceExp.
 type WordPart interface {
 	Node
 	wordPartNode()
 }
 
 func (*Lit) wordPartNode()       {}
+func (*Tilde) wordPartNode()     {}
 func (*SglQuoted) wordPartNode() {}
 func (*DblQuoted) wordPartNode() {}
 func (*ParamExp) wordPartNode()  {}
@@ -465,6 +744,18 @@ type Lit struct {
 func (l *Lit) Pos() Pos { return l.ValuePos }
 func (l *Lit) End() Pos { return l.ValueEnd }
 
+// Tilde represents a tilde prefix at the start of a word, such as "~" or
+// "~user". The prefix ends at the first slash, which is left to the following
+// word part. In assignment values like "PATH=~/bin:~/sbin", a prefix may also
+// follow a colon, and it ends at the next colon too.
+type Tilde struct {
+	ValuePos Pos
+	User     string // empty for the current user
+}
+
+func (t *Tilde) Pos() Pos { return t.ValuePos }
+func (t *Tilde) End() Pos { return posAddCol(t.ValuePos, 1+len(t.User)) }
+
 // SglQuoted represents a string within single quotes.
 type SglQuoted struct {
 	Left, Right Pos
@@ -527,6 +818,41 @@ func (p *ParamExp) End() Pos {
 	return p.Param.End()
 }
 
+// IsSpecial reports whether the expansion refers to one of the special
+// parameters "@", "*", "#", "?", "-", "$", "!" or "0". Positional parameters
+// are reported by Positional instead.
+func (p *ParamExp) IsSpecial() bool {
+	if p.Param == nil {
+		return false
+	}
+	switch p.Param.Value {
+	case "@", "*", "#", "?", "-", "$", "!", "0":
+		return true
+	}
+	return false
+}
+
+// Positional returns the number of the positional parameter the expansion
+// refers to, such as 1 for $1 or 10 for ${10}. If the expansion is not of a
+// positional parameter, ok is false.
+func (p *ParamExp) Positional() (n int, ok bool) {
+	if p.Param == nil {
+		return 0, false
+	}
+	return positionalParam(p.Param.Value)
+}
+
+func positionalParam(name string) (int, bool) {
+	if name == "" || !numberLiteral(name) {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return n, true
+}
+
 func (p *ParamExp) nakedIndex() bool {
 	return p.Short && p.Index != nil
 }
@@ -554,7 +880,7 @@ type Expansion struct {
 // ArithmExp represents an arithmetic expansion.
 type ArithmExp struct {
 	Left, Right Pos
-	Bracket     bool // deprecated $[expr] form
+	Bracket     bool // deprecated $[expr] form; see Simplify
 	Unsigned    bool // mksh's $((# expr))
 
 	X ArithmExpr
@@ -568,7 +894,9 @@ func (a *ArithmExp) End() Pos {
 	return posAddCol(a.Right, 2)
 }
 
-// ArithmCmd represents an arithmetic command.
+// ArithmCmd represents an arithmetic command, such as "((i++))". Its exit
+// status is zero if the expression evaluates to a non-zero value, and one
+// otherwise.
 //
 // This node will only appear in LangBash and LangMirBSDKorn.
 type ArithmCmd struct {
@@ -756,6 +1084,41 @@ func (d *DeclClause) End() Pos {
 	return d.Variant.End()
 }
 
+// Nameref reports whether the clause declares nameref variables, either via
+// the "nameref" variant or via a literal "-n" option such as in "declare -n"
+// or "local -rn". A later "+n" option cancels an earlier "-n". Options that
+// are only known at run-time, such as "declare $opts", are not considered.
+func (d *DeclClause) Nameref() bool {
+	nameref := d.Variant.Value == "nameref"
+	for _, as := range d.Args {
+		if !as.Naked || as.Name != nil {
+			continue
+		}
+		opt := as.Value.Lit()
+		if len(opt) < 2 || (opt[0] != '-' && opt[0] != '+') {
+			continue
+		}
+		if strings.ContainsRune(opt[1:], 'n') {
+			nameref = opt[0] == '-'
+		}
+	}
+	return nameref
+}
+
+// NamerefTarget returns the name of the variable that an assignment in a
+// nameref clause refers to, such as "bar" in "declare -n foo=bar". It returns
+// the empty string if the clause does not declare namerefs, or if the value
+// is not a literal valid name.
+func (d *DeclClause) NamerefTarget(as *Assign) string {
+	if as.Naked || as.Value == nil || !d.Nameref() {
+		return ""
+	}
+	if name := as.Value.Lit(); ValidName(name) {
+		return name
+	}
+	return ""
+}
+
 // ArrayExpr represents a Bash array expression.
 //
 // This node will only appear with LangBash.
@@ -851,6 +1214,33 @@ type CoprocClause struct {
 func (c *CoprocClause) Pos() Pos { return c.Coproc }
 func (c *CoprocClause) End() Pos { return c.Stmt.End() }
 
+// TestDecl represents the declaration of a Bats test function.
+//
+// This node will only appear with LangBats.
+type TestDecl struct {
+	Position    Pos
+	Description *Word
+	Body        *Stmt
+}
+
+func (t *TestDecl) Pos() Pos { return t.Position }
+func (t *TestDecl) End() Pos { return t.Body.End() }
+
+// BadStmt is a placeholder for a statement which could not be parsed because
+// of a syntax error, covering the source from the start of the statement to
+// the end of the line where the error was found.
+//
+// This node will only appear when using RecoverErrors. When printing a File,
+// its source is printed as is if it was kept via KeepSource; otherwise, the
+// statement is dropped.
+type BadStmt struct {
+	From, To Pos
+	Err      error // the error found while parsing
+}
+
+func (b *BadStmt) Pos() Pos { return b.From }
+func (b *BadStmt) End() Pos { return b.To }
+
 // LetClause represents a Bash let clause.
 //
 // This node will only appear in LangBash and LangMirBSDKorn.
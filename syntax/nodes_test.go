@@ -5,8 +5,10 @@ package syntax
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestPosition(t *testing.T) {
@@ -33,6 +35,10 @@ func TestPosition(t *testing.T) {
 					lines: strings.Split(in, "\n"),
 				}
 				Walk(prog, v.Visit)
+				if want := uint(len(v.lines)); prog.LineCount() != want {
+					t.Fatalf("Unexpected LineCount in %q: want %d, got %d",
+						in, want, prog.LineCount())
+				}
 			})
 		}
 	}
@@ -52,6 +58,24 @@ func (v *posWalker) Visit(n Node) bool {
 	if !p.IsValid() && len(v.f.Stmts) > 0 {
 		v.t.Fatalf("Invalid Pos")
 	}
+	// The parser's columns skip some escaping backslashes, like those
+	// within backquotes, so only compare them without any backslashes.
+	exactCols := !strings.Contains(strings.Join(v.lines, "\n"), "\\")
+	for _, pos := range []Pos{p, n.End()} {
+		if !pos.IsValid() {
+			continue
+		}
+		got := v.f.OffsetPos(pos.Offset())
+		if got.Line() != pos.Line() || (exactCols && got != pos) {
+			v.t.Fatalf("OffsetPos(%d) mismatch in %T: want %s, got %s",
+				pos.Offset(), n, pos, got)
+		}
+		if start := v.f.LineStart(pos.Line()); start.Offset() > pos.Offset() ||
+			(exactCols && start.Offset()+pos.Col()-1 != pos.Offset()) {
+			v.t.Fatalf("LineStart(%d) mismatch in %T: got offset %d for %s at %d",
+				pos.Line(), n, start.Offset(), pos, pos.Offset())
+		}
+	}
 	if c, ok := n.(*Comment); ok {
 		if v.f.Pos().After(c.Pos()) {
 			v.t.Fatalf("A Comment is before its File")
@@ -63,6 +87,272 @@ func (v *posWalker) Visit(n Node) bool {
 	return true
 }
 
+func TestFileLines(t *testing.T) {
+	t.Parallel()
+	f, err := NewParser().Parse(strings.NewReader("foo\n\nbar \\\nbaz\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.LineCount(); got != 5 {
+		t.Fatalf("Unexpected LineCount: want 5, got %d", got)
+	}
+	for line, want := range []string{"0:0", "1:1", "2:1", "3:1", "4:1", "5:1", "0:0"} {
+		if got := f.LineStart(uint(line)).String(); got != want {
+			t.Fatalf("LineStart(%d) mismatch: want %s, got %s", line, want, got)
+		}
+	}
+	for offs, want := range map[uint]string{0: "1:1", 3: "1:4", 4: "2:1", 9: "3:5", 11: "4:1"} {
+		if got := f.OffsetPos(offs).String(); got != want {
+			t.Fatalf("OffsetPos(%d) mismatch: want %s, got %s", offs, want, got)
+		}
+	}
+
+	f = &File{}
+	if got := f.LineCount(); got != 1 {
+		t.Fatalf("Unexpected LineCount: want 1, got %d", got)
+	}
+	if got := f.OffsetPos(3).String(); got != "1:4" {
+		t.Fatalf("OffsetPos(3) mismatch: want 1:4, got %s", got)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	t.Parallel()
+	in := utf8BOM + "foo   bar # baz\nif x; then\n\techo \"${a:-b}\"  >out\nfi\n" +
+		strings.Repeat("#", 2*bufSize) + "\nlast  $((1 +  2))\n"
+	f, err := NewParser(KeepSource(true)).Parse(iotest.OneByteReader(strings.NewReader(in)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(f.src); got != in {
+		t.Fatalf("Unexpected source: %q", got)
+	}
+	ifc := f.Stmts[1].Cmd.(*IfClause)
+	last := f.Stmts[2]
+	tests := []struct {
+		node Node
+		want string
+	}{
+		{f.Stmts[0], "foo   bar"},
+		{f.Stmts[0].Cmd.(*CallExpr).Args[1], "bar"},
+		{ifc, "if x; then\n\techo \"${a:-b}\"  >out\nfi"},
+		{ifc.Then[0], `echo "${a:-b}"  >out`},
+		{ifc.Then[0].Cmd.(*CallExpr).Args[1].Parts[0].(*DblQuoted).Parts[0], "${a:-b}"},
+		{ifc.Then[0].Redirs[0], ">out"},
+		{last, "last  $((1 +  2))"},
+		{last.Cmd.(*CallExpr).Args[1].Parts[0].(*ArithmExp).X, "1 +  2"},
+		{&Lit{}, ""},
+		{nil, ""},
+	}
+	for i, tc := range tests {
+		if got := f.Source(tc.node); got != tc.want {
+			t.Fatalf("%02d: Source mismatch: want %q, got %q", i, tc.want, got)
+		}
+	}
+	want := strings.Replace(in, `"${a:-b}"`, "'c'", 1)
+	word := ifc.Then[0].Cmd.(*CallExpr).Args[1]
+	if got := string(f.Replace(word, "'c'")); got != want {
+		t.Fatalf("Replace mismatch:\nwant %q\ngot  %q", want, got)
+	}
+	if got := f.Source(word); got != `"${a:-b}"` {
+		t.Fatalf("Replace modified the file's source: %q", got)
+	}
+
+	f, err = NewParser().Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := NodeText([]byte(in), f.Stmts[1]); got != tests[2].want {
+		t.Fatalf("NodeText mismatch: want %q, got %q", tests[2].want, got)
+	}
+	if got := NodeText([]byte("foo"), f.Stmts[1]); got != "" {
+		t.Fatalf("Unexpected NodeText with a short source: %q", got)
+	}
+	if got := f.Source(f.Stmts[0]); got != "" {
+		t.Fatalf("Unexpected source without KeepSource: %q", got)
+	}
+	if got := f.Replace(f.Stmts[0], "x"); got != nil {
+		t.Fatalf("Unexpected Replace without KeepSource: %q", got)
+	}
+}
+
+func TestSeparatorPositions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a; b", []string{"; 1:2"}},
+		{"a & b &", []string{"& 1:3", "& 1:7"}},
+		{"a && b || c;", []string{"; 1:12", "|| 1:8", "&& 1:3"}},
+		{"a | b |& c &", []string{"& 1:12", "|& 1:7", "| 1:3"}},
+		{"a &&\n\tb", []string{"&& 1:3"}},
+		{"{ a; } | b", []string{"| 1:8", "; 1:4"}},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := NewParser().Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			Walk(f, func(node Node) bool {
+				switch x := node.(type) {
+				case *Stmt:
+					if x.Semicolon.IsValid() {
+						op := ";"
+						if x.Background {
+							op = "&"
+						}
+						got = append(got, fmt.Sprintf("%s %s", op, x.Semicolon))
+					}
+				case *BinaryCmd:
+					got = append(got, fmt.Sprintf("%s %s", x.Op, x.OpPos))
+				}
+				return true
+			})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Unexpected separators in %q:\nwant %q\ngot  %q", tc.in, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStmtComments(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in                string
+		leading, trailing []string
+	}{
+		{"foo", nil, nil},
+		{"# doc\nfoo", []string{" doc"}, nil},
+		{"foo # bar", nil, []string{" bar"}},
+		{"# head\n\n# doc\nfoo # bar\n# last", []string{" head", " doc"}, []string{" bar"}},
+		{"{\n\t# doc\n\tfoo\n}", []string{" doc"}, nil},
+	}
+	texts := func(comments []Comment) []string {
+		var list []string
+		for _, c := range comments {
+			list = append(list, c.Text)
+		}
+		return list
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := NewParser(KeepComments(true)).Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var s *Stmt
+			Walk(f, func(node Node) bool {
+				if x, ok := node.(*Stmt); ok {
+					s = x // the innermost, last statement
+				}
+				return true
+			})
+			if got := texts(s.LeadingComments()); !reflect.DeepEqual(got, tc.leading) {
+				t.Fatalf("LeadingComments mismatch: want %q, got %q", tc.leading, got)
+			}
+			if got := texts(s.TrailingComments()); !reflect.DeepEqual(got, tc.trailing) {
+				t.Fatalf("TrailingComments mismatch: want %q, got %q", tc.trailing, got)
+			}
+		})
+	}
+}
+
+func TestBlankLines(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"a; b", 0},
+		{"a\nb", 0},
+		{"a\n\nb", 1},
+		{"a\n\n\n\nb", 3},
+		{"a # x\n\nb", 1},
+		{"a\n# x\nb", 0},
+		{"a\n\n# x\nb", 1},
+		{"a\n\n# x\n\n# y\nb", 2},
+		{"a \\\n\tc\n\nb", 1},
+		{"cat <<EOF\nx\n\nEOF\n\nb", 1},
+		{"{\n\ta\n}\nb", 0},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := NewParser(KeepComments(true)).Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := BlankLines(f.Stmts[0], f.Stmts[1]); got != tc.want {
+				t.Fatalf("BlankLines mismatch in %q: want %d, got %d", tc.in, tc.want, got)
+			}
+		})
+	}
+	if got := BlankLines(litStmt("a"), litStmt("b")); got != 0 {
+		t.Fatalf("BlankLines mismatch without positions: want 0, got %d", got)
+	}
+}
+
+func TestNodeInterfaces(t *testing.T) {
+	t.Parallel()
+	// Which of the node interfaces each node type implements; the type
+	// system is what keeps, for example, commands out of words.
+	const (
+		cmd = 1 << iota
+		part
+		arithm
+		test
+	)
+	tests := []struct {
+		node Node
+		want int
+	}{
+		{&File{}, 0},
+		{&Stmt{}, 0},
+		{&Comment{}, 0},
+		{&Word{}, arithm | test},
+		{&Assign{}, 0},
+		{&Redirect{}, 0},
+		{&CallExpr{}, cmd},
+		{&IfClause{}, cmd},
+		{&Block{}, cmd},
+		{&BinaryCmd{}, cmd},
+		{&TestDecl{}, cmd},
+		{&BadStmt{}, cmd},
+		{&Lit{}, part},
+		{&DblQuoted{}, part},
+		{&ParamExp{}, part},
+		{&CmdSubst{}, part},
+		{&ArithmExp{}, part},
+		{&BraceExp{}, part},
+		{&BinaryArithm{}, arithm},
+		{&UnaryArithm{}, arithm},
+		{&ParenArithm{}, arithm},
+		{&BinaryTest{}, test},
+		{&UnaryTest{}, test},
+		{&ParenTest{}, test},
+	}
+	for _, tc := range tests {
+		got := 0
+		if _, ok := tc.node.(Command); ok {
+			got |= cmd
+		}
+		if _, ok := tc.node.(WordPart); ok {
+			got |= part
+		}
+		if _, ok := tc.node.(ArithmExpr); ok {
+			got |= arithm
+		}
+		if _, ok := tc.node.(TestExpr); ok {
+			got |= test
+		}
+		if got != tc.want {
+			t.Errorf("%T implements interfaces %04b, want %04b", tc.node, got, tc.want)
+		}
+	}
+}
+
 func TestWeirdOperatorString(t *testing.T) {
 	t.Parallel()
 	op := RedirOperator(1000)
@@ -71,3 +361,221 @@ func TestWeirdOperatorString(t *testing.T) {
 		t.Fatalf("token.String() mismatch: want %s, got %s", want, got)
 	}
 }
+
+func TestParamExpKinds(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in         string
+		special    bool
+		positional int
+	}{
+		{"$a", false, 0},
+		{"${foo_1}", false, 0},
+		{"$@", true, 0},
+		{"$*", true, 0},
+		{"$#", true, 0},
+		{"$?", true, 0},
+		{"$-", true, 0},
+		{"$$", true, 0},
+		{"$!", true, 0},
+		{"$0", true, 0},
+		{"${#}", true, 0},
+		{"${@:-x}", true, 0},
+		{"$1", false, 1},
+		{"$9", false, 9},
+		{"${10}", false, 10},
+		{"${#12}", false, 12},
+	}
+	p := NewParser()
+	for _, tc := range tests {
+		var pe *ParamExp
+		err := p.Words(strings.NewReader(tc.in), func(w *Word) bool {
+			pe = w.Parts[0].(*ParamExp)
+			return false
+		})
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if got := pe.IsSpecial(); got != tc.special {
+			t.Errorf("%q: IsSpecial() got %v, want %v", tc.in, got, tc.special)
+		}
+		n, ok := pe.Positional()
+		if ok != (tc.positional > 0) || n != tc.positional {
+			t.Errorf("%q: Positional() got %d, %v, want %d", tc.in, n, ok, tc.positional)
+		}
+	}
+}
+
+func TestDeclClauseNameref(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in      string
+		nameref bool
+		targets []string
+	}{
+		{"declare foo=bar", false, []string{""}},
+		{"declare -n foo=bar", true, []string{"", "bar"}},
+		{"local -rn foo=bar baz", true, []string{"", "bar", ""}},
+		{"typeset -n foo=$bar", true, []string{"", ""}},
+		{"nameref foo=bar", true, []string{"bar"}},
+		{"declare -n +n foo=bar", false, []string{"", "", ""}},
+		{"declare $opts foo=bar", false, []string{"", ""}},
+	}
+	p := NewParser()
+	for _, tc := range tests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		dc := f.Stmts[0].Cmd.(*DeclClause)
+		if got := dc.Nameref(); got != tc.nameref {
+			t.Errorf("%q: Nameref() got %v, want %v", tc.in, got, tc.nameref)
+		}
+		var targets []string
+		for _, as := range dc.Args {
+			targets = append(targets, dc.NamerefTarget(as))
+		}
+		if !reflect.DeepEqual(targets, tc.targets) {
+			t.Errorf("%q: NamerefTarget() got %q, want %q", tc.in, targets, tc.targets)
+		}
+	}
+}
+
+func TestBinaryCmdPipeline(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a && b", nil},
+		{"a | b", []string{"a", "b"}},
+		{"a | b |& c | d", []string{"a", "b", "c", "d"}},
+		{"! a | b", []string{"a", "b"}},
+		{"{ a | b; } | c", []string{"{ a | b; }", "c"}},
+		{"a && b | c", nil},
+		{"a | b && c", nil},
+	}
+	p := NewParser()
+	printer := NewPrinter()
+	for _, tc := range tests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		var got []string
+		for _, stmt := range f.Stmts[0].Cmd.(*BinaryCmd).Pipeline() {
+			var sb strings.Builder
+			if err := printer.Print(&sb, stmt); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, sb.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: Pipeline() got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestBinaryCmdAndOr(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in    string
+		stmts []string
+		ops   []string
+	}{
+		{"a | b", nil, nil},
+		{"a && b", []string{"a", "b"}, []string{"&&@3"}},
+		{"a && b || c", []string{"a", "b", "c"}, []string{"&&@3", "||@8"}},
+		{"! a && b | c || d", []string{"! a", "b | c", "d"}, []string{"&&@5", "||@14"}},
+		{"a || { b && c; }", []string{"a", "{ b && c; }"}, []string{"||@3"}},
+	}
+	p := NewParser()
+	printer := NewPrinter()
+	for _, tc := range tests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		stmts, ops := f.Stmts[0].Cmd.(*BinaryCmd).AndOr()
+		var gotStmts, gotOps []string
+		for _, stmt := range stmts {
+			var sb strings.Builder
+			if err := printer.Print(&sb, stmt); err != nil {
+				t.Fatal(err)
+			}
+			gotStmts = append(gotStmts, sb.String())
+		}
+		for _, op := range ops {
+			gotOps = append(gotOps, fmt.Sprintf("%s@%d", op.Op, op.OpPos.Col()))
+		}
+		if !reflect.DeepEqual(gotStmts, tc.stmts) {
+			t.Errorf("%q: AndOr() stmts got %q, want %q", tc.in, gotStmts, tc.stmts)
+		}
+		if !reflect.DeepEqual(gotOps, tc.ops) {
+			t.Errorf("%q: AndOr() ops got %q, want %q", tc.in, gotOps, tc.ops)
+		}
+	}
+}
+
+func TestCallExprScriptArgs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"foo 'bar'", nil},
+		{"eval", nil},
+		{"eval 'foo; bar'", []string{"'foo; bar'"}},
+		{"eval -- \"$x\"", []string{"\"$x\""}},
+		{"eval foo bar", nil},
+		{"trap 'cleanup' EXIT INT", []string{"'cleanup'"}},
+		{"trap -- cleanup EXIT", []string{"cleanup"}},
+		{"trap - EXIT", nil},
+		{"trap -p", nil},
+		{"trap EXIT", nil},
+		{"$cmd 'foo'", nil},
+	}
+	p := NewParser()
+	printer := NewPrinter()
+	for _, tc := range tests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		var got []string
+		for _, w := range f.Stmts[0].Cmd.(*CallExpr).ScriptArgs() {
+			var sb strings.Builder
+			if err := printer.Print(&sb, w); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, sb.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: ScriptArgs() got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestWordLit(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+	}{
+		{"foo", "foo"},
+		{"~", "~"},
+		{"~/foo", "~/foo"},
+		{"~user/foo", "~user/foo"},
+		{"~/$foo", ""},
+		{`"foo"`, ""},
+	}
+	p := NewParser()
+	for _, tc := range tests {
+		f, err := p.Parse(strings.NewReader("echo "+tc.in), "")
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if got := f.Stmts[0].Cmd.(*CallExpr).Args[1].Lit(); got != tc.want {
+			t.Errorf("%q: Lit() got %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"mvdan.cc/sh/v3/fileutil"
+)
+
+// ParseDir parses the shell files found in the directory tree rooted at dir,
+// returning a map from each file's path to its syntax tree. Hidden directories,
+// such as ".git", are skipped.
+//
+// If filter is nil, only files which are shell scripts are parsed; that is,
+// those with a shell extension like ".sh", and those without an extension
+// which start with a shell shebang. Otherwise, only the files for which filter
+// returns true are parsed.
+//
+// The files are parsed concurrently by up to GOMAXPROCS parsers, each created
+// with the given options. A file which couldn't be read or parsed is left out
+// of the map, and its error is returned once all the other files are parsed.
+// If more than one file failed, the error is an ErrorList sorted by path. With
+// RecoverErrors, files with syntax errors are still included in the map.
+func ParseDir(dir string, filter func(os.FileInfo) bool, options ...ParserOption) (map[string]*File, error) {
+	type result struct {
+		path string
+		f    *File
+		err  error
+	}
+	paths := make(chan string)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := NewParser(options...)
+			for path := range paths {
+				f, err := p.parsePath(path, filter == nil)
+				if err != nil && p.recoverErrs == 0 {
+					f = nil
+				}
+				results <- result{path, f, err}
+			}
+		}()
+	}
+	go func() {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				results <- result{path: path, err: err}
+				return nil
+			}
+			if info.IsDir() {
+				if path != dir && info.Name()[0] == '.' {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filter != nil {
+				if filter(info) {
+					paths <- path
+				}
+			} else if fileutil.CouldBeScript(info) != fileutil.ConfNotScript {
+				paths <- path
+			}
+			return nil
+		})
+		close(paths)
+		wg.Wait()
+		close(results)
+	}()
+
+	files := make(map[string]*File)
+	var errs []result
+	for res := range results {
+		if res.f != nil {
+			files[res.path] = res.f
+		}
+		if res.err != nil {
+			errs = append(errs, res)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].path < errs[j].path })
+	var list ErrorList
+	for _, res := range errs {
+		list = append(list, res.err)
+	}
+	switch len(list) {
+	case 0:
+		return files, nil
+	case 1:
+		return files, list[0]
+	}
+	return files, list
+}
+
+// parsePath parses the file at path. If onlyShebang is true and the file
+// has no extension, it is only parsed if it starts with a shell shebang;
+// otherwise, a nil file and error are returned.
+func (p *Parser) parsePath(path string, onlyShebang bool) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if onlyShebang && filepath.Ext(path) == "" {
+		var buf [32]byte
+		n, err := io.ReadFull(f, buf[:])
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		if !fileutil.HasShebang(buf[:n]) {
+			return nil, nil
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return p.Parse(f, path)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseDir(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "syntax-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range map[string]string{
+		"a.sh":         "foo",
+		"b":            "#!/bin/sh\nfoo",
+		"c":            "not a shell script",
+		"d.txt":        "foo",
+		"bad.sh":       "foo )",
+		"sub/e.bash":   "foo",
+		".git/hook.sh": "foo",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(files map[string]*File) []string {
+		var list []string
+		for path, f := range files {
+			if f.Name != path {
+				t.Errorf("File at %q is named %q", path, f.Name)
+			}
+			rel, _ := filepath.Rel(dir, path)
+			list = append(list, filepath.ToSlash(rel))
+		}
+		sort.Strings(list)
+		return list
+	}
+
+	files, err := ParseDir(dir, nil)
+	if want := []string{"a.sh", "b", "sub/e.bash"}; !reflect.DeepEqual(names(files), want) {
+		t.Fatalf("Expected files %q, got %q", want, names(files))
+	}
+	if err == nil || !strings.HasSuffix(err.Error(), "bad.sh:1:5: a command can only contain words and redirects; encountered )") {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	files, err = ParseDir(dir, func(info os.FileInfo) bool {
+		return strings.HasSuffix(info.Name(), ".sh")
+	}, RecoverErrors(1))
+	if want := []string{"a.sh", "bad.sh"}; !reflect.DeepEqual(names(files), want) {
+		t.Fatalf("Expected files %q, got %q", want, names(files))
+	}
+	if _, ok := err.(ParseError); !ok {
+		t.Fatalf("Expected a ParseError, got %v", err)
+	}
+
+	_, err = ParseDir(filepath.Join(dir, "missing"), nil)
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected a not-exist error, got %v", err)
+	}
+}
@@ -5,8 +5,12 @@ package syntax
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"path"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -15,6 +19,11 @@ import (
 // ParserOption is a function which can be passed to NewParser
 // to alter its behaviour. To apply option to existing Parser
 // call it directly, for example KeepComments(true)(parser).
+//
+// Options are applied in order, so a later option overrides an earlier
+// one of the same kind. The zero value of each setting is the default,
+// so new options can be added without changing the behaviour of
+// existing callers.
 type ParserOption func(*Parser)
 
 // KeepComments makes the parser parse comments and attach them to
@@ -23,18 +32,150 @@ func KeepComments(enabled bool) ParserOption {
 	return func(p *Parser) { p.keepComments = enabled }
 }
 
+// KeepSource makes the parser keep a copy of the input in the resulting File,
+// so that the exact source text of any of its nodes can be obtained with
+// File.Source. This allows rewriting parts of a file without reformatting
+// the rest of it.
+func KeepSource(enabled bool) ParserOption {
+	return func(p *Parser) { p.keepSource = enabled }
+}
+
 type LangVariant int
 
 const (
 	LangBash LangVariant = iota
 	LangPOSIX
 	LangMirBSDKorn
+
+	// LangBats is Bash with the @test blocks of Bats test files, which
+	// are parsed as TestDecl nodes.
+	LangBats
+
+	// LangBusyBox is the ash shell found in BusyBox, with its default
+	// Bash compatibility options. It is POSIX Shell plus $'' strings,
+	// slicing and search and replace expansions, &> and <<< redirects,
+	// and function declarations with the "function" keyword.
+	LangBusyBox
+
+	// LangAuto picks the variant of each file from its shebang, falling
+	// back to LangBash. For example, "#!/bin/sh" means LangPOSIX, and
+	// "#!/usr/bin/env mksh" means LangMirBSDKorn. The chosen variant is
+	// recorded in File.Variant.
+	LangAuto
 )
 
 // Variant changes the shell language variant that the parser will
 // accept.
 func Variant(l LangVariant) ParserOption {
-	return func(p *Parser) { p.lang = l }
+	return func(p *Parser) { p.variant, p.lang = l, l }
+}
+
+// StrictPOSIX makes the parser error on Bash and mksh constructs which would
+// otherwise be parsed as different, valid POSIX Shell code, such as "[[",
+// "function", "&>" or "$'foo'". It only has an effect with LangPOSIX.
+func StrictPOSIX(enabled bool) ParserOption {
+	return func(p *Parser) { p.strictPOSIX = enabled }
+}
+
+// RequireUTF8 makes the parser error on any input which isn't valid UTF-8.
+// Otherwise, invalid bytes such as those in Latin-1 comments or in binary
+// payloads are kept as they are in literals, like shells do.
+func RequireUTF8(enabled bool) ParserOption {
+	return func(p *Parser) { p.requireUTF8 = enabled }
+}
+
+// TolerateCRLF makes the parser treat "\r\n" line endings, such as the ones
+// found in scripts edited on Windows, like "\n". Otherwise, the "\r" bytes
+// end up as part of literals, heredoc bodies and heredoc delimiters.
+func TolerateCRLF(enabled bool) ParserOption {
+	return func(p *Parser) { p.crlf = enabled }
+}
+
+// HistoryExpansion makes the parser follow the rules of an interactive Bash
+// shell with history expansion enabled, where a "!" can start a history
+// expansion such as "!!", "!$" or "!-2". History expansion replaces such text
+// in the input before it's parsed, which the parser cannot do, so any "!"
+// which would start a history expansion is reported as an error.
+//
+// As in Bash, a "!" does not start a history expansion if it is escaped with a
+// backslash, within single quotes, right before a closing double quote, or
+// followed by a blank, a newline, "=" or "(". Nor does it in "$!", "${!foo}"
+// or "[!a]".
+//
+// By default, the parser follows the rules of a non-interactive shell, where
+// "!" has no special meaning besides those in the shell language itself, such
+// as negating a pipeline.
+func HistoryExpansion(enabled bool) ParserOption {
+	return func(p *Parser) { p.histExp = enabled }
+}
+
+// RecoverErrors makes the parser carry on after finding a syntax error in a
+// top-level statement, so that the rest of the input is still parsed. This is
+// useful for tools such as editors, which need a syntax tree and a list of
+// diagnostics even for files which are being edited and may be broken.
+//
+// Each broken statement is replaced by one holding a BadStmt node, and the
+// parser resumes at the next line. A statement spanning multiple lines may
+// then result in more errors and BadStmt nodes, such as when "fi" is found
+// without its "if".
+//
+// At most maximum errors are reported; once that many are found, the last one
+// stops the parser as usual. If more than one error is found, they are returned
+// as an ErrorList. A maximum of zero disables the recovery, which is the
+// default.
+func RecoverErrors(maximum int) ParserOption {
+	return func(p *Parser) { p.recoverErrs = maximum }
+}
+
+// Context makes the parser stop with ctx.Err() as its error once ctx is done.
+// The context is checked every time more input is read, which happens once
+// per kilobyte or so, allowing to abort the parsing of large or endless inputs
+// on a deadline. A single read that blocks isn't interrupted.
+func Context(ctx context.Context) ParserOption {
+	return func(p *Parser) { p.ctx = ctx }
+}
+
+// defaultMaxNesting is the nesting limit used when MaxNesting isn't given.
+const defaultMaxNesting = 1000
+
+// MaxNesting sets how many levels of commands and expressions can be nested
+// within each other, such as "$(" within "$(" or "(" within "((", before the
+// parser stops with a LimitError. Since each level uses up more stack, this
+// avoids crashing on inputs nested too deeply. The default, used if depth is
+// zero, is 1000. A negative depth means no limit.
+func MaxNesting(depth int) ParserOption {
+	return func(p *Parser) { p.maxNesting = depth }
+}
+
+// MaxBytes makes the parser stop with a LimitError if the input is larger
+// than n bytes. Along with MaxStmts and MaxWordParts, it can be used to bound
+// the memory and time spent parsing untrusted input. Zero means no limit.
+func MaxBytes(n int) ParserOption {
+	return func(p *Parser) { p.maxBytes = n }
+}
+
+// MaxStmts makes the parser stop with a LimitError once the input has more
+// than n statements, counting nested ones. Zero means no limit.
+func MaxStmts(n int) ParserOption {
+	return func(p *Parser) { p.maxStmts = n }
+}
+
+// MaxWordParts makes the parser stop with a LimitError on any word with more
+// than n parts, such as "$a$b" with two, including the parts within double
+// quotes. Zero means no limit.
+func MaxWordParts(n int) ParserOption {
+	return func(p *Parser) { p.maxWordParts = n }
+}
+
+// in reports whether the variant is one of langs. Since LangBats is a
+// superset of LangBash, it's also accepted wherever LangBash is.
+func (l LangVariant) in(langs ...LangVariant) bool {
+	for _, l2 := range langs {
+		if l == l2 || (l == LangBats && l2 == LangBash) {
+			return true
+		}
+	}
+	return false
 }
 
 func (l LangVariant) String() string {
@@ -45,10 +186,28 @@ func (l LangVariant) String() string {
 		return "posix"
 	case LangMirBSDKorn:
 		return "mksh"
+	case LangBats:
+		return "bats"
+	case LangBusyBox:
+		return "busybox"
+	case LangAuto:
+		return "auto"
 	}
 	return "unknown shell language variant"
 }
 
+// ExpandAliases makes the parser replace unquoted command names with their
+// replacement text in aliases, as Bash does with the expand_aliases option.
+// An alias is not expanded again within its own replacement text. If the
+// replacement text ends with a blank, the word following it is also checked
+// for aliases.
+//
+// The nodes parsed from a replacement text are all positioned at the command
+// name that was replaced.
+func ExpandAliases(aliases map[string]string) ParserOption {
+	return func(p *Parser) { p.aliases = aliases }
+}
+
 // StopAt configures the lexer to stop at an arbitrary word, treating it
 // as if it were the end of the input. It can contain any characters
 // except whitespace, and cannot be over four bytes in size.
@@ -62,6 +221,9 @@ func (l LangVariant) String() string {
 //
 // The match is done by prefix, so the example above will also act on
 // "foo $$bar".
+//
+// Once parsing is done, Parser.StoppedAt tells where the word was found, so
+// that the caller can carry on reading the input that follows.
 func StopAt(word string) ParserOption {
 	if len(word) > 4 {
 		panic("stop word can't be over four bytes in size")
@@ -83,7 +245,12 @@ func NewParser(options ...ParserOption) *Parser {
 
 // Parse reads and parses a shell program with an optional name. It
 // returns the parsed program if no issues were encountered. Otherwise,
-// an error is returned. Reads from r are buffered.
+// an error is returned.
+//
+// Reads from r are buffered, and the input is lexed incrementally as it
+// is read, so the program never needs to be held in memory as a whole.
+// To also process each statement as soon as it is parsed, such as when
+// reading from a pipe, use Stmts instead.
 //
 // Parse can be called more than once, but not concurrently. That is, a
 // Parser can be reused once it is done working.
@@ -91,6 +258,7 @@ func (p *Parser) Parse(r io.Reader, name string) (*File, error) {
 	p.reset()
 	p.f = &File{Name: name}
 	p.src = r
+	p.skipBOM()
 	p.rune()
 	p.next()
 	p.f.Stmts, p.f.Last = p.stmtList()
@@ -99,7 +267,75 @@ func (p *Parser) Parse(r io.Reader, name string) (*File, error) {
 		// trigger it
 		p.doHeredocs()
 	}
-	return p.f, p.err
+	p.f.Variant = p.lang
+	return p.f, p.allErrs()
+}
+
+// utf8BOM is the UTF-8 encoding of the byte order mark, U+FEFF.
+const utf8BOM = "\xef\xbb\xbf"
+
+// skipBOM skips a UTF-8 byte order mark at the start of the input, if there
+// is one, recording it in the file. It stops reading as soon as the input can't
+// start with one, so that interactive input isn't read ahead of time.
+func (p *Parser) skipBOM() {
+	for len(p.bs) < len(utf8BOM) && p.readErr == nil &&
+		bytes.HasPrefix([]byte(utf8BOM), p.bs) {
+		p.fill()
+	}
+	if bytes.HasPrefix(p.bs, []byte(utf8BOM)) {
+		p.bsp = len(utf8BOM)
+		p.f.BOM = true
+	}
+}
+
+// atFileStart reports whether the current token is at the start of the file,
+// after the byte order mark if there is one.
+func (p *Parser) atFileStart() bool {
+	start := uint(0)
+	if p.f.BOM {
+		start = uint(len(utf8BOM))
+	}
+	return p.pos.Offset() == start
+}
+
+// shebangLang returns the language variant for the interpreter named in a
+// shebang, such as "sh" in "#!/bin/sh" or "bash" in "#!/usr/bin/env bash".
+// Unknown interpreters result in LangBash.
+func shebangLang(sb *Shebang) LangVariant {
+	name, args := path.Base(sb.Path), sb.Args
+	if name == "env" {
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			args = args[1:] // flags like "-S"
+		}
+		if len(args) == 0 {
+			return LangBash
+		}
+		name, args = path.Base(args[0]), args[1:]
+	}
+	switch name {
+	case "sh", "dash":
+		return LangPOSIX
+	case "mksh":
+		return LangMirBSDKorn
+	case "bats":
+		return LangBats
+	case "busybox":
+		if len(args) > 0 && (args[0] == "sh" || args[0] == "ash") {
+			return LangBusyBox
+		}
+	}
+	return LangBash
+}
+
+func newShebang(pos Pos, line string) *Shebang {
+	sb := &Shebang{Hash: pos, Text: line}
+	if fields := strings.Fields(line); len(fields) > 0 {
+		sb.Path = fields[0]
+		if len(fields) > 1 {
+			sb.Args = fields[1:]
+		}
+	}
+	return sb
 }
 
 // Stmts reads and parses statements one at a time, calling a function
@@ -109,6 +345,7 @@ func (p *Parser) Stmts(r io.Reader, fn func(*Stmt) bool) error {
 	p.reset()
 	p.f = &File{}
 	p.src = r
+	p.skipBOM()
 	p.rune()
 	p.next()
 	p.stmts(fn)
@@ -117,7 +354,7 @@ func (p *Parser) Stmts(r io.Reader, fn func(*Stmt) bool) error {
 		// trigger it
 		p.doHeredocs()
 	}
-	return p.err
+	return p.allErrs()
 }
 
 type wrappedReader struct {
@@ -163,19 +400,25 @@ func (w *wrappedReader) Read(p []byte) (n int, err error) {
 //
 // One can imagine a simple interactive shell implementation as follows:
 //
-//         fmt.Fprintf(os.Stdout, "$ ")
-//         parser.Interactive(os.Stdin, func(stmts []*syntax.Stmt) bool {
-//                 if parser.Incomplete() {
-//                         fmt.Fprintf(os.Stdout, "> ")
-//                         return true
-//                 }
-//                 run(stmts)
-//                 fmt.Fprintf(os.Stdout, "$ ")
-//                 return true
-//         }
+//	fmt.Fprintf(os.Stdout, "$ ")
+//	parser.Interactive(os.Stdin, func(stmts []*syntax.Stmt) bool {
+//	        if parser.Incomplete() {
+//	                fmt.Fprintf(os.Stdout, "> ")
+//	                return true
+//	        }
+//	        run(stmts)
+//	        fmt.Fprintf(os.Stdout, "$ ")
+//	        return true
+//	}
 //
 // If the callback function returns false, parsing is stopped and the function
-// is not called again.
+// is not called again. If the input ends in the middle of a statement, the
+// error returned satisfies IsIncomplete.
+//
+// The parser state is kept between lines. For example, the bodies of any
+// here-documents are read from the lines that follow, and the map given to
+// ExpandAliases is only read when lexing each line, so the callback can add
+// aliases to it which will apply to the following lines.
 func (p *Parser) Interactive(r io.Reader, fn func([]*Stmt) bool) error {
 	w := wrappedReader{Parser: p, Reader: r, fn: fn}
 	return p.Stmts(&w, func(stmt *Stmt) bool {
@@ -229,6 +472,43 @@ func (p *Parser) Words(r io.Reader, fn func(*Word) bool) error {
 	}
 }
 
+// Tokens parses the input like Parse, calling a function with each of the
+// lexical tokens found in it, in the order that they appear in. If the
+// function returns false, parsing is stopped and the function is not called
+// again.
+//
+// The tokens are those of the shell language, so the same characters may be
+// lexed differently depending on where they are. For example, "((" might
+// start an arithmetic command or two subshells, and "#" might start a comment
+// or be part of a word. Whitespace outside of literals isn't reported, and
+// neither are the delimiters closing heredocs.
+//
+// Since the input is parsed, tokenizing it costs about as much as parsing it.
+// However, syntax errors don't stop the tokenizing; the parser recovers from
+// them as if RecoverErrors was used without a maximum, unless the option sets
+// one. This way, the tokens for the rest of an input with errors, such as one
+// being edited, are still found. What is given up is the rest of the line
+// with an error, as no tokens are reported past the token where the error was
+// found until the next line. The errors are still returned, as with Parse.
+func (p *Parser) Tokens(r io.Reader, fn func(Token) bool) error {
+	p.tokFn = fn
+	if p.recoverErrs == 0 {
+		p.recoverErrs = math.MaxInt32
+		defer func() { p.recoverErrs = 0 }()
+	}
+	p.holdingTok = false
+	_, err := p.Parse(r, "")
+	p.flushTok()
+	p.tokFn = nil
+	return err
+}
+
+// errStopTokens stops the parser when the function given to Tokens asks it to.
+var errStopTokens = errors.New("stopped by the tokens function")
+
+// errMaxBytes is the read error once the input goes over MaxBytes.
+var errMaxBytes = errors.New("input over MaxBytes")
+
 // Document parses a single here-document word. That is, it parses the input as
 // if they were lines following a <<EOF redirection.
 //
@@ -249,6 +529,122 @@ func (p *Parser) Document(r io.Reader) (*Word, error) {
 	return w, p.err
 }
 
+// Reparse parses the value of a word as a shell program, such as the argument
+// in "eval 'foo; bar'" or the action in "trap 'cleanup' EXIT". See
+// CallExpr.ScriptArgs to find such words.
+//
+// The positions of the resulting nodes, as well as those of any parse errors,
+// point to the word's source in the original file. For this reason, the word's
+// value must be known statically and must match its source byte by byte; the
+// word must consist of an unquoted literal without backslashes, a single-quoted
+// string, or a double-quoted string which only holds such a literal. Otherwise,
+// an error is returned.
+func (p *Parser) Reparse(w *Word, name string) (*File, error) {
+	src, pos, ok := wordSource(w)
+	if !ok {
+		return nil, fmt.Errorf("cannot reparse a word which isn't a static string")
+	}
+	p.reset()
+	p.f = &File{Name: name}
+	p.src = strings.NewReader(src)
+	p.npos.line, p.npos.col = pos.line, pos.col
+	p.offsShift = int(pos.offs)
+	p.rune()
+	p.next()
+	p.f.Stmts, p.f.Last = p.stmtList()
+	if p.err == nil {
+		p.doHeredocs()
+	}
+	return p.f, p.err
+}
+
+// wordSource returns the value of a word along with the position where it
+// starts in the source, if the value is static and matches its source.
+func wordSource(w *Word) (string, Pos, bool) {
+	if len(w.Parts) != 1 {
+		return "", Pos{}, false
+	}
+	switch x := w.Parts[0].(type) {
+	case *Lit:
+		if strings.ContainsRune(x.Value, '\\') {
+			return "", Pos{}, false
+		}
+		return x.Value, x.ValuePos, true
+	case *SglQuoted:
+		if x.Dollar {
+			return "", Pos{}, false
+		}
+		return x.Value, posAddCol(x.Left, 1), true
+	case *DblQuoted:
+		switch {
+		case x.Dollar:
+		case len(x.Parts) == 0:
+			return "", posAddCol(x.Left, 1), true
+		case len(x.Parts) == 1:
+			if lit, ok := x.Parts[0].(*Lit); ok {
+				return wordSource(&Word{Parts: []WordPart{lit}})
+			}
+		}
+	}
+	return "", Pos{}, false
+}
+
+// ReparseArithm is like Reparse, but parses the value of a word as a single
+// arithmetic expression, such as the quoted argument in `let "x = y + 1"`. Like
+// with ParseArithmExpr, it's an error for the value to hold anything past the
+// expression.
+func (p *Parser) ReparseArithm(w *Word) (ArithmExpr, error) {
+	src, pos, ok := wordSource(w)
+	if !ok {
+		return nil, fmt.Errorf("cannot reparse a word which isn't a static string")
+	}
+	p.reset()
+	p.f = &File{}
+	p.src = strings.NewReader(src)
+	p.npos.line, p.npos.col = pos.line, pos.col
+	p.offsShift = int(pos.offs)
+	p.rune()
+	p.quote = arithmExpr
+	p.next()
+	expr := p.arithmExpr(false)
+	switch {
+	case p.err != nil:
+	case p.tok != _EOF:
+		p.curErr("not a valid arithmetic operator: %s", p.tokText())
+	case expr == nil:
+		p.posErr(pos, "expected an arithmetic expression; found none")
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return expr, nil
+}
+
+// LetExprs returns the arithmetic expressions evaluated by a let clause, such
+// as the two in `let "x = y + 1" i++`. The parser keeps quoted arguments like
+// "x = y + 1" as words, as the shell only parses them once they are expanded,
+// so those are parsed with ReparseArithm. Arguments which can't be reparsed,
+// such as "x = $y", are kept as words.
+func (p *Parser) LetExprs(lc *LetClause) ([]ArithmExpr, error) {
+	exprs := make([]ArithmExpr, len(lc.Exprs))
+	for i, expr := range lc.Exprs {
+		exprs[i] = expr
+		w, ok := expr.(*Word)
+		if !ok {
+			continue
+		}
+		if _, _, ok := wordSource(w); !ok {
+			continue
+		}
+		x, err := p.ReparseArithm(w)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = x
+	}
+	return exprs, nil
+}
+
 // Arithmetic parses a single arithmetic expression. That is, as if the input
 // were within the $(( and )) tokens.
 func (p *Parser) Arithmetic(r io.Reader) (ArithmExpr, error) {
@@ -262,15 +658,90 @@ func (p *Parser) Arithmetic(r io.Reader) (ArithmExpr, error) {
 	return expr, p.err
 }
 
+// ParseWord parses src as a single shell word, such as `foo"$bar"` or
+// "${name:-default}", with a new parser using the given options. Unlike
+// Parser.Words, it's an error for src to hold anything other than one word.
+func ParseWord(src string, options ...ParserOption) (*Word, error) {
+	p := NewParser(options...)
+	var word *Word
+	err := p.Words(strings.NewReader(src), func(w *Word) bool {
+		if word != nil {
+			p.posErr(w.Pos(), "expected a single word")
+			return false
+		}
+		word = w
+		return true
+	})
+	if err == nil {
+		err = p.err
+	}
+	if err == nil && word == nil {
+		err = fmt.Errorf("expected a single word; found none")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return word, nil
+}
+
+// ParseArithmExpr parses src as a single arithmetic expression, such as
+// "x = y + 1", with a new parser using the given options. Unlike
+// Parser.Arithmetic, it's an error for src to hold anything past the
+// expression.
+func ParseArithmExpr(src string, options ...ParserOption) (ArithmExpr, error) {
+	p := NewParser(options...)
+	expr, err := p.Arithmetic(strings.NewReader(src))
+	if err == nil && p.tok != _EOF {
+		p.curErr("not a valid arithmetic operator: %s", p.tokText())
+		err = p.err
+	}
+	if err == nil && expr == nil {
+		err = fmt.Errorf("expected an arithmetic expression; found none")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// ParseParamExp parses src as a single parameter expansion, such as "$foo" or
+// "${foo:-bar}", with a new parser using the given options. This can be useful
+// to validate templates which should only hold a parameter expansion.
+func ParseParamExp(src string, options ...ParserOption) (*ParamExp, error) {
+	w, err := ParseWord(src, options...)
+	if err != nil {
+		return nil, err
+	}
+	pe, ok := w.Parts[0].(*ParamExp)
+	if !ok {
+		return nil, ParseError{Pos: w.Pos(), Text: "not a parameter expansion"}
+	}
+	if len(w.Parts) > 1 {
+		return nil, ParseError{Pos: w.Parts[1].Pos(),
+			Text: "expected a single parameter expansion"}
+	}
+	return pe, nil
+}
+
 // Parser holds the internal state of the parsing mechanism of a
 // program.
 type Parser struct {
 	src io.Reader
+	ctx context.Context // set by Context
+	// tokFn is the function given to Tokens, if any.
+	tokFn func(Token) bool
+	// heldTok is the last token found, which is only reported to tokFn
+	// once the next one is found. See emitTok.
+	heldTok    Token
+	holdingTok bool
+
 	bs  []byte // current chunk of read bytes
 	bsp int    // pos within chunk for the rune after r
 	r   rune   // next rune
 	w   uint16 // width of r
 
+	crlfNewl bool // r is a '\n' read from "\r\n"
+
 	f *File
 
 	spaced bool // whether tok has whitespace on its left
@@ -289,9 +760,49 @@ type Parser struct {
 	eqlOffs int        // position of '=' in val (a literal)
 
 	keepComments bool
-	lang         LangVariant
-
-	stopAt []byte
+	keepSource   bool
+	variant      LangVariant // as given to Variant
+	lang         LangVariant // in use, if variant is LangAuto
+	strictPOSIX  bool
+	crlf         bool
+	requireUTF8  bool
+
+	// histExp enables HistoryExpansion, which follows the quoting of the
+	// input with histQuote and histEsc, and the last bytes read with
+	// histPrev.
+	histExp   bool
+	histQuote byte
+	histEsc   bool
+	histPrev  [2]byte
+
+	// recoverErrs is the maximum set by RecoverErrors. The lexer state
+	// when the current error was found is kept in the errBsp fields, to
+	// resume from it, and badErrs are the errors recovered from.
+	recoverErrs int
+	errBsp      int
+	errR        rune
+	errW        uint16
+	errNpos     Pos
+	badErrs     []error
+
+	// litBreaks are the line continuations within the current literal
+	// token, and escNewlOffs is the offset of the last one's backslash.
+	litBreaks   []litBreak
+	escNewlOffs int
+
+	stopAt  []byte
+	stopPos Pos // where the stopAt word was found, if it was
+
+	aliases map[string]string
+
+	// aliasFrames is the stack of alias replacement texts being read.
+	aliasFrames []aliasFrame
+	// aliasBlank is set when the last alias that ended had a trailing
+	// blank, so that the next word is checked for aliases too.
+	aliasBlank bool
+	// offsShift is added to the offsets of the source being read, as
+	// inserting replacement texts moves the bytes following them.
+	offsShift int
 
 	forbidNested bool
 
@@ -307,6 +818,15 @@ type Parser struct {
 	// non-zero number means that we require certain tokens or words before
 	// reaching EOF.
 	openStmts int
+	// nesting is how many levels of commands and expressions we're in,
+	// and maxNesting is the limit set by MaxNesting.
+	nesting, maxNesting int
+
+	// nbytes and nstmts count the input read and the statements parsed
+	// so far, for MaxBytes and MaxStmts.
+	nbytes, maxBytes int
+	nstmts, maxStmts int
+	maxWordParts     int
 	// openBquotes is how many levels of backquotes are open at the moment.
 	openBquotes int
 
@@ -329,11 +849,21 @@ type Parser struct {
 	stListBatch []*Stmt
 	callBatch   []callAlloc
 
-	readBuf [bufSize]byte
+	readBuf []byte // grown by peekFill when looking far ahead
 	litBuf  [bufSize]byte
 	litBs   []byte
 }
 
+// StoppedAt returns the position of the word set with StopAt where the parser
+// stopped during its last use, or an invalid position if it wasn't found. Its
+// offset is the number of bytes of input which were parsed as shell code,
+// before the stop word.
+//
+// Since reads are buffered, the parser may have read more bytes than that from
+// its reader, so the caller should use the offset on its own copy of the input
+// to carry on reading past the shell code.
+func (p *Parser) StoppedAt() Pos { return p.stopPos }
+
 // Incomplete reports whether the parser is waiting to read more bytes because
 // it needs to finish properly parsing a statement.
 //
@@ -349,24 +879,43 @@ func (p *Parser) Incomplete() bool {
 
 const bufSize = 1 << 10
 
+// maxPeekBuf is how large readBuf may grow when the lexer looks ahead.
+const maxPeekBuf = 64 * bufSize
+
 func (p *Parser) reset() {
 	p.tok, p.val = illegalTok, ""
 	p.eqlOffs = 0
+	if p.readBuf == nil {
+		p.readBuf = make([]byte, bufSize)
+	}
 	p.bs, p.bsp = nil, 0
 	p.offs = 0
+	p.histQuote, p.histEsc, p.histPrev = 0, false, [2]byte{}
+	p.aliasFrames, p.aliasBlank, p.offsShift = p.aliasFrames[:0], false, 0
 	p.npos = Pos{line: 1, col: 1}
 	p.r, p.w = 0, 0
-	p.err, p.readErr = nil, nil
+	p.err, p.readErr, p.badErrs = nil, nil, nil
+	p.stopPos = Pos{}
 	p.quote, p.forbidNested = noState, false
 	p.openStmts = 0
+	p.nesting, p.nbytes, p.nstmts = 0, 0, 0
 	p.heredocs, p.buriedHdocs = p.heredocs[:0], 0
 	p.parsingDoc = false
 	p.openBquotes, p.buriedBquotes = 0, 0
 	p.accComs, p.curComs = nil, &p.accComs
+	if p.variant == LangAuto {
+		p.lang = LangBash
+	}
 }
 
 func (p *Parser) getPos() Pos {
-	p.npos.offs = uint32(p.offs + p.bsp - int(p.w))
+	if len(p.aliasFrames) > 0 {
+		return p.aliasFrames[0].namePos
+	}
+	p.npos.offs = uint32(p.offs + p.offsShift + p.bsp - int(p.w))
+	if p.crlfNewl && p.r == '\n' {
+		p.npos.offs-- // the "\r" before it
+	}
 	return p.npos
 }
 
@@ -382,6 +931,34 @@ func (p *Parser) lit(pos Pos, val string) *Lit {
 	return l
 }
 
+// litParts returns val, a suffix of the current literal token starting at
+// pos, as word parts. Usually, that is a single *Lit, but the literal is split
+// at any line continuations within it so that the printer can keep them.
+func (p *Parser) litParts(pos Pos, val string) []WordPart {
+	if len(p.litBreaks) == 0 {
+		return p.wps(p.lit(pos, val))
+	}
+	var wps []WordPart
+	start := len(p.val) - len(val)
+	prev := start
+	for _, brk := range p.litBreaks {
+		if brk.offs <= start {
+			continue // before the suffix, or at its very start
+		}
+		if brk.offs >= len(p.val) {
+			break // trailing, outside of the literal's value
+		}
+		if brk.offs > prev {
+			l := p.lit(pos, p.val[prev:brk.offs])
+			l.ValueEnd = brk.end
+			wps = append(wps, l)
+			prev = brk.offs
+		}
+		pos = brk.pos
+	}
+	return append(wps, p.lit(pos, p.val[prev:]))
+}
+
 func (p *Parser) word(parts []WordPart) *Word {
 	if len(p.wordBatch) == 0 {
 		p.wordBatch = make([]Word, 64)
@@ -409,6 +986,9 @@ func (p *Parser) stmt(pos Pos) *Stmt {
 	s := &p.stmtBatch[0]
 	p.stmtBatch = p.stmtBatch[1:]
 	s.Position = pos
+	if p.nstmts++; p.maxStmts > 0 && p.nstmts > p.maxStmts {
+		p.limitErr(pos, LimitStmts, p.maxStmts)
+	}
 	return s
 }
 
@@ -467,6 +1047,8 @@ const (
 		hdocBodyTabs | paramExpExp
 	allRegTokens = noState | subCmd | subCmdBckquo | hdocWord |
 		switchCase | arrayElems | testExpr
+	allTildeWords = noState | subCmd | subCmdBckquo | switchCase |
+		arrayElems | testExpr
 	allArithmExpr = arithmExpr | arithmExprLet | arithmExprCmd |
 		arithmExprBrack | paramExpSlice
 	allParamReg = paramExpName | paramExpSlice
@@ -478,6 +1060,24 @@ type saveState struct {
 	buriedHdocs int
 }
 
+// nest enters a level of nested commands or expressions. It returns false
+// and errors if that goes past the maximum set by MaxNesting; otherwise, the
+// caller must call unnest when leaving the level.
+func (p *Parser) nest() bool {
+	max := p.maxNesting
+	if max == 0 {
+		max = defaultMaxNesting
+	}
+	if max > 0 && p.nesting >= max {
+		p.limitErr(p.pos, LimitNesting, max)
+		return false
+	}
+	p.nesting++
+	return true
+}
+
+func (p *Parser) unnest() { p.nesting-- }
+
 func (p *Parser) preNested(quote quoteState) (s saveState) {
 	s.quote, s.buriedHdocs = p.quote, p.buriedHdocs
 	p.buriedHdocs, p.quote = len(p.heredocs), quote
@@ -547,8 +1147,13 @@ func (p *Parser) doHeredocs() {
 			p.rune()
 		}
 		lastLine := p.npos.line
-		if quoted {
+		if r.HdocQuoted = quoted; quoted {
 			r.Hdoc = p.quotedHdocWord()
+			if r.Hdoc != nil && p.tokFn != nil {
+				for _, wp := range r.Hdoc.Parts {
+					p.emitTok(LitToken, wp.Pos(), wp.End(), wp.(*Lit).Value)
+				}
+			}
 		} else {
 			p.next()
 			r.Hdoc = p.getWord()
@@ -585,6 +1190,9 @@ func (p *Parser) got(tok token) bool {
 func (p *Parser) gotRsrv(val string) (Pos, bool) {
 	pos := p.pos
 	if p.tok == _LitWord && p.val == val {
+		if IsKeyword(val) {
+			p.markTok(useKeyword)
+		}
 		p.next()
 		return pos, true
 	}
@@ -599,9 +1207,14 @@ func readableStr(s string) string {
 	return s
 }
 
-func (p *Parser) followErr(pos Pos, left, right string) {
+// followErr reports that left must be followed by right. If the expected
+// tokens aren't given, right is what's expected.
+func (p *Parser) followErr(pos Pos, left, right string, expected ...string) {
+	if expected == nil {
+		expected = []string{right}
+	}
 	leftStr := readableStr(left)
-	p.posErr(pos, "%s must be followed by %s", leftStr, right)
+	p.expectErr(pos, expected, "%s must be followed by %s", leftStr, right)
 }
 
 func (p *Parser) followErrExp(pos Pos, left string) {
@@ -617,7 +1230,7 @@ func (p *Parser) follow(lpos Pos, left string, tok token) {
 func (p *Parser) followRsrv(lpos Pos, left, val string) Pos {
 	pos, ok := p.gotRsrv(val)
 	if !ok {
-		p.followErr(lpos, left, fmt.Sprintf("%q", val))
+		p.followErr(lpos, left, fmt.Sprintf("%q", val), val)
 	}
 	return pos
 }
@@ -645,19 +1258,19 @@ func (p *Parser) followWordTok(tok token, pos Pos) *Word {
 func (p *Parser) stmtEnd(n Node, start, end string) Pos {
 	pos, ok := p.gotRsrv(end)
 	if !ok {
-		p.posErr(n.Pos(), "%s statement must end with %q", start, end)
+		p.expectErr(n.Pos(), []string{end}, "%s statement must end with %q", start, end)
 	}
 	return pos
 }
 
 func (p *Parser) quoteErr(lpos Pos, quote token) {
-	p.posErr(lpos, "reached %s without closing quote %s",
-		p.tok.String(), quote)
+	p.expectErr(lpos, []string{quote.String()},
+		"reached %s without closing quote %s", p.tok.String(), quote)
 }
 
 func (p *Parser) matchingErr(lpos Pos, left, right interface{}) {
-	p.posErr(lpos, "reached %s without matching %s with %s",
-		p.tok.String(), left, right)
+	p.expectErr(lpos, []string{fmt.Sprint(right)},
+		"reached %s without matching %s with %s", p.tok.String(), left, right)
 }
 
 func (p *Parser) matched(lpos Pos, left, right token) Pos {
@@ -671,6 +1284,9 @@ func (p *Parser) matched(lpos Pos, left, right token) Pos {
 func (p *Parser) errPass(err error) {
 	if p.err == nil {
 		p.err = err
+		if p.recoverErrs > 0 {
+			p.errBsp, p.errR, p.errW, p.errNpos = p.bsp, p.r, p.w, p.npos
+		}
 		p.bsp = len(p.bs) + 1
 		p.r = utf8.RuneSelf
 		p.w = 1
@@ -680,22 +1296,85 @@ func (p *Parser) errPass(err error) {
 
 // IsIncomplete reports whether a Parser error could have been avoided with
 // extra input bytes. For example, if an io.EOF was encountered while there was
-// an unclosed quote or parenthesis.
+// an unclosed quote or parenthesis. For an ErrorList, the last error is used.
+//
+// This allows an interactive shell to tell a script like "if true; then" apart
+// from one with a syntax error, and to ask for more input with a continuation
+// prompt like "> " instead of reporting the error.
 func IsIncomplete(err error) bool {
+	if list, ok := err.(ErrorList); ok && len(list) > 0 {
+		err = list[len(list)-1]
+	}
 	perr, ok := err.(ParseError)
 	return ok && perr.Incomplete
 }
 
+// allErrs returns the errors found while parsing, including those recovered
+// from with RecoverErrors, as a single error.
+func (p *Parser) allErrs() error {
+	errs := p.badErrs
+	if p.err != nil && p.err != errStopTokens {
+		errs = append(errs, p.err)
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return ErrorList(errs)
+}
+
+// ErrorList is a list of errors, such as those found by the parser in a single
+// input when using RecoverErrors, in the order that they appear in. ParseDir
+// also returns one when more than one file fails to be read or parsed.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	case 2:
+		return fmt.Sprintf("%s (and 1 more error)", l[0])
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
 // ParseError represents an error found when parsing a source file, from which
 // the parser cannot recover.
+//
+// Besides the human-readable Text, the error describes the token the parser
+// was at and what it expected to find instead, so that tools can build their
+// own messages.
 type ParseError struct {
 	Filename string
 	Pos
 	Text string
 
+	// Token is the source text of the token the parser was at when the
+	// error was found, such as ")", "fi" or "\n". It is empty at the end of
+	// the input.
+	Token string
+
+	// expected holds the elements returned by Expected, each followed by
+	// a null byte. A string is used so that ParseError stays comparable.
+	expected string
+
 	Incomplete bool
 }
 
+// Expected lists what the parser expected to find, if known. Each element is
+// either the source text of a token, such as "fi" or ")", or a description
+// such as "a word" or "a statement list".
+func (e ParseError) Expected() []string {
+	if e.expected == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(e.expected, "\x00"), "\x00")
+}
+
 func (e ParseError) Error() string {
 	if e.Filename == "" {
 		return fmt.Sprintf("%s: %s", e.Pos.String(), e.Text)
@@ -735,15 +1414,157 @@ func (e LangError) Error() string {
 	return buf.String()
 }
 
+// Snippet is like ParseError.Snippet.
+func (e LangError) Snippet(src []byte) string {
+	return e.Error() + sourceSnippet(src, e.Pos)
+}
+
+// Limit is one of the limits that can be set on a parser, such as MaxBytes.
+type Limit int
+
+const (
+	LimitBytes     Limit = iota + 1 // set by MaxBytes
+	LimitStmts                      // set by MaxStmts
+	LimitWordParts                  // set by MaxWordParts
+	LimitNesting                    // set by MaxNesting
+)
+
+// LimitError is returned when the input goes over one of the limits set on
+// the parser, such as MaxBytes. Max is the value of the limit.
+type LimitError struct {
+	Filename string
+	Pos
+	Limit Limit
+	Max   int
+}
+
+func (e LimitError) Error() string {
+	var text string
+	switch e.Limit {
+	case LimitBytes:
+		text = "input is larger than %d bytes"
+	case LimitStmts:
+		text = "input has more than %d statements"
+	case LimitWordParts:
+		text = "word has more than %d parts"
+	case LimitNesting:
+		text = "nesting is deeper than %d levels"
+	}
+	text = fmt.Sprintf(text, e.Max)
+	if e.Filename == "" {
+		return fmt.Sprintf("%s: %s", e.Pos.String(), text)
+	}
+	return fmt.Sprintf("%s:%s: %s", e.Filename, e.Pos.String(), text)
+}
+
+// Snippet is like ParseError.Snippet.
+func (e LimitError) Snippet(src []byte) string {
+	return e.Error() + sourceSnippet(src, e.Pos)
+}
+
+// Snippet returns the error followed by the line of src where it was found,
+// and a caret pointing at its position, like:
+//
+//	1:6: a command can only contain words and redirects; encountered )
+//	echo )
+//	     ^
+//
+// src must be the source which was parsed. The caret is aligned with any tabs
+// and multibyte characters before it in the line. If the position isn't
+// within src, only the error is returned.
+func (e ParseError) Snippet(src []byte) string {
+	return e.Error() + sourceSnippet(src, e.Pos)
+}
+
+// Snippet returns the snippets of all the errors, one after another. See
+// ParseError.Snippet.
+func (l ErrorList) Snippet(src []byte) string {
+	var sb strings.Builder
+	for i, err := range l {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		if s, ok := err.(interface{ Snippet([]byte) string }); ok {
+			sb.WriteString(s.Snippet(src))
+		} else {
+			sb.WriteString(err.Error())
+		}
+	}
+	return sb.String()
+}
+
+// sourceSnippet returns the line of src at pos followed by a caret line, each
+// of them starting with a newline.
+func sourceSnippet(src []byte, pos Pos) string {
+	offs := int(pos.Offset())
+	if !pos.IsValid() || offs > len(src) {
+		return ""
+	}
+	start := bytes.LastIndexByte(src[:offs], '\n') + 1
+	end := len(src)
+	if i := bytes.IndexByte(src[offs:], '\n'); i >= 0 {
+		end = offs + i
+	}
+	line := bytes.TrimSuffix(src[start:end], []byte("\r"))
+
+	var sb strings.Builder
+	sb.WriteByte('\n')
+	sb.Write(line)
+	sb.WriteByte('\n')
+	for prefix := src[start:offs]; len(prefix) > 0; {
+		r, size := utf8.DecodeRune(prefix)
+		if r == '\t' {
+			sb.WriteByte('\t')
+		} else {
+			sb.WriteByte(' ')
+		}
+		prefix = prefix[size:]
+	}
+	sb.WriteByte('^')
+	return sb.String()
+}
+
 func (p *Parser) posErr(pos Pos, format string, a ...interface{}) {
+	p.expectErr(pos, nil, format, a...)
+}
+
+// expectErr is like posErr, but also records what was expected instead of the
+// current token.
+func (p *Parser) expectErr(pos Pos, expected []string, format string, a ...interface{}) {
+	var sb strings.Builder
+	for _, exp := range expected {
+		sb.WriteString(exp)
+		sb.WriteByte(0)
+	}
 	p.errPass(ParseError{
 		Filename:   p.f.Name,
 		Pos:        pos,
 		Text:       fmt.Sprintf(format, a...),
+		Token:      p.tokText(),
+		expected:   sb.String(),
 		Incomplete: p.tok == _EOF && p.Incomplete(),
 	})
 }
 
+// limitErr reports that the input went past one of the parser's limits, such as
+// the one set via MaxNesting.
+func (p *Parser) limitErr(pos Pos, limit Limit, max int) {
+	p.errPass(LimitError{Filename: p.f.Name, Pos: pos, Limit: limit, Max: max})
+}
+
+// tokText returns the source text of the current token.
+func (p *Parser) tokText() string {
+	switch p.tok {
+	case illegalTok, _EOF:
+		return ""
+	case _Newl:
+		return "\n"
+	case _Lit, _LitWord, _LitRedir:
+		return p.val
+	}
+	return p.tok.String()
+}
+
 func (p *Parser) curErr(format string, a ...interface{}) {
 	p.posErr(p.pos, format, a...)
 }
@@ -757,11 +1578,24 @@ func (p *Parser) langErr(pos Pos, feature string, langs ...LangVariant) {
 	})
 }
 
+// checkLang reports whether the parser's language variant is one of langs,
+// the variants which accept the given feature. If it isn't, a LangError is
+// reported.
+func (p *Parser) checkLang(pos Pos, feature string, langs ...LangVariant) bool {
+	if p.lang.in(langs...) {
+		return true
+	}
+	p.langErr(pos, feature, langs...)
+	return false
+}
+
 func (p *Parser) stmts(fn func(*Stmt) bool, stops ...string) {
 	gotEnd := true
+	recovering := p.recoverErrs > 0 && p.openStmts == 0 && p.quote == noState
 loop:
 	for p.tok != _EOF {
 		newLine := p.got(_Newl)
+		pos, coms := p.pos, p.accComs
 		switch p.tok {
 		case _LitWord:
 			for _, stop := range stops {
@@ -786,14 +1620,19 @@ loop:
 		if !newLine && !gotEnd {
 			p.curErr("statements must be separated by &, ; or a newline")
 		}
-		if p.tok == _EOF {
-			break
+		var s *Stmt
+		if p.tok != _EOF {
+			p.openStmts++
+			s = p.getStmt(true, false, false)
+			p.openStmts--
+			if s == nil {
+				p.invalidStmtStart()
+			}
 		}
-		p.openStmts++
-		s := p.getStmt(true, false, false)
-		p.openStmts--
-		if s == nil {
-			p.invalidStmtStart()
+		if recovering && p.canRecover() {
+			s = p.badStmt(pos)
+			s.Comments = coms
+		} else if s == nil {
 			break
 		}
 		gotEnd = s.Semicolon.IsValid()
@@ -803,7 +1642,51 @@ loop:
 	}
 }
 
+// badStmt recovers from the error found while parsing the top-level
+// statement at pos, returning a statement holding a BadStmt to replace it. The
+// rest of the line is skipped, and the parser resumes at the following one.
+func (p *Parser) badStmt(pos Pos) *Stmt {
+	err := p.err
+	p.badErrs = append(p.badErrs, err)
+	p.bsp, p.r, p.w, p.npos = p.errBsp, p.errR, p.errW, p.errNpos
+	p.quote, p.forbidNested = noState, false
+	p.litBs, p.litBreaks = nil, p.litBreaks[:0]
+	p.heredocs, p.buriedHdocs, p.hdocStops = p.heredocs[:0], 0, nil
+	p.openBquotes, p.buriedBquotes = 0, 0
+	p.histQuote, p.histEsc = 0, false
+	p.accComs, p.curComs = nil, &p.accComs
+	for p.r != '\n' && p.r != utf8.RuneSelf {
+		p.rune()
+	}
+	s := p.stmt(pos)
+	s.Cmd = &BadStmt{From: pos, To: p.getPos(), Err: err}
+	if !p.readFailed() {
+		p.err = nil
+		p.tok = illegalTok
+		p.next()
+	}
+	return s
+}
+
+// canRecover reports whether the current error can be recovered from with
+// RecoverErrors. Errors when reading the input are never recovered from.
+func (p *Parser) canRecover() bool {
+	if _, ok := p.err.(LimitError); ok {
+		return false
+	}
+	return p.err != nil && p.err != errStopTokens &&
+		len(p.badErrs)+1 < p.recoverErrs && !p.readFailed()
+}
+
+func (p *Parser) readFailed() bool {
+	return p.readErr != nil && p.readErr != io.EOF
+}
+
 func (p *Parser) stmtList(stops ...string) ([]*Stmt, []Comment) {
+	if !p.nest() {
+		return nil, nil
+	}
+	defer p.unnest()
 	var stmts []*Stmt
 	var last []Comment
 	fn := func(s *Stmt) bool {
@@ -852,11 +1735,88 @@ func (p *Parser) invalidStmtStart() {
 
 func (p *Parser) getWord() *Word {
 	if parts := p.wordParts(); len(parts) > 0 && p.err == nil {
-		return p.word(parts)
+		return p.tildeWord(parts)
 	}
 	return nil
 }
 
+// tildeWord is like word, but it also splits a leading tilde prefix if the
+// current context allows tilde expansion.
+func (p *Parser) tildeWord(parts []WordPart) *Word {
+	if len(parts) > 0 && p.quote&allTildeWords != 0 {
+		parts = p.splitTilde(parts, false)
+	}
+	return p.word(parts)
+}
+
+// splitTilde replaces the tilde prefixes in a word's parts with *Tilde nodes.
+// Like in the shell, a prefix must be at the start of the word, must not
+// contain any quoted characters, and must end with a slash or at the end of
+// the word. In assignment values, prefixes may also follow or end with colons.
+func (p *Parser) splitTilde(parts []WordPart, assign bool) []WordPart {
+	ends := "/"
+	if assign {
+		ends = "/:"
+	}
+	var split []WordPart
+	start := true // at the start of the word, or after a colon
+	for i, part := range parts {
+		l, ok := part.(*Lit)
+		if !ok {
+			split = append(split, part)
+			start = false
+			continue
+		}
+		val, last, done := l.Value, i == len(parts)-1, 0
+		for j := 0; j < len(val); j++ {
+			atStart := j == 0 && start || assign && j > 0 && val[j-1] == ':'
+			if val[j] != '~' || !atStart {
+				continue
+			}
+			if strings.Contains(val[:j], "\n") {
+				break // positions can't be computed by columns
+			}
+			end := strings.IndexAny(val[j:], ends)
+			if end < 0 {
+				if !last {
+					continue
+				}
+				end = len(val)
+			} else {
+				end += j
+			}
+			user := val[j+1 : end]
+			if strings.Contains(user, "\\") {
+				continue
+			}
+			if j > done {
+				split = append(split, &Lit{
+					ValuePos: posAddCol(l.ValuePos, done),
+					ValueEnd: posAddCol(l.ValuePos, j),
+					Value:    val[done:j],
+				})
+			}
+			split = append(split, &Tilde{
+				ValuePos: posAddCol(l.ValuePos, j),
+				User:     user,
+			})
+			done = end
+		}
+		switch {
+		case done == 0:
+			split = append(split, l)
+		case done < len(val):
+			split = append(split, &Lit{
+				ValuePos: posAddCol(l.ValuePos, done),
+				ValueEnd: l.ValueEnd,
+				Value:    val[done:],
+			})
+		}
+		start = assign && len(val) > 0 && val[len(val)-1] == ':'
+	}
+	return split
+}
+
 func (p *Parser) getLit() *Lit {
 	switch p.tok {
 	case _Lit, _LitWord, _LitRedir:
@@ -869,15 +1829,20 @@ func (p *Parser) getLit() *Lit {
 
 func (p *Parser) wordParts() (wps []WordPart) {
 	for {
-		n := p.wordPart()
-		if n == nil {
+		if len(p.litBreaks) > 0 && (p.tok == _Lit || p.tok == _LitWord) {
+			wps = append(wps, p.litParts(p.pos, p.val)...)
+			p.next()
+		} else if n := p.wordPart(); n == nil {
 			return
-		}
-		if wps == nil {
+		} else if wps == nil {
 			wps = p.wps(n)
 		} else {
 			wps = append(wps, n)
 		}
+		if p.maxWordParts > 0 && len(wps) > p.maxWordParts {
+			p.limitErr(wps[p.maxWordParts].Pos(), LimitWordParts, p.maxWordParts)
+			return
+		}
 		if p.spaced {
 			return
 		}
@@ -900,14 +1865,10 @@ func (p *Parser) wordPart() WordPart {
 		p.ensureNoNested()
 		switch p.r {
 		case '|':
-			if p.lang != LangMirBSDKorn {
-				p.curErr(`"${|stmts;}" is a mksh feature`)
-			}
+			p.checkLang(p.pos, `"${|stmts;}"`, LangMirBSDKorn)
 			fallthrough
 		case ' ', '\t', '\n':
-			if p.lang != LangMirBSDKorn {
-				p.curErr(`"${ stmts;}" is a mksh feature`)
-			}
+			p.checkLang(p.pos, `"${ stmts;}"`, LangMirBSDKorn)
 			cs := &CmdSubst{
 				Left:     p.pos,
 				TempFile: p.r != '|',
@@ -925,6 +1886,10 @@ func (p *Parser) wordPart() WordPart {
 			cs.Right = pos
 			return cs
 		default:
+			if !p.nest() {
+				return nil
+			}
+			defer p.unnest()
 			return p.paramExp()
 		}
 	case dollDblParen, dollBrack:
@@ -939,9 +1904,7 @@ func (p *Parser) wordPart() WordPart {
 		}
 		p.next()
 		if p.got(hash) {
-			if p.lang != LangMirBSDKorn {
-				p.langErr(ar.Pos(), "unsigned expressions", LangMirBSDKorn)
-			}
+			p.checkLang(ar.Pos(), "unsigned expressions", LangMirBSDKorn)
 			ar.Unsigned = true
 		}
 		ar.X = p.followArithm(left, ar.Left)
@@ -982,6 +1945,9 @@ func (p *Parser) wordPart() WordPart {
 		p.ensureNoNested()
 		pe := &ParamExp{Dollar: p.pos, Short: true}
 		p.pos = posAddCol(p.pos, 1)
+		if p.val != "" {
+			p.emitTok(LitToken, p.pos, p.getPos(), p.val)
+		}
 		pe.Param = p.getLit()
 		if pe.Param != nil && pe.Param.Value == "" {
 			l := p.lit(pe.Dollar, "$")
@@ -1012,6 +1978,14 @@ func (p *Parser) wordPart() WordPart {
 			case '\'':
 				sq.Right = p.getPos()
 				sq.Value = p.endLit()
+				if p.tokFn != nil {
+					start := posAddCol(sq.Left, 1)
+					if sq.Dollar {
+						start = posAddCol(start, 1)
+					}
+					p.emitTok(LitToken, start, sq.Right, sq.Value)
+					p.emitTok(OpToken, sq.Right, posAddCol(sq.Right, 1), "'")
+				}
 
 				// restore openBquotes
 				p.openBquotes = p.buriedBquotes
@@ -1065,9 +2039,7 @@ func (p *Parser) wordPart() WordPart {
 		}
 		return cs
 	case globQuest, globStar, globPlus, globAt, globExcl:
-		if p.lang == LangPOSIX {
-			p.langErr(p.pos, "extended globs", LangBash, LangMirBSDKorn)
-		}
+		p.checkLang(p.pos, "extended globs", LangBash, LangMirBSDKorn)
 		eg := &ExtGlob{Op: GlobOperator(p.tok), OpPos: p.pos}
 		lparens := 1
 		r := p.r
@@ -1085,6 +2057,10 @@ func (p *Parser) wordPart() WordPart {
 			}
 		}
 		eg.Pattern = p.lit(posAddCol(eg.OpPos, 2), p.endLit())
+		p.emitTok(LitToken, eg.Pattern.ValuePos, eg.Pattern.ValueEnd, eg.Pattern.Value)
+		if lparens == 0 {
+			p.emitTok(OpToken, eg.Pattern.ValueEnd, posAddCol(eg.Pattern.ValueEnd, 1), ")")
+		}
 		p.rune()
 		p.next()
 		if lparens != 0 {
@@ -1127,6 +2103,7 @@ func (p *Parser) paramExp() *ParamExp {
 		p.tok = hash
 		p.pos = p.getPos()
 		p.rune()
+		p.emitTok(OpToken, p.pos, posAddCol(p.pos, 1), "#")
 	} else {
 		p.next()
 	}
@@ -1137,18 +2114,14 @@ func (p *Parser) paramExp() *ParamExp {
 			p.next()
 		}
 	case perc:
-		if p.lang != LangMirBSDKorn {
-			p.posErr(pe.Pos(), `"${%%foo}" is a mksh feature`)
-		}
+		p.checkLang(pe.Pos(), `"${%foo}"`, LangMirBSDKorn)
 		if paramNameOp(p.r) {
 			pe.Width = true
 			p.next()
 		}
 	case exclMark:
 		if paramNameOp(p.r) {
-			if p.lang == LangPOSIX {
-				p.langErr(p.pos, "${!foo}", LangBash, LangMirBSDKorn)
-			}
+			p.checkLang(p.pos, "${!foo}", LangBash, LangMirBSDKorn)
 			pe.Excl = true
 			p.next()
 		}
@@ -1185,9 +2158,7 @@ func (p *Parser) paramExp() *ParamExp {
 		p.next()
 		return pe
 	case leftBrack:
-		if p.lang == LangPOSIX {
-			p.langErr(p.pos, "arrays", LangBash, LangMirBSDKorn)
-		}
+		p.checkLang(p.pos, "arrays", LangBash, LangMirBSDKorn)
 		if !ValidName(pe.Param.Value) {
 			p.curErr("cannot index a special parameter name")
 		}
@@ -1205,9 +2176,7 @@ func (p *Parser) paramExp() *ParamExp {
 	switch p.tok {
 	case slash, dblSlash:
 		// pattern search and replace
-		if p.lang == LangPOSIX {
-			p.langErr(p.pos, "search and replace", LangBash, LangMirBSDKorn)
-		}
+		p.checkLang(p.pos, "search and replace", LangBash, LangMirBSDKorn, LangBusyBox)
 		pe.Repl = &Replace{All: p.tok == dblSlash}
 		p.quote = paramExpRepl
 		p.next()
@@ -1218,9 +2187,7 @@ func (p *Parser) paramExp() *ParamExp {
 		}
 	case colon:
 		// slicing
-		if p.lang == LangPOSIX {
-			p.langErr(p.pos, "slicing", LangBash, LangMirBSDKorn)
-		}
+		p.checkLang(p.pos, "slicing", LangBash, LangMirBSDKorn, LangBusyBox)
 		pe.Slice = &Slice{}
 		colonPos := p.pos
 		p.quote = paramExpSlice
@@ -1239,13 +2206,11 @@ func (p *Parser) paramExp() *ParamExp {
 		return pe
 	case caret, dblCaret, comma, dblComma:
 		// upper/lower case
-		if p.lang != LangBash {
-			p.langErr(p.pos, "this expansion operator", LangBash)
-		}
+		p.checkLang(p.pos, "this expansion operator", LangBash)
 		pe.Exp = p.paramExpExp()
 	case at, star:
 		switch {
-		case p.tok == at && p.lang == LangPOSIX:
+		case p.tok == at && !p.lang.in(LangBash, LangMirBSDKorn):
 			p.langErr(p.pos, "this expansion operator", LangBash, LangMirBSDKorn)
 		case p.tok == star && !pe.Excl:
 			p.curErr("not a valid parameter expansion operator: %v", p.tok)
@@ -1258,6 +2223,16 @@ func (p *Parser) paramExp() *ParamExp {
 	case plus, colPlus, minus, colMinus, quest, colQuest, assgn, colAssgn,
 		perc, dblPerc, hash, dblHash:
 		pe.Exp = p.paramExpExp()
+		// like the shell, only expand a tilde in a default value if the
+		// expansion isn't quoted; patterns are always expanded
+		quoted := old&(dblQuotes|hdocBody|hdocBodyTabs) != 0
+		switch pe.Exp.Op {
+		case RemSmallSuffix, RemLargeSuffix, RemSmallPrefix, RemLargePrefix:
+			quoted = false
+		}
+		if w := pe.Exp.Word; w != nil && !quoted {
+			w.Parts = p.splitTilde(w.Parts, false)
+		}
 	case _EOF:
 	default:
 		p.curErr("not a valid parameter expansion operator: %v", p.tok)
@@ -1346,7 +2321,7 @@ func (p *Parser) hasValidIdent() bool {
 		return false
 	}
 	if end := p.eqlOffs; end > 0 {
-		if p.val[end-1] == '+' && p.lang != LangPOSIX {
+		if p.val[end-1] == '+' && p.lang.in(LangBash, LangMirBSDKorn) {
 			end-- // a+=x
 		}
 		if ValidName(p.val[:end]) {
@@ -1362,7 +2337,7 @@ func (p *Parser) getAssign(needEqual bool) *Assign {
 	as := &Assign{}
 	if p.eqlOffs > 0 { // foo=bar
 		nameEnd := p.eqlOffs
-		if p.lang != LangPOSIX && p.val[p.eqlOffs-1] == '+' {
+		if p.lang.in(LangBash, LangMirBSDKorn) && p.val[p.eqlOffs-1] == '+' {
 			// a+=b
 			as.Append = true
 			nameEnd--
@@ -1370,15 +2345,14 @@ func (p *Parser) getAssign(needEqual bool) *Assign {
 		as.Name = p.lit(p.pos, p.val[:nameEnd])
 		// since we're not using the entire p.val
 		as.Name.ValueEnd = posAddCol(as.Name.ValuePos, nameEnd)
-		left := p.lit(posAddCol(p.pos, 1), p.val[p.eqlOffs+1:])
-		if left.Value != "" {
-			left.ValuePos = posAddCol(left.ValuePos, p.eqlOffs)
-			as.Value = p.word(p.wps(left))
+		if val := p.val[p.eqlOffs+1:]; val != "" {
+			as.Value = p.word(p.litParts(posAddCol(p.pos, p.eqlOffs+1), val))
 		}
 		p.next()
 	} else { // foo[x]=bar
 		as.Name = p.lit(p.pos, p.val)
 		// hasValidIdent already checks p.r is '['
+		p.emitTok(OpToken, as.Name.ValueEnd, posAddCol(as.Name.ValueEnd, 1), "[")
 		p.rune()
 		p.pos = posAddCol(p.pos, 1)
 		as.Index = p.eitherIndex()
@@ -1410,18 +2384,19 @@ func (p *Parser) getAssign(needEqual bool) *Assign {
 		}
 	}
 	if p.spaced || stopToken(p.tok) {
+		if as.Value != nil {
+			as.Value.Parts = p.splitTilde(as.Value.Parts, true)
+		}
 		return as
 	}
 	if as.Value == nil && p.tok == leftParen {
-		if p.lang == LangPOSIX {
-			p.langErr(p.pos, "arrays", LangBash, LangMirBSDKorn)
-		}
+		p.checkLang(p.pos, "arrays", LangBash, LangMirBSDKorn)
 		if as.Index != nil {
 			p.curErr("arrays cannot be nested")
 		}
 		as.Array = &ArrayExpr{Lparen: p.pos}
 		newQuote := p.quote
-		if p.lang == LangBash {
+		if p.lang.in(LangBash) {
 			newQuote = arrayElems
 		}
 		old := p.preNested(newQuote)
@@ -1467,6 +2442,9 @@ func (p *Parser) getAssign(needEqual bool) *Assign {
 			as.Value.Parts = append(as.Value.Parts, w.Parts...)
 		}
 	}
+	if as.Value != nil {
+		as.Value.Parts = p.splitTilde(as.Value.Parts, true)
+	}
 	return as
 }
 
@@ -1494,8 +2472,8 @@ func (p *Parser) doRedirect(s *Stmt) {
 		s.Redirs = append(s.Redirs, r)
 	}
 	r.N = p.getLit()
-	if p.lang != LangBash && r.N != nil && r.N.Value[0] == '{' {
-		p.langErr(r.N.Pos(), "{varname} redirects", LangBash)
+	if r.N != nil && r.N.Value[0] == '{' {
+		p.checkLang(r.N.Pos(), "{varname} redirects", LangBash)
 	}
 	r.Op, r.OpPos = RedirOperator(p.tok), p.pos
 	p.next()
@@ -1536,13 +2514,19 @@ func (p *Parser) getStmt(readEnd, binCmd, fnBody bool) *Stmt {
 	if s = p.gotStmtPipe(s, false); s == nil || p.err != nil {
 		return nil
 	}
-	// instead of using recursion, iterate manually
+	// instead of using recursion, iterate manually; the nesting level
+	// still grows with each BinaryCmd, as the resulting tree is deep
+	nesting := p.nesting
 	for p.tok == andAnd || p.tok == orOr {
 		if binCmd {
 			// left associativity: in a list of BinaryCmds, the
 			// right recursion should only read a single element
 			return s
 		}
+		if !p.nest() {
+			p.nesting = nesting
+			return nil
+		}
 		b := &BinaryCmd{
 			OpPos: p.pos,
 			Op:    BinCmdOperator(p.tok),
@@ -1553,12 +2537,14 @@ func (p *Parser) getStmt(readEnd, binCmd, fnBody bool) *Stmt {
 		b.Y = p.getStmt(false, true, false)
 		if b.Y == nil || p.err != nil {
 			p.followErr(b.OpPos, b.Op.String(), "a statement")
+			p.nesting = nesting
 			return nil
 		}
 		s = p.stmt(s.Position)
 		s.Cmd = b
 		s.Comments, b.X.Comments = b.X.Comments, nil
 	}
+	p.nesting = nesting
 	if readEnd {
 		switch p.tok {
 		case semicolon:
@@ -1588,6 +2574,29 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 	s.Comments, p.accComs = p.accComs, nil
 	switch p.tok {
 	case _LitWord:
+		if p.aliases != nil && p.expandAlias() {
+			return p.gotStmtPipe(s, binCmd)
+		}
+		if p.lang == LangPOSIX && p.strictPOSIX {
+			p.strictKeyword()
+		}
+		switch p.val {
+		case "{", "if", "while", "until", "for", "case", "}", "then",
+			"elif", "fi", "do", "done", "esac", "!":
+			p.markTok(useKeyword)
+		case "[[", "]]", "time", "select":
+			if p.lang.in(LangBash, LangMirBSDKorn) {
+				p.markTok(useKeyword)
+			}
+		case "function":
+			if p.lang.in(LangBash, LangMirBSDKorn, LangBusyBox) {
+				p.markTok(useKeyword)
+			}
+		case "coproc":
+			if p.lang.in(LangBash) {
+				p.markTok(useKeyword)
+			}
+		}
 		switch p.val {
 		case "{":
 			p.block(s)
@@ -1619,42 +2628,46 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 				break
 			}
 		case "[[":
-			if p.lang != LangPOSIX {
+			if p.lang.in(LangBash, LangMirBSDKorn) {
 				p.testClause(s)
 			}
 		case "]]":
-			if p.lang != LangPOSIX {
+			if p.lang.in(LangBash, LangMirBSDKorn) {
 				p.curErr(`%q can only be used to close a test`,
 					p.val)
 			}
 		case "let":
-			if p.lang != LangPOSIX {
+			if p.lang.in(LangBash, LangMirBSDKorn) {
 				p.letClause(s)
 			}
 		case "function":
-			if p.lang != LangPOSIX {
+			if p.lang.in(LangBash, LangMirBSDKorn, LangBusyBox) {
 				p.bashFuncDecl(s)
 			}
 		case "declare":
-			if p.lang == LangBash {
+			if p.lang.in(LangBash) {
 				p.declClause(s)
 			}
 		case "local", "export", "readonly", "typeset", "nameref":
-			if p.lang != LangPOSIX {
+			if p.lang.in(LangBash, LangMirBSDKorn) {
 				p.declClause(s)
 			}
 		case "time":
-			if p.lang != LangPOSIX {
+			if p.lang.in(LangBash, LangMirBSDKorn) {
 				p.timeClause(s)
 			}
 		case "coproc":
-			if p.lang == LangBash {
+			if p.lang.in(LangBash) {
 				p.coprocClause(s)
 			}
 		case "select":
-			if p.lang != LangPOSIX {
+			if p.lang.in(LangBash, LangMirBSDKorn) {
 				p.selectClause(s)
 			}
+		case "@test":
+			if p.lang.in(LangBats) {
+				p.testDecl(s)
+			}
 		}
 		if s.Cmd != nil {
 			break
@@ -1664,14 +2677,21 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 			break
 		}
 		name := p.lit(p.pos, p.val)
+		var parts []WordPart
+		if len(p.litBreaks) > 0 {
+			parts = p.litParts(p.pos, p.val)
+		}
 		if p.next(); p.got(leftParen) {
 			p.follow(name.ValuePos, "foo(", rightParen)
-			if p.lang == LangPOSIX && !ValidName(name.Value) {
+			if !p.lang.in(LangBash, LangMirBSDKorn) && !ValidName(name.Value) {
 				p.posErr(name.Pos(), "invalid func name")
 			}
-			p.funcDecl(s, name, name.ValuePos)
+			p.funcDecl(s, name, name.ValuePos, false)
 		} else {
-			p.callExpr(s, p.word(p.wps(name)), false)
+			if parts == nil {
+				parts = p.wps(name)
+			}
+			p.callExpr(s, p.tildeWord(parts), false)
 		}
 	case rdrOut, appOut, rdrIn, dplIn, dplOut, clbOut, rdrInOut,
 		hdoc, dashHdoc, wordHdoc, rdrAll, appAll, _LitRedir:
@@ -1689,7 +2709,7 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 			p.callExpr(s, nil, true)
 			break
 		}
-		w := p.word(p.wordParts())
+		w := p.tildeWord(p.wordParts())
 		if p.got(leftParen) {
 			p.posErr(w.Pos(), "invalid func name")
 		}
@@ -1706,18 +2726,22 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 	for p.peekRedir() {
 		p.doRedirect(s)
 	}
-	// instead of using recursion, iterate manually
+	// instead of using recursion, iterate manually; see getStmt
+	nesting := p.nesting
 	for p.tok == or || p.tok == orAnd {
 		if binCmd {
 			// left associativity: in a list of BinaryCmds, the
 			// right recursion should only read a single element
 			return s
 		}
-		if p.tok == orAnd && p.lang == LangMirBSDKorn {
+		if p.tok == orAnd && p.lang.in(LangMirBSDKorn) {
 			// No need to check for LangPOSIX, as on that language
 			// we parse |& as two tokens.
 			break
 		}
+		if !p.nest() {
+			break
+		}
 		b := &BinaryCmd{OpPos: p.pos, Op: BinCmdOperator(p.tok), X: s}
 		p.next()
 		p.got(_Newl)
@@ -1732,6 +2756,7 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 		s.Negated = b.X.Negated
 		b.X.Negated = false
 	}
+	p.nesting = nesting
 	return s
 }
 
@@ -1750,9 +2775,7 @@ func (p *Parser) arithmExpCmd(s *Stmt) {
 	old := p.preNested(arithmExprCmd)
 	p.next()
 	if p.got(hash) {
-		if p.lang != LangMirBSDKorn {
-			p.langErr(ar.Pos(), "unsigned expressions", LangMirBSDKorn)
-		}
+		p.checkLang(ar.Pos(), "unsigned expressions", LangMirBSDKorn)
 		ar.Unsigned = true
 	}
 	ar.X = p.followArithm(dblLeftParen, ar.Left)
@@ -1783,6 +2806,7 @@ func (p *Parser) ifClause(s *Stmt) {
 		elf := &IfClause{Position: p.pos}
 		curIf.Last = p.accComs
 		p.accComs = nil
+		p.markTok(useKeyword)
 		p.next()
 		elf.Cond, elf.CondLast = p.followStmts("elif", elf.Position, "then")
 		elf.ThenPos = p.followRsrv(elf.Position, "elif <cond>", "then")
@@ -1831,9 +2855,7 @@ func (p *Parser) forClause(s *Stmt) {
 
 	start, end := "do", "done"
 	if pos, ok := p.gotRsrv("{"); ok {
-		if p.lang == LangPOSIX {
-			p.langErr(pos, "for loops with braces", LangBash, LangMirBSDKorn)
-		}
+		p.checkLang(pos, "for loops with braces", LangBash, LangMirBSDKorn)
 		fc.DoPos = pos
 		fc.Braces = true
 		start, end = "{", "}"
@@ -1849,11 +2871,9 @@ func (p *Parser) forClause(s *Stmt) {
 }
 
 func (p *Parser) loop(fpos Pos) Loop {
-	if p.lang != LangBash {
-		switch p.tok {
-		case leftParen, dblLeftParen:
-			p.langErr(p.pos, "c-style fors", LangBash)
-		}
+	switch p.tok {
+	case leftParen, dblLeftParen:
+		p.checkLang(p.pos, "c-style fors", LangBash)
 	}
 	if p.tok == dblLeftParen {
 		cl := &CStyleLoop{Lparen: p.pos}
@@ -1897,7 +2917,8 @@ func (p *Parser) wordIter(ftok string, fpos Pos) *WordIter {
 		p.got(_Newl)
 	} else if p.tok == _LitWord && p.val == "do" {
 	} else {
-		p.followErr(fpos, ftok+" foo", `"in", "do", ;, or a newline`)
+		p.followErr(fpos, ftok+" foo", `"in", "do", ;, or a newline`,
+			"in", "do", ";", "\n")
 	}
 	return wi
 }
@@ -1924,9 +2945,7 @@ func (p *Parser) caseClause(s *Stmt) {
 	if pos, ok := p.gotRsrv("{"); ok {
 		cc.In = pos
 		cc.Braces = true
-		if p.lang != LangMirBSDKorn {
-			p.posErr(cc.Pos(), `"case i {" is a mksh feature`)
-		}
+		p.checkLang(cc.Pos(), `"case i {"`, LangMirBSDKorn)
 		end = "}"
 	} else {
 		cc.In = p.followRsrv(cc.Case, "case x", "in")
@@ -2049,13 +3068,16 @@ func (p *Parser) testExpr(ftok token, fpos Pos, pastAndOr bool) TestExpr {
 	switch b.Op {
 	case AndTest, OrTest:
 		p.next()
-		if b.Y = p.testExpr(token(b.Op), b.OpPos, false); b.Y == nil {
+		if !p.nest() {
+			return nil
+		}
+		b.Y = p.testExpr(token(b.Op), b.OpPos, false)
+		p.unnest()
+		if b.Y == nil {
 			p.followErrExp(b.OpPos, b.Op.String())
 		}
 	case TsReMatch:
-		if p.lang != LangBash {
-			p.langErr(p.pos, "regex tests", LangBash)
-		}
+		p.checkLang(p.pos, "regex tests", LangBash)
 		p.rxOpenParens = 0
 		p.rxFirstPart = true
 		// TODO(mvdan): Using nested states within a regex will break in
@@ -2065,8 +3087,8 @@ func (p *Parser) testExpr(ftok token, fpos Pos, pastAndOr bool) TestExpr {
 		fallthrough
 	default:
 		if _, ok := b.X.(*Word); !ok {
-			p.posErr(b.OpPos, "expected %s, %s or %s after complex expr",
-				AndTest, OrTest, "]]")
+			p.expectErr(b.OpPos, []string{AndTest.String(), OrTest.String(), "]]"},
+				"expected %s, %s or %s after complex expr", AndTest, OrTest, "]]")
 		}
 		p.next()
 		b.Y = p.followWordTok(token(b.Op), b.OpPos)
@@ -2076,6 +3098,10 @@ func (p *Parser) testExpr(ftok token, fpos Pos, pastAndOr bool) TestExpr {
 }
 
 func (p *Parser) testExprBase(ftok token, fpos Pos) TestExpr {
+	if !p.nest() {
+		return nil
+	}
+	defer p.unnest()
 	switch p.tok {
 	case _EOF, rightParen:
 		return nil
@@ -2084,7 +3110,7 @@ func (p *Parser) testExprBase(ftok token, fpos Pos) TestExpr {
 		switch op {
 		case illegalTok:
 		case tsRefVar, tsModif: // not available in mksh
-			if p.lang == LangBash {
+			if p.lang.in(LangBash) {
 				p.tok = op
 			}
 		default:
@@ -2094,6 +3120,7 @@ func (p *Parser) testExprBase(ftok token, fpos Pos) TestExpr {
 	switch p.tok {
 	case exclMark:
 		u := &UnaryTest{OpPos: p.pos, Op: TsNot}
+		p.markTok(useUnaryTest)
 		p.next()
 		if u.X = p.testExpr(token(u.Op), u.OpPos, false); u.X == nil {
 			p.followErrExp(u.OpPos, u.Op.String())
@@ -2104,6 +3131,7 @@ func (p *Parser) testExprBase(ftok token, fpos Pos) TestExpr {
 		tsUsrOwn, tsModif, tsRead, tsWrite, tsExec, tsNoEmpty,
 		tsFdTerm, tsEmpStr, tsNempStr, tsOptSet, tsVarSet, tsRefVar:
 		u := &UnaryTest{OpPos: p.pos, Op: UnTestOperator(p.tok)}
+		p.markTok(useUnaryTest)
 		p.next()
 		u.X = p.followWordTok(token(u.Op), u.OpPos)
 		return u
@@ -2170,6 +3198,10 @@ func isBashCompoundCommand(tok token, val string) bool {
 }
 
 func (p *Parser) timeClause(s *Stmt) {
+	if !p.nest() {
+		return
+	}
+	defer p.unnest()
 	tc := &TimeClause{Time: p.pos}
 	p.next()
 	if _, ok := p.gotRsrv("-p"); ok {
@@ -2180,6 +3212,10 @@ func (p *Parser) timeClause(s *Stmt) {
 }
 
 func (p *Parser) coprocClause(s *Stmt) {
+	if !p.nest() {
+		return
+	}
+	defer p.unnest()
 	cc := &CoprocClause{Coproc: p.pos}
 	if p.next(); isBashCompoundCommand(p.tok, p.val) {
 		// has no name
@@ -2208,6 +3244,22 @@ func (p *Parser) coprocClause(s *Stmt) {
 	s.Cmd = cc
 }
 
+func (p *Parser) testDecl(s *Stmt) {
+	if !p.nest() {
+		return
+	}
+	defer p.unnest()
+	td := &TestDecl{Position: p.pos}
+	p.next()
+	if td.Description = p.getWord(); td.Description == nil {
+		p.followErr(td.Position, "@test", "a description word")
+	}
+	if td.Body = p.getStmt(false, false, true); td.Body == nil {
+		p.followErr(td.Position, `@test "desc"`, "a statement")
+	}
+	s.Cmd = td
+}
+
 func (p *Parser) letClause(s *Stmt) {
 	lc := &LetClause{Let: p.pos}
 	old := p.preNested(arithmExprLet)
@@ -2232,10 +3284,33 @@ func (p *Parser) bashFuncDecl(s *Stmt) {
 		p.followErr(fpos, "function", "a name")
 	}
 	name := p.lit(p.pos, p.val)
+	hasParens := false
 	if p.next(); p.got(leftParen) {
+		hasParens = true
 		p.follow(name.ValuePos, "foo(", rightParen)
 	}
-	p.funcDecl(s, name, fpos)
+	p.funcDecl(s, name, fpos, hasParens)
+}
+
+// strictKeyword errors if the current word starts a compound command or a
+// declaration in Bash or mksh.
+func (p *Parser) strictKeyword() {
+	switch p.val {
+	case "[[":
+		p.langErr(p.pos, "test clauses", LangBash, LangMirBSDKorn)
+	case "function":
+		p.langErr(p.pos, `"function" declarations`, LangBash, LangMirBSDKorn, LangBusyBox)
+	case "let":
+		p.langErr(p.pos, "let clauses", LangBash, LangMirBSDKorn)
+	case "declare":
+		p.langErr(p.pos, `"declare" clauses`, LangBash)
+	case "typeset", "nameref":
+		p.langErr(p.pos, fmt.Sprintf("%q clauses", p.val), LangBash, LangMirBSDKorn)
+	case "select":
+		p.langErr(p.pos, "select loops", LangBash, LangMirBSDKorn)
+	case "coproc":
+		p.langErr(p.pos, "coprocesses", LangBash)
+	}
 }
 
 func (p *Parser) callExpr(s *Stmt, w *Word, assign bool) {
@@ -2248,6 +3323,8 @@ func (p *Parser) callExpr(s *Stmt, w *Word, assign bool) {
 	}
 loop:
 	for {
+		aliasBlank := p.aliasBlank
+		p.aliasBlank = false
 		switch p.tok {
 		case _EOF, _Newl, semicolon, and, or, andAnd, orOr, orAnd,
 			dblSemicolon, semiAnd, dblSemiAnd, semiOr:
@@ -2257,16 +3334,18 @@ loop:
 				ce.Assigns = append(ce.Assigns, p.getAssign(true))
 				break
 			}
-			ce.Args = append(ce.Args, p.word(
-				p.wps(p.lit(p.pos, p.val)),
-			))
+			if (len(ce.Args) == 0 || aliasBlank) && p.aliases != nil &&
+				p.expandAlias() {
+				break
+			}
+			ce.Args = append(ce.Args, p.tildeWord(p.litParts(p.pos, p.val)))
 			p.next()
 		case _Lit:
 			if len(ce.Args) == 0 && p.hasValidIdent() {
 				ce.Assigns = append(ce.Assigns, p.getAssign(true))
 				break
 			}
-			ce.Args = append(ce.Args, p.word(p.wordParts()))
+			ce.Args = append(ce.Args, p.tildeWord(p.wordParts()))
 		case bckQuote:
 			if p.backquoteEnd() {
 				break loop
@@ -2275,7 +3354,7 @@ loop:
 		case dollBrace, dollDblParen, dollParen, dollar, cmdIn, cmdOut,
 			sglQuote, dollSglQuote, dblQuote, dollDblQuote, dollBrack,
 			globQuest, globStar, globPlus, globAt, globExcl:
-			ce.Args = append(ce.Args, p.word(p.wordParts()))
+			ce.Args = append(ce.Args, p.tildeWord(p.wordParts()))
 		case rdrOut, appOut, rdrIn, dplIn, dplOut, clbOut, rdrInOut,
 			hdoc, dashHdoc, wordHdoc, rdrAll, appAll, _LitRedir:
 			p.doRedirect(s)
@@ -2305,10 +3384,15 @@ loop:
 	s.Cmd = ce
 }
 
-func (p *Parser) funcDecl(s *Stmt, name *Lit, pos Pos) {
+func (p *Parser) funcDecl(s *Stmt, name *Lit, pos Pos, withParens bool) {
+	if !p.nest() {
+		return
+	}
+	defer p.unnest()
 	fd := &FuncDecl{
 		Position: pos,
 		RsrvWord: pos != name.ValuePos,
+		Parens:   withParens,
 		Name:     name,
 	}
 	p.got(_Newl)
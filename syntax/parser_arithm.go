@@ -1,5 +1,10 @@
 package syntax
 
+import (
+	"fmt"
+	"strings"
+)
+
 // compact specifies whether we allow spaces between expressions.
 // This is true for let
 func (p *Parser) arithmExpr(compact bool) ArithmExpr {
@@ -28,7 +33,11 @@ func (p *Parser) arithmExprAssign(compact bool) ArithmExpr {
 		pos := p.pos
 		tok := p.tok
 		p.nextArithOp(compact)
+		if !p.nest() {
+			return nil
+		}
 		y := p.arithmExprAssign(compact)
+		p.unnest()
 		if y == nil {
 			p.followErrExp(pos, tok.String())
 		}
@@ -56,6 +65,10 @@ func (p *Parser) arithmExprTernary(compact bool) ArithmExpr {
 	if BinAritOperator(p.tok) == TernColon {
 		p.followErrExp(questPos, TernQuest.String())
 	}
+	if !p.nest() {
+		return nil
+	}
+	defer p.unnest()
 	trueExpr := p.arithmExpr(compact)
 	if trueExpr == nil {
 		p.followErrExp(questPos, TernQuest.String())
@@ -136,7 +149,11 @@ func (p *Parser) arithmExprPower(compact bool) ArithmExpr {
 	op := p.tok
 	pos := p.pos
 	p.nextArithOp(compact)
+	if !p.nest() {
+		return nil
+	}
 	y := p.arithmExprPower(compact)
+	p.unnest()
 	if y == nil {
 		p.followErrExp(pos, op.String())
 	}
@@ -149,6 +166,10 @@ func (p *Parser) arithmExprPower(compact bool) ArithmExpr {
 }
 
 func (p *Parser) arithmExprUnary(compact bool) ArithmExpr {
+	if !p.nest() {
+		return nil
+	}
+	defer p.unnest()
 	if !compact {
 		p.got(_Newl)
 	}
@@ -345,9 +366,70 @@ func (p *Parser) arithmEnd(ltok token, lpos Pos, old saveState) Pos {
 	if !p.peekArithmEnd() {
 		p.arithmMatchingErr(lpos, ltok, dblRightParen)
 	}
+	p.emitTok(OpToken, posAddCol(p.pos, 1), posAddCol(p.pos, 2), ")")
 	p.rune()
 	p.postNested(old)
 	pos := p.pos
 	p.next()
 	return pos
 }
+
+// ArithmInt returns the integer value of a numeric literal in an arithmetic
+// expression, such as "12", "0xff", "010" or "2#1011". As in Bash, a leading
+// "0x" or "0X" denotes hexadecimal, a leading "0" denotes octal, and
+// "base#value" denotes any base between 2 and 64.
+//
+// Digits greater than 9 are represented by lowercase letters, uppercase
+// letters, '@' and '_', in that order. With bases up to 36, lowercase and
+// uppercase letters are interchangeable.
+func ArithmInt(lit string) (int, error) {
+	base, digits := 10, lit
+	switch {
+	case strings.HasPrefix(lit, "0x"), strings.HasPrefix(lit, "0X"):
+		base, digits = 16, lit[2:]
+	case strings.HasPrefix(lit, "0") && len(lit) > 1:
+		base, digits = 8, lit[1:]
+	default:
+		if i := strings.IndexByte(lit, '#'); i >= 0 {
+			if !numberLiteral(lit[:i]) {
+				return 0, fmt.Errorf("invalid arithmetic base: %q", lit[:i])
+			}
+			base, digits = 0, lit[i+1:]
+			for _, r := range lit[:i] {
+				if base = base*10 + int(r-'0'); base > 64 {
+					break
+				}
+			}
+			if base < 2 || base > 64 {
+				return 0, fmt.Errorf("invalid arithmetic base: %q", lit[:i])
+			}
+		}
+	}
+	if digits == "" {
+		return 0, fmt.Errorf("invalid arithmetic number: %q", lit)
+	}
+	n := 0
+	for _, r := range digits {
+		d := 64
+		switch {
+		case '0' <= r && r <= '9':
+			d = int(r - '0')
+		case 'a' <= r && r <= 'z':
+			d = int(r-'a') + 10
+		case 'A' <= r && r <= 'Z':
+			d = int(r-'A') + 10
+			if base > 36 {
+				d += 26
+			}
+		case r == '@':
+			d = 62
+		case r == '_':
+			d = 63
+		}
+		if d >= base {
+			return 0, fmt.Errorf("value too great for base: %q", lit)
+		}
+		n = n*base + d
+	}
+	return n, nil
+}
@@ -5,6 +5,7 @@ package syntax
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/kr/pretty"
 )
@@ -31,6 +33,172 @@ func TestKeepComments(t *testing.T) {
 	singleParse(NewParser(KeepComments(true)), in, want)(t)
 }
 
+func TestParseShebang(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want *Shebang
+	}{
+		{"foo", nil},
+		{"# bar\n#!/bin/sh", nil},
+		{" #!/bin/sh", nil},
+		{"#!/bin/sh", &Shebang{Text: "/bin/sh", Path: "/bin/sh"}},
+		{"#!/usr/bin/env bash\nfoo", &Shebang{
+			Text: "/usr/bin/env bash",
+			Path: "/usr/bin/env",
+			Args: []string{"bash"},
+		}},
+		{"#! /bin/bash -eu -o pipefail\n", &Shebang{
+			Text: " /bin/bash -eu -o pipefail",
+			Path: "/bin/bash",
+			Args: []string{"-eu", "-o", "pipefail"},
+		}},
+		{"#!\n", &Shebang{}},
+		{"\ufeff#!/bin/sh", &Shebang{Text: "/bin/sh", Path: "/bin/sh"}},
+		{"\ufeff #!/bin/sh", nil},
+	}
+	for i, tc := range tests {
+		want := &File{Shebang: tc.want}
+		want.BOM = strings.HasPrefix(tc.in, "\ufeff")
+		if strings.Contains(tc.in, "foo") {
+			want.Stmts = litStmts("foo")
+		}
+		t.Run(fmt.Sprintf("%02d", i), singleParse(NewParser(), tc.in, want))
+	}
+}
+
+func TestParseCharClassOneByte(t *testing.T) {
+	t.Parallel()
+	// peeking past "a[" must not depend on how the input is read
+	for _, in := range []string{
+		"echo a[[:alpha:]]",
+		"echo a[[:alpha:]_]b",
+		"echo a[b] a[[b]",
+		"a[1]=b",
+		"case x in x[[:space:]]) ;; esac",
+	} {
+		p := NewParser()
+		want, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatalf("Unexpected error in %q: %v", in, err)
+		}
+		got, err := p.Parse(iotest.OneByteReader(strings.NewReader(in)), "")
+		if err != nil {
+			t.Fatalf("Unexpected error in %q: %v", in, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Reading one byte at a time changed the syntax tree of %q", in)
+		}
+	}
+}
+
+func TestParseBOM(t *testing.T) {
+	t.Parallel()
+	const bom = "\ufeff"
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"foo", false},
+		{"", false},
+		{bom, true},
+		{bom + "foo", true},
+		{bom + bom + "foo", true},
+		{"foo" + bom, false},
+	}
+	p := NewParser()
+	printer := NewPrinter()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			// a reader of one byte at a time may not give us the
+			// entire byte order mark at once
+			for _, r := range []io.Reader{
+				strings.NewReader(tc.in),
+				iotest.OneByteReader(strings.NewReader(tc.in)),
+			} {
+				f, err := p.Parse(r, "")
+				if err != nil {
+					t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+				}
+				if f.BOM != tc.want {
+					t.Fatalf("BOM mismatch in %q: want %t, got %t",
+						tc.in, tc.want, f.BOM)
+				}
+				var buf bytes.Buffer
+				printer.Print(&buf, f)
+				if got := buf.String(); strings.HasPrefix(got, bom) != tc.want {
+					t.Fatalf("BOM not printed back in %q: %q", tc.in, got)
+				}
+			}
+		})
+	}
+	t.Run("Positions", func(t *testing.T) {
+		f, err := p.Parse(strings.NewReader(bom+"foo bar"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := f.Stmts[0].Cmd.(*CallExpr).Args
+		if got := args[0].Lit(); got != "foo" {
+			t.Fatalf("want the first word to be foo, got %q", got)
+		}
+		if pos := args[0].Pos(); pos.Offset() != 3 || pos.Col() != 1 {
+			t.Fatalf("want foo to be at offset 3 and 1:1, got %s", pos)
+		}
+		if pos := args[1].Pos(); pos.Offset() != 7 || pos.Col() != 5 {
+			t.Fatalf("want bar to be at offset 7 and 1:5, got %s", pos)
+		}
+	})
+	t.Run("Stmts", func(t *testing.T) {
+		var got []string
+		err := p.Stmts(strings.NewReader(bom+"foo\nbar"), func(s *Stmt) bool {
+			got = append(got, s.Cmd.(*CallExpr).Args[0].Lit())
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"foo", "bar"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("want the statements %q, got %q", want, got)
+		}
+	})
+	t.Run("Interactive", func(t *testing.T) {
+		var got []string
+		err := p.Interactive(strings.NewReader(bom+"foo\nbar\n"), func(stmts []*Stmt) bool {
+			for _, s := range stmts {
+				got = append(got, s.Cmd.(*CallExpr).Args[0].Lit())
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"foo", "bar"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("want the statements %q, got %q", want, got)
+		}
+	})
+	t.Run("InteractiveShort", func(t *testing.T) {
+		// a line shorter than a byte order mark must be parsed without
+		// waiting for more input
+		inReader, inWriter := io.Pipe()
+		recv := make(chan []*Stmt)
+		errc := make(chan error, 1)
+		go func() {
+			errc <- NewParser().Interactive(inReader, func(stmts []*Stmt) bool {
+				recv <- stmts
+				return true
+			})
+		}()
+		io.WriteString(inWriter, "a\n")
+		if stmts := <-recv; len(stmts) != 1 {
+			t.Fatalf("want one statement, got %d", len(stmts))
+		}
+		inWriter.Close()
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
 func TestParseBash(t *testing.T) {
 	t.Parallel()
 	p := NewParser()
@@ -75,6 +243,119 @@ func TestParseMirBSDKorn(t *testing.T) {
 	}
 }
 
+func TestParseBats(t *testing.T) {
+	t.Parallel()
+	p := NewParser(Variant(LangBats))
+	// Bats is a superset of Bash.
+	for i, c := range append(fileTests, fileTestsNoPrint...) {
+		want := c.Bash
+		if want == nil {
+			continue
+		}
+		for j, in := range c.Strs {
+			t.Run(fmt.Sprintf("%03d-%d", i, j), singleParse(p, in, want))
+		}
+	}
+	tests := []struct {
+		in   string
+		want *File
+	}{
+		{
+			"@test \"foo bar\" {\n\trun foo\n}",
+			fullProg(&TestDecl{
+				Description: word(dblQuoted(lit("foo bar"))),
+				Body:        stmt(block(litStmt("run", "foo"))),
+			}),
+		},
+		{
+			"@test foo { bar; }\nbaz",
+			fullProg([]*Stmt{
+				stmt(&TestDecl{
+					Description: litWord("foo"),
+					Body:        stmt(block(litStmt("bar"))),
+				}),
+				litStmt("baz"),
+			}),
+		},
+	}
+	printer := NewPrinter()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("Bats%02d", i), func(t *testing.T) {
+			singleParse(p, tc.in, tc.want)(t)
+			prog, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			printer.Print(&buf, prog)
+			if got, want := buf.String(), tc.in+"\n"; got != want {
+				t.Fatalf("Print mismatch:\nwant: %q\ngot:  %q", want, got)
+			}
+		})
+	}
+	// @test is just a command name in Bash.
+	prog, err := NewParser().Parse(strings.NewReader("@test foo"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := prog.Stmts[0].Cmd.(*CallExpr); !ok {
+		t.Fatalf("want @test to be a command in Bash")
+	}
+}
+
+func TestParseBusyBox(t *testing.T) {
+	t.Parallel()
+	p := NewParser(Variant(LangBusyBox))
+	parseWith := func(p *Parser, in string) *File {
+		t.Helper()
+		prog, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatalf("Unexpected error in %q: %v", in, err)
+		}
+		clearPosRecurse(t, in, prog)
+		return prog
+	}
+	// These Bash features are supported by BusyBox.
+	bash := NewParser()
+	for _, in := range []string{
+		"echo $'a\\tb'",
+		"echo ${a:1:2} ${a/b/c} ${a//b}",
+		"foo &>/dev/null",
+		"cat <<<foo",
+		"function f { bar; }",
+		"function f() { bar; }",
+	} {
+		want := parseWith(bash, in)
+		singleParse(p, in, want)(t)
+	}
+	// Others parse like in POSIX Shell.
+	posix := NewParser(Variant(LangPOSIX))
+	for _, in := range []string{
+		"[[ a ]]",
+		"let i++",
+		"local a=b",
+		"declare a",
+		"echo $\"a\"",
+		"a+=b",
+	} {
+		want := parseWith(posix, in)
+		singleParse(p, in, want)(t)
+	}
+	// And others are not supported at all.
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"a=(b c)", `1:3: arrays are a bash/mksh feature`},
+		{"echo ${a[0]}", `1:9: arrays are a bash/mksh feature`},
+		{"echo @(a)", `1:6: extended globs are a bash/mksh feature`},
+		{"echo ${!a}", `1:8: ${!foo} is a bash/mksh feature`},
+		{"echo ${a@Q}", `1:9: this expansion operator is a bash/mksh feature`},
+		{"foo |& bar", `1:5: | must be followed by a statement`},
+	} {
+		checkError(p, tc.in, tc.want)(t)
+	}
+}
+
 var (
 	hasBash50  bool
 	hasDash059 bool
@@ -287,6 +568,10 @@ func singleParse(p *Parser, in string, want *File) func(t *testing.T) {
 			t.Fatalf("Unexpected error in %q: %v", in, err)
 		}
 		clearPosRecurse(t, in, got)
+		if got.Variant != p.lang {
+			t.Fatalf("Variant mismatch in %q: want %s, got %s", in, p.lang, got.Variant)
+		}
+		got.Variant = want.Variant
 		if !reflect.DeepEqual(got, want) {
 			t.Fatalf("syntax tree mismatch in %q\ndiff:\n%s", in,
 				strings.Join(pretty.Diff(want, got), "\n"))
@@ -329,42 +614,6 @@ type errorCase struct {
 }
 
 var shellTests = []errorCase{
-	{
-		in:     "echo \x80",
-		common: `1:6: invalid UTF-8 encoding #NOERR common shells use bytes`,
-	},
-	{
-		in:     "\necho \x80",
-		common: `2:6: invalid UTF-8 encoding #NOERR common shells use bytes`,
-	},
-	{
-		in:     "echo foo\x80bar",
-		common: `1:9: invalid UTF-8 encoding #NOERR common shells use bytes`,
-	},
-	{
-		in:     "echo foo\xc3",
-		common: `1:9: invalid UTF-8 encoding #NOERR common shells use bytes`,
-	},
-	{
-		in:     "#foo\xc3",
-		common: `1:5: invalid UTF-8 encoding #NOERR common shells use bytes`,
-	},
-	{
-		in:     "echo a\x80",
-		common: `1:7: invalid UTF-8 encoding #NOERR common shells use bytes`,
-	},
-	{
-		in:     "<<$\xc8\n$\xc8",
-		common: `1:4: invalid UTF-8 encoding #NOERR common shells use bytes`,
-	},
-	{
-		in:     "echo $((foo\x80bar",
-		common: `1:12: invalid UTF-8 encoding`,
-	},
-	{
-		in:   "z=($\\\n#\\\n\\\n$#\x91\\\n",
-		bash: `4:3: invalid UTF-8 encoding`,
-	},
 	{
 		in:   `((# 1 + 2))`,
 		bash: `1:1: unsigned expressions are a mksh feature`,
@@ -408,30 +657,6 @@ var shellTests = []errorCase{
 		in:   `${|foo }`,
 		mksh: `1:1: reached EOF without matching ${ with }`,
 	},
-	{
-		in:     "((foo\x80bar",
-		common: `1:6: invalid UTF-8 encoding`,
-	},
-	{
-		in:     ";\x80",
-		common: `1:2: invalid UTF-8 encoding`,
-	},
-	{
-		in:     "${a\x80",
-		common: `1:4: invalid UTF-8 encoding`,
-	},
-	{
-		in:     "${a#\x80",
-		common: `1:5: invalid UTF-8 encoding`,
-	},
-	{
-		in:     "${a-'\x80",
-		common: `1:6: invalid UTF-8 encoding`,
-	},
-	{
-		in:     "echo $((a |\x80",
-		common: `1:12: invalid UTF-8 encoding`,
-	},
 	{
 		in:     "!",
 		common: `1:1: "!" cannot form a statement alone`,
@@ -972,10 +1197,6 @@ var shellTests = []errorCase{
 		in:     "echo $((a ; c))",
 		common: `1:11: not a valid arithmetic operator: ;`,
 	},
-	{
-		in:   "echo $((foo) )",
-		bsmk: `1:6: reached ) without matching $(( with )) #NOERR`,
-	},
 	{
 		in:     "echo $((a *))",
 		common: `1:11: * must be followed by an expression`,
@@ -1027,9 +1248,13 @@ var shellTests = []errorCase{
 		// common: `1:10: not a valid arithmetic operator: '`,
 	},
 	{
-		in:     "<<EOF\n$(()a",
+		in:     "<<EOF\n$(()",
 		common: `2:1: $(( must be followed by an expression`,
 	},
+	{
+		in:     "<<EOF\n$(()a",
+		common: `2:5: statements must be separated by &, ; or a newline`,
+	},
 	{
 		in:     "<<EOF\n`))",
 		common: `2:2: ) can only be used to close a subshell`,
@@ -1693,14 +1918,6 @@ var shellTests = []errorCase{
 		in:   "echo ${foo@'Q'}",
 		bash: `1:12: @ expansion operator requires a literal #NOERR at runtime`,
 	},
-	{
-		in:   `echo $((echo a); (echo b))`,
-		bsmk: `1:14: not a valid arithmetic operator: a #NOERR backtrack`,
-	},
-	{
-		in:   `((echo a); (echo b))`,
-		bsmk: `1:8: not a valid arithmetic operator: a #NOERR backtrack`,
-	},
 	{
 		in:   "for ((;;",
 		bash: `1:5: reached EOF without matching (( with ))`,
@@ -1781,11 +1998,11 @@ var shellTests = []errorCase{
 	},
 	{
 		in:    "echo ${foo/a/b}",
-		posix: `1:11: search and replace is a bash/mksh feature`,
+		posix: `1:11: search and replace is a bash/mksh/busybox feature`,
 	},
 	{
 		in:    "echo ${foo:1}",
-		posix: `1:11: slicing is a bash/mksh feature`,
+		posix: `1:11: slicing is a bash/mksh/busybox feature`,
 	},
 	{
 		in:    "echo ${foo,bar}",
@@ -1875,6 +2092,131 @@ func TestParseErrMirBSDKorn(t *testing.T) {
 	}
 }
 
+var strictPOSIXTests = []struct {
+	in, want string
+}{
+	{"[[ a ]]", `1:1: test clauses are a bash/mksh feature`},
+	{"foo; function f", `1:6: "function" declarations are a bash/mksh/busybox feature`},
+	{"let i++", `1:1: let clauses are a bash/mksh feature`},
+	{"declare -x foo", `1:1: "declare" clauses are a bash feature`},
+	{"typeset foo", `1:1: "typeset" clauses are a bash/mksh feature`},
+	{"select i in a", `1:1: select loops are a bash/mksh feature`},
+	{"coproc foo", `1:1: coprocesses are a bash feature`},
+	{"foo &>/dev/null", `1:5: &> redirects are a bash/mksh/busybox feature`},
+	{"echo $'foo'", `1:6: "$''" strings are a bash/mksh/busybox feature`},
+	{`echo "a" $"b"`, `1:10: "$\"\"" strings are a bash feature`},
+	{"a=(b c)", `1:3: arrays are a bash/mksh feature`},
+}
+
+func TestParseErrStrictPOSIX(t *testing.T) {
+	t.Parallel()
+	p := NewParser(Variant(LangPOSIX), StrictPOSIX(true))
+	for i, c := range strictPOSIXTests {
+		t.Run(fmt.Sprintf("%02d", i), checkError(p, c.in, c.want))
+	}
+	// Without StrictPOSIX, all but the last are valid POSIX Shell.
+	p = NewParser(Variant(LangPOSIX))
+	for _, c := range strictPOSIXTests[:len(strictPOSIXTests)-1] {
+		if _, err := p.Parse(strings.NewReader(c.in), ""); err != nil {
+			t.Fatalf("Unexpected error in %q: %v", c.in, err)
+		}
+	}
+	// StrictPOSIX has no effect on other language variants.
+	p = NewParser(StrictPOSIX(true))
+	if _, err := p.Parse(strings.NewReader("[[ a ]] &>/dev/null"), ""); err != nil {
+		t.Fatalf("Unexpected error with LangBash: %v", err)
+	}
+}
+
+var langErrTests = []struct {
+	lang  LangVariant
+	in    string
+	langs []LangVariant
+}{
+	{LangPOSIX, "a=(b)", []LangVariant{LangBash, LangMirBSDKorn}},
+	{LangPOSIX, "${a/b/c}", []LangVariant{LangBash, LangMirBSDKorn, LangBusyBox}},
+	{LangBusyBox, "foo {fd}>f", []LangVariant{LangBash}},
+	{LangMirBSDKorn, "for ((;;)); do foo; done", []LangVariant{LangBash}},
+	{LangBash, "${|foo;}", []LangVariant{LangMirBSDKorn}},
+	{LangBats, "case i { esac", []LangVariant{LangMirBSDKorn}},
+}
+
+func TestParseLangError(t *testing.T) {
+	t.Parallel()
+	for i, c := range langErrTests {
+		p := NewParser(Variant(c.lang))
+		_, err := p.Parse(strings.NewReader(c.in), "")
+		var langErr LangError
+		if !errors.As(err, &langErr) {
+			t.Fatalf("%02d: expected a LangError in %q, got: %v", i, c.in, err)
+		}
+		if !reflect.DeepEqual(langErr.Langs, c.langs) {
+			t.Fatalf("%02d: LangError.Langs mismatch in %q\nwant: %v\ngot:  %v",
+				i, c.in, c.langs, langErr.Langs)
+		}
+	}
+}
+
+// The first inputs are valid for common shells, as they use bytes. The rest
+// have other syntax errors.
+var requireUTF8Tests = []struct {
+	in, want string
+}{
+	{"echo \x80", "1:6: invalid UTF-8 encoding"},
+	{"\necho \x80", "2:6: invalid UTF-8 encoding"},
+	{"echo foo\x80bar", "1:9: invalid UTF-8 encoding"},
+	{"echo foo\xc3", "1:9: invalid UTF-8 encoding"},
+	{"#foo\xc3", "1:5: invalid UTF-8 encoding"},
+	{"echo a\x80", "1:7: invalid UTF-8 encoding"},
+	{"<<$\xc8\n$\xc8", "1:4: invalid UTF-8 encoding"},
+	{"echo $((foo\x80bar", "1:12: invalid UTF-8 encoding"},
+	{"z=($\\\n#\\\n\\\n$#\x91\\\n", "4:3: invalid UTF-8 encoding"},
+	{"((foo\x80bar", "1:6: invalid UTF-8 encoding"},
+	{";\x80", "1:2: invalid UTF-8 encoding"},
+	{"${a\x80", "1:4: invalid UTF-8 encoding"},
+	{"${a#\x80", "1:5: invalid UTF-8 encoding"},
+	{"${a-'\x80", "1:6: invalid UTF-8 encoding"},
+	{"echo $((a |\x80", "1:12: invalid UTF-8 encoding"},
+}
+
+func TestParseErrRequireUTF8(t *testing.T) {
+	t.Parallel()
+	p := NewParser(RequireUTF8(true))
+	for i, c := range requireUTF8Tests {
+		t.Run(fmt.Sprintf("%02d", i), checkError(p, c.in, c.want))
+	}
+}
+
+func TestParseInvalidUTF8(t *testing.T) {
+	t.Parallel()
+	p := NewParser(KeepComments(true))
+	printer := NewPrinter()
+	for i, in := range []string{
+		"echo \x80",
+		"echo foo\x80bar \xfe\xfd",
+		"echo foo\xc3",
+		"#foo\xc3",
+		"cat <<\xc8\nfoo\xe9\n\xc8",
+		"echo '\x80' \"\x80$\x80\" $'\x80'",
+		"echo ${a-\x80} ${a//\x80/\xe9}",
+		"f\xe9() { :; }",
+		"exit 0\n# \xe9t\xe9\x00\x01\xfe",
+	} {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(in), "")
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", in, err)
+			}
+			var buf bytes.Buffer
+			printer.Print(&buf, f)
+			if got := strings.TrimSuffix(buf.String(), "\n"); got != in {
+				t.Fatalf("Invalid UTF-8 bytes not kept in %q, got: %q",
+					in, got)
+			}
+		})
+	}
+}
+
 func TestInputName(t *testing.T) {
 	t.Parallel()
 	in := "("
@@ -1975,90 +2317,502 @@ func TestParseStmtsStopEarly(t *testing.T) {
 	}
 }
 
-func TestParseStmtsError(t *testing.T) {
-	t.Parallel()
-	in := "foo; )"
-	p := NewParser()
-	recv := make(chan bool, 10)
-	errc := make(chan error, 1)
-	go func() {
-		errc <- p.Stmts(strings.NewReader(in), func(s *Stmt) bool {
-			recv <- true
-			return true
-		})
-	}()
-	<-recv
-	if err := <-errc; err == nil {
-		t.Fatalf("Expected an error in %q, but got nil", in)
+// genReader generates an endless script, keeping count of the lines read.
+type genReader struct {
+	buf   []byte
+	lines int
+}
+
+func (r *genReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		r.lines++
+		r.buf = []byte(fmt.Sprintf("echo line %d | tr a-z A-Z\n", r.lines))
 	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
 }
 
-func TestParseWords(t *testing.T) {
+func TestParseStmtsIncremental(t *testing.T) {
 	t.Parallel()
 	p := NewParser()
-	inReader, inWriter := io.Pipe()
-	recv := make(chan bool, 10)
-	errc := make(chan error, 1)
-	go func() {
-		errc <- p.Words(inReader, func(w *Word) bool {
-			recv <- true
-			return true
-		})
-	}()
-	// TODO: Allow a single space to end parsing a word. At the moment, the
-	// parser must read the next non-space token (the next literal or
-	// newline, in this case) to finish parsing a word.
-	io.WriteString(inWriter, "foo ")
-	io.WriteString(inWriter, "bar\n")
-	<-recv
-	io.WriteString(inWriter, "baz etc")
-	inWriter.Close()
-	<-recv
-	<-recv
-	<-recv
-	if err := <-errc; err != nil {
+	r := &genReader{}
+	count := 0
+	err := p.Stmts(r, func(s *Stmt) bool {
+		count++
+		return count < 5000
+	})
+	if err != nil {
 		t.Fatalf("Expected no error: %v", err)
 	}
+	if count != 5000 {
+		t.Fatalf("Expected 5000 statements, got %d", count)
+	}
+	// The input is endless, so the parser must have consumed it
+	// incrementally. Allow for a buffered read ahead.
+	if ahead := r.lines - count; ahead > bufSize/10 {
+		t.Fatalf("Read %d lines past the last statement", ahead)
+	}
 }
 
-func TestParseWordsStopEarly(t *testing.T) {
+func TestParseContext(t *testing.T) {
 	t.Parallel()
-	p := NewParser()
-	r := strings.NewReader("a\nb\nc\n")
-	parsed := 0
-	err := p.Words(r, func(w *Word) bool {
-		parsed++
-		return w.Lit() != "b"
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewParser(Context(ctx))
+	count := 0
+	err := p.Stmts(&genReader{}, func(s *Stmt) bool {
+		if count++; count == 100 {
+			cancel()
+		}
+		return true
 	})
-	if err != nil {
-		t.Fatalf("Expected no error: %v", err)
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
 	}
-	if want := 2; parsed != want {
-		t.Fatalf("wanted %d words parsed, got %d", want, parsed)
+	if count > 100+bufSize/10 {
+		t.Fatalf("Parsed %d statements after the cancel", count-100)
+	}
+
+	// a context which is already done stops the parser before any reads
+	_, err = p.Parse(strings.NewReader("foo"), "")
+	if err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
 	}
 }
 
-func TestParseWordsError(t *testing.T) {
+func TestParseInteractive(t *testing.T) {
 	t.Parallel()
-	in := "foo )"
-	p := NewParser()
-	recv := make(chan bool, 10)
+	aliases := map[string]string{}
+	p := NewParser(ExpandAliases(aliases))
+	inReader, inWriter := io.Pipe()
+	recv := make(chan []*Stmt)
 	errc := make(chan error, 1)
 	go func() {
-		errc <- p.Words(strings.NewReader(in), func(w *Word) bool {
-			recv <- true
+		errc <- p.Interactive(inReader, func(stmts []*Stmt) bool {
+			if !p.Incomplete() {
+				// like the alias builtin, once the line is run
+				aliases["ll"] = "ls -l"
+			}
+			recv <- stmts
 			return true
 		})
 	}()
-	<-recv
-	want := "1:5: ) is not a valid word"
-	got := fmt.Sprintf("%v", <-errc)
-	if got != want {
-		t.Fatalf("Expected %q as an error, but got %q", want, got)
+	printed := func(stmts []*Stmt) string {
+		var sb strings.Builder
+		for _, s := range stmts {
+			NewPrinter().Print(&sb, s)
+			sb.WriteString("\n")
+		}
+		return sb.String()
+	}
+	for _, pair := range [...][2]string{
+		{"ll\n", "ll\n"},
+		{"ll foo\n", "ls -l foo\n"},
+		{"cat <<EOF\n", ""},
+		{"body\n", ""},
+		{"EOF\n", "cat <<EOF\nbody\nEOF\n"},
+	} {
+		io.WriteString(inWriter, pair[0])
+		if got := printed(<-recv); got != pair[1] {
+			t.Fatalf("Statements mismatch after %q:\nwant: %q\ngot:  %q",
+				pair[0], pair[1], got)
+		}
+	}
+	inWriter.Close()
+	if err := <-errc; err != nil {
+		t.Fatalf("Expected no error: %v", err)
 	}
 }
 
-var documentTests = []struct {
+func TestTokenPredicates(t *testing.T) {
+	t.Parallel()
+	in := "if [[ ! -f x && a < b ]]; then echo $((a<<2)) >out 2>&1; ((y*=3)); fi; echo '-f' \"for\""
+	var keywords, redirects, ariths, unaryTests []string
+	err := NewParser().Tokens(strings.NewReader(in), func(tok Token) bool {
+		if tok.IsKeyword() {
+			keywords = append(keywords, tok.String())
+		}
+		if tok.IsRedirect() {
+			redirects = append(redirects, tok.String())
+		}
+		if tok.IsBinaryArithm() {
+			ariths = append(ariths, tok.String())
+		}
+		if tok.IsUnaryTest() {
+			unaryTests = append(unaryTests, tok.String())
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		got, want []string
+	}{
+		{"keywords", keywords, []string{"if", "[[", "]]", "then", "fi"}},
+		{"redirects", redirects, []string{">", ">&"}},
+		{"arithmetic operators", ariths, []string{"<<", "*="}},
+		{"unary test operators", unaryTests, []string{"!", "-f"}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("Unexpected %s: want %q, got %q", tc.name, tc.want, tc.got)
+		}
+	}
+	for _, tc := range []struct {
+		in                   string
+		keywords, unaryTests []string
+	}{
+		{"echo if done then", nil, nil},
+		{"case x in in) in ;; esac", []string{"case", "in", "esac"}, nil},
+		{"if ! true; then :; elif false; then :; else :; fi", []string{"if", "!", "then", "elif", "then", "else", "fi"}, nil},
+		{"for i in a; do echo do; done", []string{"for", "in", "do", "done"}, nil},
+		{"[[ a == -f ]]", []string{"[[", "]]"}, nil},
+		{"[[ -f -f && ! -n x ]]", []string{"[[", "]]"}, []string{"-f", "!", "-n"}},
+		{"time -p foo", []string{"time"}, nil},
+	} {
+		var keywords, unaryTests []string
+		err := NewParser().Tokens(strings.NewReader(tc.in), func(tok Token) bool {
+			if tok.IsKeyword() {
+				keywords = append(keywords, tok.String())
+			}
+			if tok.IsUnaryTest() {
+				unaryTests = append(unaryTests, tok.String())
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		if !reflect.DeepEqual(keywords, tc.keywords) {
+			t.Errorf("Unexpected keywords in %q: want %q, got %q", tc.in, tc.keywords, keywords)
+		}
+		if !reflect.DeepEqual(unaryTests, tc.unaryTests) {
+			t.Errorf("Unexpected unary test operators in %q: want %q, got %q", tc.in, tc.unaryTests, unaryTests)
+		}
+	}
+	for word, want := range map[string]bool{"if": true, "{": true, "esac": true, "foo": false, "If": false, "": false} {
+		if got := IsKeyword(word); got != want {
+			t.Errorf("IsKeyword(%q): want %t, got %t", word, want, got)
+		}
+	}
+}
+
+func TestParseVariantAuto(t *testing.T) {
+	t.Parallel()
+	p := NewParser(Variant(LangAuto))
+	tests := []struct {
+		in   string
+		want LangVariant
+	}{
+		{"foo", LangBash},
+		{"#!/bin/sh\nfoo", LangPOSIX},
+		{"#!/bin/bash\nfoo", LangBash},
+		{"#!/usr/bin/env sh\nfoo", LangPOSIX},
+		{"#!/usr/bin/env -S bash -e\nfoo", LangBash},
+		{"#!/bin/dash\nfoo", LangPOSIX},
+		{"#!/bin/mksh\nfoo", LangMirBSDKorn},
+		{"#!/usr/bin/env bats\nfoo", LangBats},
+		{"#!/bin/busybox sh\nfoo", LangBusyBox},
+		{"#!/usr/bin/env busybox ash\nfoo", LangBusyBox},
+		{"#!/usr/bin/python\nfoo", LangBash},
+		{"foo\n#!/bin/sh", LangBash},
+		{"\ufeff#!/bin/sh\nfoo", LangPOSIX},
+		{"\ufeff#!/bin/mksh\nfoo", LangMirBSDKorn},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if f.Variant != tc.want {
+				t.Fatalf("Variant mismatch: want %s, got %s", tc.want, f.Variant)
+			}
+		})
+	}
+	_, err := p.Parse(strings.NewReader("#!/bin/sh\necho ${a/b/c}"), "")
+	if want := "2:9: search and replace is a bash/mksh/busybox feature"; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("Expected error %q, got %v", want, err)
+	}
+}
+
+func TestParseMaxNesting(t *testing.T) {
+	t.Parallel()
+	nested := func(left, mid, right string) func(int) string {
+		return func(n int) string {
+			return strings.Repeat(left, n) + mid + strings.Repeat(right, n)
+		}
+	}
+	tests := []func(int) string{
+		nested("(", "foo", ")"),
+		nested("{ ", "foo", "; }"),
+		nested("echo $(", "foo", ")"),
+		nested("echo ${a:-", "foo", "}"),
+		nested("((", "1", "))"),
+		nested("$((", "1", "))"),
+		nested("$(( (", "1", ") ))"),
+		func(n int) string { return "[[ " + nested("( ", "a", " )")(n) + " ]]" },
+		func(n int) string { return "[[ " + strings.Repeat("! ", n) + "a ]]" },
+		func(n int) string { return "echo $((" + strings.Repeat("- ", n) + "1))" },
+		func(n int) string { return "echo $((" + strings.Repeat("a=", n) + "1))" },
+		func(n int) string { return "echo $((" + strings.Repeat("1**", n) + "1))" },
+		func(n int) string { return "echo $((" + strings.Repeat("a?b:", n) + "c))" },
+		func(n int) string { return "echo $((" + strings.Repeat("a?", n) + "b" + strings.Repeat(":c", n) + "))" },
+		func(n int) string { return "[[ " + strings.Repeat("a && ", n) + "a ]]" },
+		func(n int) string { return strings.Repeat("a | ", n) + "a" },
+		func(n int) string { return strings.Repeat("a && ", n) + "a" },
+		func(n int) string { return strings.Repeat("time ", n) + "a" },
+		func(n int) string { return strings.Repeat("coproc ", n) + "a" },
+		func(n int) string { return strings.Repeat("f() ", n) + "a" },
+	}
+	for i, src := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			if _, err := NewParser().Parse(strings.NewReader(src(5000)), ""); err == nil ||
+				!strings.Contains(err.Error(), "nesting is deeper than 1000 levels") {
+				t.Fatalf("Expected a nesting error, got: %v", err)
+			}
+			if _, err := NewParser(MaxNesting(-1)).Parse(strings.NewReader(src(2000)), ""); err != nil {
+				t.Fatalf("Unexpected error with no limit: %v", err)
+			}
+			p := NewParser(MaxNesting(10))
+			if _, err := p.Parse(strings.NewReader(src(3)), ""); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := p.Parse(strings.NewReader(src(20)), ""); err == nil ||
+				!strings.Contains(err.Error(), "nesting is deeper than 10 levels") {
+				t.Fatalf("Expected a nesting error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		opt  ParserOption
+		in   string
+		want string // empty for no error
+	}{
+		{MaxBytes(8), "echo foo", ""},
+		{MaxBytes(8), "echo foo\n", "1:9: input is larger than 8 bytes"},
+		{MaxBytes(8), "echo foo bar", "1:9: input is larger than 8 bytes"},
+		{MaxStmts(2), "foo; bar", ""},
+		{MaxStmts(2), "foo; bar; baz", "1:11: input has more than 2 statements"},
+		{MaxStmts(2), "(foo; bar)", "1:7: input has more than 2 statements"},
+		{MaxWordParts(4), `echo $a$b$c$d "$a$b$c$d"`, ""},
+		{MaxWordParts(4), "echo $a$b$c$d$e", "1:14: word has more than 4 parts"},
+		{MaxWordParts(4), `echo "$a$b$c$d$e"`, "1:15: word has more than 4 parts"},
+		{MaxNesting(3), "$($(foo))", ""},
+		{MaxNesting(3), "$($($(foo)))", "1:7: nesting is deeper than 3 levels"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser(tc.opt, RecoverErrors(3))
+			_, err := p.Parse(iotest.OneByteReader(strings.NewReader(tc.in)), "")
+			if tc.want == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if _, ok := err.(LimitError); !ok {
+				t.Fatalf("Expected a LimitError, got %#v", err)
+			}
+			if got := err.Error(); got != tc.want {
+				t.Fatalf("Error mismatch:\nwant: %s\ngot:  %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"foo bar", []string{`1:1 literal "foo"`, `1:5 literal "bar"`}},
+		{
+			"foo; bar # x\n",
+			[]string{
+				`1:1 literal "foo"`, `1:4 operator ";"`,
+				`1:6 literal "bar"`, `1:10 comment "# x"`,
+				`1:13 newline "\n"`,
+			},
+		},
+		{
+			"echo 'a b' $y ${#z}",
+			[]string{
+				`1:1 literal "echo"`,
+				`1:6 operator "'"`, `1:7 literal "a b"`, `1:10 operator "'"`,
+				`1:12 operator "$"`, `1:13 literal "y"`,
+				`1:15 operator "${"`, `1:17 operator "#"`,
+				`1:18 literal "z"`, `1:19 operator "}"`,
+			},
+		},
+		{
+			"((x+1))",
+			[]string{
+				`1:1 operator "(("`, `1:3 literal "x"`, `1:4 operator "+"`,
+				`1:5 literal "1"`, `1:6 operator ")"`, `1:7 operator ")"`,
+			},
+		},
+		{
+			"cat <<'EOF'\nx\nEOF\n",
+			[]string{
+				`1:1 literal "cat"`, `1:5 operator "<<"`,
+				`1:7 operator "'"`, `1:8 literal "EOF"`, `1:11 operator "'"`,
+				`1:12 newline "\n"`, `2:1 literal "x\n"`,
+			},
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			var got []string
+			err := NewParser().Tokens(strings.NewReader(tc.in), func(tok Token) bool {
+				got = append(got, fmt.Sprintf("%s %s %q", tok.Pos, tok.Kind, tok.Value))
+				return true
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Tokens mismatch:\nwant: %q\ngot:  %q", tc.want, got)
+			}
+		})
+	}
+
+	t.Run("Stop", func(t *testing.T) {
+		var got []string
+		err := NewParser().Tokens(strings.NewReader("foo; bar; ("), func(tok Token) bool {
+			got = append(got, tok.Value)
+			return len(got) < 2
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if want := []string{"foo", ";"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Tokens mismatch:\nwant: %q\ngot:  %q", want, got)
+		}
+	})
+
+	t.Run("Recover", func(t *testing.T) {
+		in := "foo )\nif\nbar )\nbaz"
+		for _, p := range []*Parser{NewParser(), NewParser(RecoverErrors(3))} {
+			var got []string
+			err := p.Tokens(strings.NewReader(in), func(tok Token) bool {
+				got = append(got, tok.Value)
+				return true
+			})
+			if _, ok := err.(ErrorList); !ok {
+				t.Fatalf("Expected an ErrorList, got %#v", err)
+			}
+			if want := []string{"foo", ")", "\n", "if", "\n", "bar", ")", "\n", "baz"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("Tokens mismatch:\nwant: %q\ngot:  %q", want, got)
+			}
+		}
+
+		// a maximum set via RecoverErrors is still followed
+		var got []string
+		err := NewParser(RecoverErrors(1)).Tokens(strings.NewReader(in), func(tok Token) bool {
+			got = append(got, tok.Value)
+			return true
+		})
+		if _, ok := err.(ParseError); !ok {
+			t.Fatalf("Expected a ParseError, got %#v", err)
+		}
+		if want := []string{"foo", ")"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Tokens mismatch:\nwant: %q\ngot:  %q", want, got)
+		}
+	})
+}
+
+func TestParseStmtsError(t *testing.T) {
+	t.Parallel()
+	in := "foo; )"
+	p := NewParser()
+	recv := make(chan bool, 10)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Stmts(strings.NewReader(in), func(s *Stmt) bool {
+			recv <- true
+			return true
+		})
+	}()
+	<-recv
+	if err := <-errc; err == nil {
+		t.Fatalf("Expected an error in %q, but got nil", in)
+	}
+}
+
+func TestParseWords(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	inReader, inWriter := io.Pipe()
+	recv := make(chan bool, 10)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Words(inReader, func(w *Word) bool {
+			recv <- true
+			return true
+		})
+	}()
+	// TODO: Allow a single space to end parsing a word. At the moment, the
+	// parser must read the next non-space token (the next literal or
+	// newline, in this case) to finish parsing a word.
+	io.WriteString(inWriter, "foo ")
+	io.WriteString(inWriter, "bar\n")
+	<-recv
+	io.WriteString(inWriter, "baz etc")
+	inWriter.Close()
+	<-recv
+	<-recv
+	<-recv
+	if err := <-errc; err != nil {
+		t.Fatalf("Expected no error: %v", err)
+	}
+}
+
+func TestParseWordsStopEarly(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	r := strings.NewReader("a\nb\nc\n")
+	parsed := 0
+	err := p.Words(r, func(w *Word) bool {
+		parsed++
+		return w.Lit() != "b"
+	})
+	if err != nil {
+		t.Fatalf("Expected no error: %v", err)
+	}
+	if want := 2; parsed != want {
+		t.Fatalf("wanted %d words parsed, got %d", want, parsed)
+	}
+}
+
+func TestParseWordsError(t *testing.T) {
+	t.Parallel()
+	in := "foo )"
+	p := NewParser()
+	recv := make(chan bool, 10)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.Words(strings.NewReader(in), func(w *Word) bool {
+			recv <- true
+			return true
+		})
+	}()
+	<-recv
+	want := "1:5: ) is not a valid word"
+	got := fmt.Sprintf("%v", <-errc)
+	if got != want {
+		t.Fatalf("Expected %q as an error, but got %q", want, got)
+	}
+}
+
+var documentTests = []struct {
 	in   string
 	want []WordPart
 }{
@@ -2210,6 +2964,56 @@ func TestParseArithmeticError(t *testing.T) {
 	}
 }
 
+func TestParseSingle(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		kind, in string
+		want     string // printed node, or error
+	}{
+		{"word", `foo"$bar"`, `foo"$bar"`},
+		{"word", "\n${a:-b}\n", "${a:-b}"},
+		{"word", "foo bar", "1:5: expected a single word"},
+		{"word", "foo;", "1:4: ; is not a valid word"},
+		{"word", "", "expected a single word; found none"},
+		{"arithm", "x = y + 1", "x = y + 1"},
+		{"arithm", "1 )", "1:3: not a valid arithmetic operator: )"},
+		{"arithm", "1 2", "1:3: not a valid arithmetic operator: 2"},
+		{"arithm", " ", "expected an arithmetic expression; found none"},
+		{"param", "$foo", "$foo"},
+		{"param", "${foo[@]/a/b}", "${foo[@]/a/b}"},
+		{"param", "foo", "1:1: not a parameter expansion"},
+		{"param", `"$foo"`, "1:1: not a parameter expansion"},
+		{"param", "${foo}.txt", "1:7: expected a single parameter expansion"},
+		{"param", "${foo", "1:1: reached EOF without matching ${ with }"},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			var node Node
+			var err error
+			switch tc.kind {
+			case "word":
+				node, err = ParseWord(tc.in)
+			case "arithm":
+				node, err = ParseArithmExpr(tc.in)
+			case "param":
+				node, err = ParseParamExp(tc.in)
+			}
+			var got string
+			if err != nil {
+				got = err.Error()
+			} else {
+				var sb strings.Builder
+				NewPrinter().Print(&sb, node)
+				got = sb.String()
+			}
+			if got != tc.want {
+				t.Fatalf("%s mismatch in %q:\nwant: %q\ngot:  %q",
+					tc.kind, tc.in, tc.want, got)
+			}
+		})
+	}
+}
+
 var stopAtTests = []struct {
 	in   string
 	stop string
@@ -2262,6 +3066,768 @@ func TestParseStmtsStopAt(t *testing.T) {
 	}
 }
 
+func TestParseStoppedAt(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, stop string
+		want     int // offset of the stop word, or -1
+	}{
+		{"foo bar", "$$", -1},
+		{"foo $$ bar", "$$", 4},
+		{"foo;$$", "$$", 4},
+		{"foo '$$'", "$$", -1},
+		{"foo\n\tbar }}\n}}", "}}", 9},
+		{"echo ${a} %>", "%>", 10},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser(StopAt(tc.stop))
+			// read a byte at a time, so that the stop word is split
+			r := iotest.OneByteReader(strings.NewReader(tc.in))
+			if _, err := p.Parse(r, ""); err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+			pos := p.StoppedAt()
+			got := -1
+			if pos.IsValid() {
+				got = int(pos.Offset())
+			}
+			if got != tc.want {
+				t.Fatalf("StoppedAt mismatch in %q: want %d, got %d",
+					tc.in, tc.want, got)
+			}
+			if got >= 0 && !strings.HasPrefix(tc.in[got:], tc.stop) {
+				t.Fatalf("StoppedAt in %q is not at %q", tc.in, tc.stop)
+			}
+		})
+	}
+}
+
+var aliasTests = []struct {
+	in, want string
+}{
+	{"ll", "ls -l"},
+	{"ll foo; echo ll", "ls -l foo\necho ll"},
+	{"\"ll\" foo; \\ll", "\"ll\" foo\n\\ll"},
+	{"a=b ll", "a=b ls -l"},
+	{"ll | ll\nll && ll", "ls -l | ls -l\nls -l && ls -l"},
+	{"echo $(ll) `ll`", "echo $(ls -l) $(ll)"},
+	{"sudo ll", "sudo ls -l"},
+	{"sudo sudo ll ll", "sudo sudo ls -l ll"},
+	{"loop", "loop x"},
+	{"first", "third 3 2 1"},
+	{"pipe bar", "foo | bar"},
+	{"cond echo; fi", "if true; then echo; fi"},
+	{"empty echo", "echo"},
+	{"twice; ll", "echo one\necho two\nls -l"},
+	{"ll <<EOF\nll\nEOF\nll", "ls -l <<EOF\nll\nEOF\nls -l"},
+}
+
+func TestParseAliases(t *testing.T) {
+	t.Parallel()
+	p := NewParser(ExpandAliases(map[string]string{
+		"ll":     "ls -l",
+		"sudo":   "sudo ",
+		"loop":   "loop x",
+		"first":  "second 1",
+		"second": "third 2",
+		"third":  "third 3",
+		"pipe":   "foo |",
+		"cond":   "if true; then",
+		"empty":  "",
+		"twice":  "echo one\necho two",
+	}))
+	printer := NewPrinter()
+	for i, c := range aliasTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(c.in), "")
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", c.in, err)
+			}
+			var buf bytes.Buffer
+			printer.Print(&buf, f)
+			if got := strings.TrimSuffix(buf.String(), "\n"); got != c.want {
+				t.Fatalf("Alias expansion mismatch in %q:\nwant: %q\ngot:  %q",
+					c.in, c.want, got)
+			}
+		})
+	}
+	t.Run("Positions", func(t *testing.T) {
+		in := "ll foo\nbar"
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := f.Stmts[0].Cmd.(*CallExpr).Args
+		for _, word := range args[:2] {
+			if pos := word.Pos(); pos.Offset() != 0 {
+				t.Fatalf("want %q to be at offset 0, got %s", word.Lit(), pos)
+			}
+		}
+		if pos := args[2].Pos(); pos.Offset() != 3 || pos.Col() != 4 {
+			t.Fatalf("want foo to be at 1:4, got %s", pos)
+		}
+		if pos := f.Stmts[1].Pos(); pos.Offset() != 7 || pos.Line() != 2 {
+			t.Fatalf("want bar to be at 2:1, got %s", pos)
+		}
+	})
+}
+
+var crlfTests = []struct {
+	in, want string
+}{
+	{"foo\r\nbar\r\n", "foo\nbar"},
+	{"foo \\\r\n\tbar", "foo \\\n\tbar"},
+	{"cat <<EOF\r\nfoo\r\nEOF\r\nbar", "cat <<EOF\nfoo\nEOF\nbar"},
+	{"cat <<'EOF'\r\n$foo\r\nEOF\r\n", "cat <<'EOF'\n$foo\nEOF"},
+	{"echo 'a\r\nb' \"c\r\nd\"", "echo 'a\nb' \"c\nd\""},
+	{"if true; then # foo\r\n\tbar\r\nfi", "if true; then # foo\n\tbar\nfi"},
+	{"foo\rbar", "foo bar"},
+}
+
+func TestParseHistoryExpansion(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"sudo !!", `1:6: "!" would start a history expansion`},
+		{"!-2", `1:1: "!" would start a history expansion`},
+		{"echo !$", `1:6: "!" would start a history expansion`},
+		{"a=!x", `1:3: "!" would start a history expansion`},
+		{`echo "a!b"`, `1:8: "!" would start a history expansion`},
+		{`echo "it's !x"`, `1:12: "!" would start a history expansion`},
+		{"echo 'a\n!b'\n!c", `3:1: "!" would start a history expansion`},
+		{"echo a # don't\n!b", `2:1: "!" would start a history expansion`},
+		{`echo "hello!"`, ""},
+		{"echo hello!", ""},
+		{"! true", ""},
+		{"[[ ! -f x ]]", ""},
+		{"x!=y", ""},
+		{"echo !(a)", ""},
+		{"echo $! ${!a} [!a]*", ""},
+		{`echo 'a!b' a\!b "a\!b"`, ""},
+		{"echo 'a\n!b'", ""},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser(HistoryExpansion(true))
+			_, err := p.Parse(strings.NewReader(tc.in), "")
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tc.want {
+				t.Fatalf("Error mismatch in %q:\nwant: %q\ngot:  %q",
+					tc.in, tc.want, got)
+			}
+			// the default non-interactive mode never errors on "!"
+			if _, err := NewParser().Parse(strings.NewReader(tc.in), ""); err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+		})
+	}
+}
+
+func TestParseErrorFields(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in       string
+		token    string
+		expected []string
+	}{
+		{"echo )", ")", nil},
+		{"foo &&", "", []string{"a statement"}},
+		{"if foo; then bar", "", []string{"fi"}},
+		{"if foo; bar", "", []string{"then"}},
+		{"for i foo; do bar; done", "foo", []string{"in", "do", ";", "\n"}},
+		{"echo 'foo", "", []string{"'"}},
+		{"echo ${foo", "", []string{"}"}},
+		{"[[ a", "", []string{"]]"}},
+		{"foo; ;", ";", nil},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			_, err := NewParser().Parse(strings.NewReader(tc.in), "")
+			perr, ok := err.(ParseError)
+			if !ok {
+				t.Fatalf("Expected a ParseError in %q, got %#v", tc.in, err)
+			}
+			if perr.Token != tc.token {
+				t.Fatalf("Token mismatch in %q:\nwant: %q\ngot:  %q",
+					tc.in, tc.token, perr.Token)
+			}
+			if got := perr.Expected(); !reflect.DeepEqual(got, tc.expected) {
+				t.Fatalf("Expected mismatch in %q:\nwant: %q\ngot:  %q",
+					tc.in, tc.expected, got)
+			}
+			// ParseError must stay comparable, as with ==
+			if err2 := error(perr); err2 != err {
+				t.Fatalf("ParseError is not equal to itself")
+			}
+		})
+	}
+}
+
+func TestParseErrorSnippet(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		opts []ParserOption
+		want string
+	}{
+		{
+			"echo )",
+			nil,
+			"1:6: a command can only contain words and redirects; encountered )\n" +
+				"echo )\n" +
+				"     ^",
+		},
+		{
+			"foo\n\tif\tbar; }\nbaz",
+			nil,
+			`2:10: "}" can only be used to close a block` + "\n" +
+				"\tif\tbar; }\n" +
+				"\t  \t     ^",
+		},
+		{
+			"echo \"héllo\" )",
+			nil,
+			"1:15: a command can only contain words and redirects; encountered )\n" +
+				"echo \"héllo\" )\n" +
+				"             ^",
+		},
+		{
+			"foo\r\necho )\r\n",
+			nil,
+			"2:6: a command can only contain words and redirects; encountered )\n" +
+				"echo )\n" +
+				"     ^",
+		},
+		{
+			"foo\necho ${a:1}",
+			[]ParserOption{Variant(LangPOSIX)},
+			"2:9: slicing is a bash/mksh/busybox feature\n" +
+				"echo ${a:1}\n" +
+				"        ^",
+		},
+		{
+			"echo )\nfoo; ;",
+			[]ParserOption{RecoverErrors(5)},
+			"1:6: a command can only contain words and redirects; encountered )\n" +
+				"echo )\n" +
+				"     ^\n" +
+				"2:6: ; can only immediately follow a statement\n" +
+				"foo; ;\n" +
+				"     ^",
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			_, err := NewParser(tc.opts...).Parse(strings.NewReader(tc.in), "")
+			snippeter, ok := err.(interface{ Snippet([]byte) string })
+			if !ok {
+				t.Fatalf("Unexpected error in %q: %#v", tc.in, err)
+			}
+			if got := snippeter.Snippet([]byte(tc.in)); got != tc.want {
+				t.Fatalf("Snippet mismatch in %q:\nwant:\n%s\ngot:\n%s",
+					tc.in, tc.want, got)
+			}
+		})
+	}
+	// a position outside of the source only gives the error
+	err := ParseError{Pos: Pos{offs: 20, line: 2, col: 3}, Text: "foo"}
+	if got, want := err.Snippet([]byte("short")), "2:3: foo"; got != want {
+		t.Fatalf("Snippet mismatch:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestParseRecoverErrors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string // printed statements, or the errors of BadStmts
+	}{
+		{"foo\nbar", []string{"foo", "bar"}},
+		{"foo\necho )\nbar", []string{
+			"foo",
+			"2:1-2:7 2:6: a command can only contain words and redirects; encountered )",
+			"bar",
+		}},
+		{"a; b )\nc", []string{
+			"a",
+			"1:4-1:7 1:6: a command can only contain words and redirects; encountered )",
+			"c",
+		}},
+		{"foo; ;\nbar", []string{
+			"foo",
+			"1:6-1:7 1:6: ; can only immediately follow a statement",
+			"bar",
+		}},
+		{"if true; then\n\techo )\nfi\nlast", []string{
+			"1:1-2:8 2:7: a command can only contain words and redirects; encountered )",
+			`3:1-3:3 3:1: "fi" can only be used to end an if`,
+			"last",
+		}},
+		{"# c\necho ) # d\nok", []string{
+			"2:1-2:11 2:6: a command can only contain words and redirects; encountered )",
+			"ok",
+		}},
+		{"echo 'foo\nbar", []string{
+			"1:1-2:4 1:6: reached EOF without closing quote '",
+		}},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			p := NewParser(RecoverErrors(10), KeepComments(true))
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			var got []string
+			var errs ErrorList
+			for _, s := range f.Stmts {
+				if b, ok := s.Cmd.(*BadStmt); ok {
+					got = append(got, fmt.Sprintf("%s-%s %v", b.Pos(), b.End(), b.Err))
+					errs = append(errs, b.Err)
+					continue
+				}
+				var sb strings.Builder
+				NewPrinter().Print(&sb, s)
+				got = append(got, strings.TrimSuffix(sb.String(), "\n"))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Statements mismatch in %q:\nwant: %q\ngot:  %q",
+					tc.in, tc.want, got)
+			}
+			var wantErr error
+			switch len(errs) {
+			case 0:
+			case 1:
+				wantErr = errs[0]
+			default:
+				wantErr = errs
+			}
+			if !reflect.DeepEqual(err, wantErr) {
+				t.Fatalf("Expected the error %v, got %v", wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseRecoverErrorsMaximum(t *testing.T) {
+	t.Parallel()
+	in := "a )\nb )\nc )\nd"
+	p := NewParser(RecoverErrors(2))
+	f, err := p.Parse(strings.NewReader(in), "")
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 2 {
+		t.Fatalf("Expected an ErrorList with 2 errors, got %#v", err)
+	}
+	want := "1:3: a command can only contain words and redirects; encountered ) (and 1 more error)"
+	if got := err.Error(); got != want {
+		t.Fatalf("Error mismatch:\nwant: %q\ngot:  %q", want, got)
+	}
+	// the second error stops the parser
+	if len(f.Stmts) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(f.Stmts))
+	}
+
+	// without recovery, or with a maximum of one error, the first error
+	// stops the parser
+	for _, p := range []*Parser{NewParser(), NewParser(RecoverErrors(1))} {
+		_, err = p.Parse(strings.NewReader(in), "")
+		if _, ok := err.(ParseError); !ok {
+			t.Fatalf("Expected a ParseError, got %#v", err)
+		}
+	}
+
+	// the last error tells if the input is incomplete
+	_, err = p.Parse(strings.NewReader("a )\nb 'incomp"), "")
+	if !IsIncomplete(err) {
+		t.Fatalf("Expected an incomplete error, got %v", err)
+	}
+}
+
+func TestReparse(t *testing.T) {
+	t.Parallel()
+	in := "x=1\ntrap 'rm -f \"$tmp\"\n\techo done' EXIT\neval \"foo; bar\"\neval echo\n"
+	p := NewParser()
+	f, err := p.Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, stmt := range f.Stmts {
+		call, ok := stmt.Cmd.(*CallExpr)
+		if !ok {
+			continue
+		}
+		for _, w := range call.ScriptArgs() {
+			f2, err := p.Reparse(w, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			Walk(f2, func(node Node) bool {
+				if call, ok := node.(*CallExpr); ok {
+					// each position should point to the original source
+					pos, end := call.Pos(), call.End()
+					got = append(got, fmt.Sprintf("%s-%s %s", pos, end,
+						in[pos.Offset():end.Offset()]))
+				}
+				return true
+			})
+		}
+	}
+	want := []string{
+		`2:7-2:19 rm -f "$tmp"`,
+		`3:2-3:11 echo done`,
+		`4:7-4:10 foo`,
+		`4:12-4:15 bar`,
+		`5:6-5:10 echo`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Reparse positions mismatch:\nwant: %q\ngot:  %q", want, got)
+	}
+
+	errTests := []struct {
+		in, want string
+	}{
+		{"eval 'foo )'", `1:11: a command can only contain words and redirects; encountered )`},
+		{`eval "$x"`, "cannot reparse a word which isn't a static string"},
+		{`eval 'a'"b"`, "cannot reparse a word which isn't a static string"},
+		{`eval a\ b`, "cannot reparse a word which isn't a static string"},
+		{`eval $'a\nb'`, "cannot reparse a word which isn't a static string"},
+	}
+	for _, tc := range errTests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := f.Stmts[0].Cmd.(*CallExpr).Args[1]
+		_, err = p.Reparse(w, "")
+		if err == nil || err.Error() != tc.want {
+			t.Fatalf("Reparse error mismatch in %q:\nwant: %s\ngot:  %v", tc.in, tc.want, err)
+		}
+	}
+}
+
+func TestLetExprs(t *testing.T) {
+	t.Parallel()
+	in := "let i++ \"x = y + 1\" 'z=(1 +\n2)' \"x = $y\""
+	p := NewParser()
+	f, err := p.Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprs, err := p.LetExprs(f.Stmts[0].Cmd.(*LetClause))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, expr := range exprs {
+		// each position should point to the original source
+		pos, end := expr.Pos(), expr.End()
+		got = append(got, fmt.Sprintf("%T %s-%s %s", expr, pos, end,
+			in[pos.Offset():end.Offset()]))
+	}
+	want := []string{
+		"*syntax.UnaryArithm 1:5-1:8 i++",
+		"*syntax.BinaryArithm 1:10-1:19 x = y + 1",
+		"*syntax.BinaryArithm 1:22-2:3 z=(1 +\n2)",
+		`*syntax.Word 2:5-2:13 "x = $y"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("LetExprs mismatch:\nwant: %q\ngot:  %q", want, got)
+	}
+
+	errTests := []struct {
+		in, want string
+	}{
+		{`let "x +"`, `1:8: + must be followed by an expression`},
+		{`let "x y"`, `1:8: not a valid arithmetic operator: y`},
+		{`let ""`, `1:6: expected an arithmetic expression; found none`},
+	}
+	for _, tc := range errTests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.LetExprs(f.Stmts[0].Cmd.(*LetClause))
+		if err == nil || err.Error() != tc.want {
+			t.Fatalf("LetExprs error mismatch in %q:\nwant: %s\ngot:  %v", tc.in, tc.want, err)
+		}
+	}
+}
+
+func TestParseArithmLookahead(t *testing.T) {
+	t.Parallel()
+	// longer than the parser's read buffer, to look ahead past it
+	long := strings.Repeat("x", 3000)
+	tests := []struct {
+		in     string
+		arithm bool
+	}{
+		{"echo $((echo " + long + "); (echo y))", false},
+		{"echo \"$((echo " + long + ") | cat)\"", false},
+		{"((echo " + long + "); (echo y))", false},
+		{"echo $((" + long + " + (" + long + ")))", true},
+		{"((" + long + "))", true},
+		{"echo $((" + long + " + ')'))", true},
+	}
+	p := NewParser()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			for _, r := range []io.Reader{
+				strings.NewReader(tc.in),
+				iotest.OneByteReader(strings.NewReader(tc.in)),
+			} {
+				f, err := p.Parse(r, "")
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if end := f.End().Offset(); end != uint(len(tc.in)) {
+					t.Fatalf("want file to end at offset %d, got %d", len(tc.in), end)
+				}
+				arithm := false
+				Walk(f, func(node Node) bool {
+					switch node.(type) {
+					case *ArithmExp, *ArithmCmd:
+						arithm = true
+					}
+					return true
+				})
+				if arithm != tc.arithm {
+					t.Fatalf("want arithmetic %t, got %t", tc.arithm, arithm)
+				}
+			}
+		})
+	}
+
+	// the lookahead is limited; past that, arithmetic is assumed
+	huge := strings.Repeat("x", maxPeekBuf)
+	_, err := p.Parse(strings.NewReader("echo $((echo "+huge+"); (echo y))"), "")
+	if want := "1:14: not a valid arithmetic operator"; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("Expected error %q", want)
+	}
+	if len(p.readBuf) > maxPeekBuf {
+		t.Fatalf("Read buffer grew to %d bytes", len(p.readBuf))
+	}
+}
+
+func TestParseCRLF(t *testing.T) {
+	t.Parallel()
+	p := NewParser(TolerateCRLF(true), KeepComments(true))
+	printer := NewPrinter()
+	for i, c := range crlfTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			// a reader of one byte at a time exercises "\r\n"
+			// being split between reads
+			for _, r := range []io.Reader{
+				strings.NewReader(c.in),
+				iotest.OneByteReader(strings.NewReader(c.in)),
+			} {
+				f, err := p.Parse(r, "")
+				if err != nil {
+					t.Fatalf("Unexpected error in %q: %v", c.in, err)
+				}
+				var buf bytes.Buffer
+				printer.Print(&buf, f)
+				if got := strings.TrimSuffix(buf.String(), "\n"); got != c.want {
+					t.Fatalf("CRLF mismatch in %q:\nwant: %q\ngot:  %q",
+						c.in, c.want, got)
+				}
+			}
+		})
+	}
+	t.Run("Positions", func(t *testing.T) {
+		in := "foo\r\nbar \\\r\nbaz"
+		f, err := p.Parse(strings.NewReader(in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := f.Stmts[1].Cmd.(*CallExpr).Args
+		if pos := args[0].Pos(); pos.Offset() != 5 || pos.Line() != 2 || pos.Col() != 1 {
+			t.Fatalf("want bar to be at 2:1, got %s", pos)
+		}
+		if pos := args[1].Pos(); pos.Offset() != 12 || pos.Line() != 3 || pos.Col() != 1 {
+			t.Fatalf("want baz to be at 3:1, got %s", pos)
+		}
+	})
+	t.Run("Ends", func(t *testing.T) {
+		// the nodes before a "\r\n" end where the "\r" is
+		for _, tc := range []struct {
+			in   string
+			want []uint
+		}{
+			{"echo foo\r\n", []uint{4, 8}},
+			{"echo $x\r\n", []uint{4, 7}},
+			{"echo \"x\"\r\nfoo", []uint{4, 8, 13}},
+		} {
+			f, err := NewParser(TolerateCRLF(true), KeepSource(true)).Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint
+			for _, s := range f.Stmts {
+				for _, w := range s.Cmd.(*CallExpr).Args {
+					got = append(got, w.End().Offset())
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Unexpected word ends in %q: want %v, got %v", tc.in, tc.want, got)
+			}
+			if src, want := f.Source(f.Stmts[0]), strings.TrimRight(tc.in[:tc.want[1]], "\r"); src != want {
+				t.Fatalf("Unexpected source in %q: want %q, got %q", tc.in, want, src)
+			}
+		}
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		in := "cat <<EOF\r\nfoo\r\nEOF\r\n"
+		_, err := NewParser().Parse(strings.NewReader(in), "")
+		if err == nil {
+			t.Fatalf("Expected error in %q", in)
+		}
+	})
+}
+
+func TestParseLineContinuations(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		opts []ParserOption
+	}{
+		{"echo foo\\\nbar", nil},
+		{"echo foo\\\r\nbar", []ParserOption{TolerateCRLF(true)}},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := NewParser(tc.opts...).Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts := f.Stmts[0].Cmd.(*CallExpr).Args[1].Parts
+			if len(parts) != 2 {
+				t.Fatalf("want foo\\<newline>bar to be two parts, got %d", len(parts))
+			}
+			foo, bar := parts[0].(*Lit), parts[1].(*Lit)
+			if foo.Value != "foo" || bar.Value != "bar" {
+				t.Fatalf("want foo and bar, got %q and %q", foo.Value, bar.Value)
+			}
+			if pos, end := foo.Pos(), foo.End(); pos.String() != "1:6" ||
+				end.String() != "1:9" || end.Offset() != 8 {
+				t.Fatalf("want foo to span 1:6 to 1:9, got %s to %s", pos, end)
+			}
+			wantOffs := uint(len(tc.in) - len("bar"))
+			if pos, end := bar.Pos(), bar.End(); pos.String() != "2:1" ||
+				end.String() != "2:4" || pos.Offset() != wantOffs {
+				t.Fatalf("want bar to span 2:1 to 2:4, got %s to %s", pos, end)
+			}
+		})
+	}
+}
+
+func TestParseKeywordPositions(t *testing.T) {
+	t.Parallel()
+	in := `if a; then
+	b
+elif c; then
+	d
+else
+	e
+fi
+while f; do g; done
+until h
+do i
+done
+for j in k l; do m; done
+for ((;;)) { n; }
+case o in
+p) q ;;
+esac
+`
+	f, err := NewParser().Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	add := func(pos Pos) {
+		if !pos.IsValid() {
+			t.Fatalf("invalid keyword position")
+		}
+		rest := in[pos.Offset():]
+		got = append(got, rest[:strings.IndexAny(rest, " \n;(")])
+	}
+	Walk(f, func(node Node) bool {
+		switch x := node.(type) {
+		case *IfClause:
+			add(x.Position)
+			if x.ThenPos.IsValid() {
+				add(x.ThenPos)
+			}
+			if x.Else == nil {
+				add(x.FiPos)
+			}
+		case *WhileClause:
+			add(x.WhilePos)
+			add(x.DoPos)
+			add(x.DonePos)
+		case *ForClause:
+			add(x.ForPos)
+			add(x.DoPos)
+			add(x.DonePos)
+		case *WordIter:
+			add(x.InPos)
+		case *CaseClause:
+			add(x.Case)
+			add(x.In)
+			add(x.Esac)
+		}
+		return true
+	})
+	want := []string{
+		"if", "then", "elif", "then", "else", "fi",
+		"while", "do", "done",
+		"until", "do", "done",
+		"for", "do", "done", "in",
+		"for", "{", "}",
+		"case", "in", "esac",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("keyword positions mismatch\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestParseWordIterIn(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in    string
+		inPos bool
+	}{
+		{"for i; do foo; done", false},
+		{"for i do foo; done", false},
+		{"for i\ndo foo; done", false},
+		{"for i in; do foo; done", true},
+		{"for i in a b; do foo; done", true},
+		{"for i #c\n\tin a; do foo; done", true},
+	}
+	p := NewParser()
+	for _, tc := range tests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatalf("%q: %v", tc.in, err)
+		}
+		wi := f.Stmts[0].Cmd.(*ForClause).Loop.(*WordIter)
+		if got := wi.InPos.IsValid(); got != tc.inPos {
+			t.Fatalf("%q: InPos.IsValid() got %v, want %v", tc.in, got, tc.inPos)
+		}
+		var buf bytes.Buffer
+		if err := NewPrinter().Print(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(buf.String(), " in"); got != tc.inPos {
+			t.Fatalf("%q: printed %q, want \"in\" %v", tc.in, buf.String(), tc.inPos)
+		}
+	}
+}
+
 func TestValidName(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -2287,6 +3853,47 @@ func TestValidName(t *testing.T) {
 	}
 }
 
+func TestArithmInt(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"Decimal", "123", 123, false},
+		{"Zero", "0", 0, false},
+		{"Hex", "0xff", 255, false},
+		{"HexUpper", "0XFF", 255, false},
+		{"Octal", "010", 8, false},
+		{"OctalBadDigit", "09", 0, true},
+		{"Binary", "2#1011", 11, false},
+		{"Base36", "36#Zz", 35*36 + 35, false},
+		{"Base64Lower", "64#z", 35, false},
+		{"Base64Upper", "64#Z", 61, false},
+		{"Base64Symbols", "64#@_", 62*64 + 63, false},
+		{"BaseTooSmall", "1#0", 0, true},
+		{"BaseTooLarge", "65#0", 0, true},
+		{"EmptyBase", "#1", 0, true},
+		{"EmptyValue", "16#", 0, true},
+		{"DigitTooLarge", "2#102", 0, true},
+		{"NotNumber", "foo", 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ArithmInt(tc.in)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("ArithmInt(%q) got error %v, wanted error: %t",
+					tc.in, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Fatalf("ArithmInt(%q) got %d, wanted %d",
+					tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
 func TestIsIncomplete(t *testing.T) {
 	t.Parallel()
 
@@ -2301,6 +3908,19 @@ func TestIsIncomplete(t *testing.T) {
 		{"foo; 'incomp", true},
 		{" (incomp", true},
 		{"badsyntax)", false},
+		{"if true; then", true},
+		{"if true; then\n", true},
+		{"while x; do", true},
+		{"case x in a) b;;", true},
+		{"foo() {", true},
+		{"foo |", true},
+		{"foo &&\n", true},
+		{"echo $(foo", true},
+		{"echo ${foo", true},
+		{"echo \"foo\nbar", true},
+		{"cat <<EOF\nbar", true},
+		{"foo; }", false},
+		{"if true; then fi", false},
 	}
 	p := NewParser()
 	for i, tc := range tests {
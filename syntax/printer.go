@@ -98,8 +98,8 @@ func NewPrinter(opts ...PrinterOption) *Printer {
 // to w are buffered.
 //
 // The node types supported at the moment are *File, *Stmt, *Word, any Command
-// node, and any WordPart node. A trailing newline will only be printed when a
-// *File is used.
+// node, any WordPart node, and any ArithmExpr node. A trailing newline will
+// only be printed when a *File is used.
 func (p *Printer) Print(w io.Writer, node Node) error {
 	p.reset()
 
@@ -120,6 +120,10 @@ func (p *Printer) Print(w io.Writer, node Node) error {
 	p.bufWriter.Reset(w)
 	switch x := node.(type) {
 	case *File:
+		if x.BOM {
+			p.WriteString(utf8BOM)
+		}
+		p.src = x.src
 		p.stmtList(x.Stmts, x.Last)
 		p.newline(x.End())
 	case *Stmt:
@@ -133,6 +137,9 @@ func (p *Printer) Print(w io.Writer, node Node) error {
 	case WordPart:
 		p.line = x.Pos().Line()
 		p.wordPart(x, nil)
+	case ArithmExpr:
+		p.line = x.Pos().Line()
+		p.arithmExpr(x, false, false)
 	default:
 		return fmt.Errorf("unsupported node type: %T", x)
 	}
@@ -226,6 +233,9 @@ type Printer struct {
 	// line is the current line number
 	line uint
 
+	// src is the source of the file being printed, if it was kept
+	src []byte
+
 	// lastLevel is the last level of indentation that was used.
 	lastLevel uint
 	// level is the current level of indentation.
@@ -250,6 +260,7 @@ func (p *Printer) reset() {
 	// minification uses its own newline logic
 	p.firstLine = !p.minify
 	p.line = 0
+	p.src = nil
 
 	p.lastLevel, p.level = 0, 0
 	p.levelIncs = p.levelIncs[:0]
@@ -545,8 +556,9 @@ func (p *Printer) wordParts(wps []WordPart, quoted bool) {
 			next = wps[i+1]
 		}
 		for wp.Pos().Line() > p.line {
-			if quoted {
-				// No extra spacing or indentation if quoted.
+			if quoted || i > 0 {
+				// No extra spacing or indentation if quoted,
+				// or within a word.
 				p.WriteString("\\\n")
 				p.line++
 			} else {
@@ -562,6 +574,9 @@ func (p *Printer) wordPart(wp, next WordPart) {
 	switch x := wp.(type) {
 	case *Lit:
 		p.writeLit(x.Value)
+	case *Tilde:
+		p.WriteByte('~')
+		p.writeLit(x.User)
 	case *SglQuoted:
 		if x.Dollar {
 			p.WriteByte('$')
@@ -613,6 +628,12 @@ func (p *Printer) wordPart(wp, next WordPart) {
 		}
 		p.paramExp(x)
 	case *ArithmExp:
+		if x.Bracket {
+			p.WriteString("$[")
+			p.arithmExpr(x.X, false, false)
+			p.WriteByte(']')
+			break
+		}
 		p.WriteString("$((")
 		if x.Unsigned {
 			p.WriteString("# ")
@@ -623,6 +644,19 @@ func (p *Printer) wordPart(wp, next WordPart) {
 		p.WriteString(x.Op.String())
 		p.writeLit(x.Pattern.Value)
 		p.WriteByte(')')
+	case *BraceExp:
+		p.WriteByte('{')
+		sep := ","
+		if x.Sequence {
+			sep = ".."
+		}
+		for i, elem := range x.Elems {
+			if i > 0 {
+				p.WriteString(sep)
+			}
+			p.word(elem)
+		}
+		p.WriteByte('}')
 	case *ProcSubst:
 		// avoid conflict with << and others
 		if p.wantSpace {
@@ -905,26 +939,12 @@ func (p *Printer) stmt(s *Stmt) {
 	}
 	var startRedirs int
 	if s.Cmd != nil {
-		startRedirs = p.command(s.Cmd, s.Redirs)
+		startRedirs = p.redirsBefore(s.Redirs, s.Cmd.Pos())
+		startRedirs += p.command(s.Cmd, s.Redirs[startRedirs:])
 	}
 	p.incLevel()
 	for _, r := range s.Redirs[startRedirs:] {
-		if r.OpPos.Line() > p.line {
-			p.bslashNewl()
-		}
-		if p.wantSpace {
-			p.spacePad(r.Pos())
-		}
-		if r.N != nil {
-			p.writeLit(r.N.Value)
-		}
-		p.WriteString(r.Op.String())
-		if p.spaceRedirects && (r.Op != DplIn && r.Op != DplOut) {
-			p.space()
-		} else {
-			p.wantSpace = true
-		}
-		p.word(r.Word)
+		p.redirect(r)
 		if r.Op == Hdoc || r.Op == DashHdoc {
 			p.pendingHdocs = append(p.pendingHdocs, r)
 		}
@@ -953,36 +973,65 @@ func (p *Printer) stmt(s *Stmt) {
 	p.decLevel()
 }
 
+func (p *Printer) redirect(r *Redirect) {
+	if r.OpPos.Line() > p.line {
+		p.bslashNewl()
+	}
+	if p.wantSpace {
+		p.spacePad(r.Pos())
+	}
+	if r.N != nil {
+		p.writeLit(r.N.Value)
+	}
+	p.WriteString(r.Op.String())
+	if p.spaceRedirects && (r.Op != DplIn && r.Op != DplOut) {
+		p.space()
+	} else {
+		p.wantSpace = true
+	}
+	p.word(r.Word)
+}
+
+// redirsBefore prints the redirections at the start of redirs which appear
+// before pos, returning how many were printed. This keeps redirections in
+// their original place among a command's assignments and arguments, as
+// recorded by their positions. Redirections without a position, and heredocs,
+// are left to be printed at the end of the statement.
+func (p *Printer) redirsBefore(redirs []*Redirect, pos Pos) int {
+	p.incLevel()
+	n := 0
+	for _, r := range redirs {
+		if !r.Pos().IsValid() || !pos.After(r.Pos()) || r.Op == Hdoc || r.Op == DashHdoc {
+			break
+		}
+		p.redirect(r)
+		n++
+	}
+	p.decLevel()
+	return n
+}
+
 func (p *Printer) command(cmd Command, redirs []*Redirect) (startRedirs int) {
 	p.spacePad(cmd.Pos())
 	switch x := cmd.(type) {
 	case *CallExpr:
 		p.assigns(x.Assigns)
-		if len(x.Args) <= 1 {
-			p.wordJoin(x.Args)
-			return 0
-		}
-		p.wordJoin(x.Args[:1])
-		for _, r := range redirs {
-			if r.Pos().After(x.Args[1].Pos()) || r.Op == Hdoc || r.Op == DashHdoc {
-				break
-			}
-			if p.wantSpace {
-				p.spacePad(r.Pos())
-			}
-			if r.N != nil {
-				p.writeLit(r.N.Value)
-			}
-			p.WriteString(r.Op.String())
-			if p.spaceRedirects && (r.Op != DplIn && r.Op != DplOut) {
-				p.space()
-			} else {
-				p.wantSpace = true
+		anyNewline := false
+		for _, w := range x.Args {
+			startRedirs += p.redirsBefore(redirs[startRedirs:], w.Pos())
+			if pos := w.Pos(); pos.Line() > p.line {
+				if !anyNewline {
+					p.incLevel()
+					anyNewline = true
+				}
+				p.bslashNewl()
 			}
-			p.word(r.Word)
-			startRedirs++
+			p.spacePad(w.Pos())
+			p.word(w)
+		}
+		if anyNewline {
+			p.decLevel()
 		}
-		p.wordJoin(x.Args[1:])
 	case *Block:
 		p.WriteByte('{')
 		p.wantSpace = true
@@ -995,7 +1044,10 @@ func (p *Printer) command(cmd Command, redirs []*Redirect) (startRedirs int) {
 	case *Subshell:
 		p.WriteByte('(')
 		p.wantSpace = len(x.Stmts) > 0 && startsWithLparen(x.Stmts[0])
-		p.spacePad(stmtsPos(x.Stmts, x.Last))
+		if !p.wantSpace {
+			// otherwise, the space is written unless a newline follows
+			p.spacePad(stmtsPos(x.Stmts, x.Last))
+		}
 		p.nestedStmts(x.Stmts, x.Last, x.Rparen)
 		p.wantSpace = false
 		p.spacePad(x.Rparen)
@@ -1065,11 +1117,15 @@ func (p *Printer) command(cmd Command, redirs []*Redirect) (startRedirs int) {
 			p.WriteString("function ")
 		}
 		p.writeLit(x.Name.Value)
-		p.WriteString("()")
-		if p.funcNextLine {
+		if !x.RsrvWord || x.Parens {
+			p.WriteString("()")
+		}
+		_, subshell := x.Body.Cmd.(*Subshell)
+		// "function foo (a)" would parse as "function foo()"
+		if p.funcNextLine || x.RsrvWord && !x.Parens && subshell {
 			p.newline(Pos{})
 			p.indent()
-		} else if !p.minify {
+		} else if !p.minify || x.RsrvWord && !x.Parens {
 			p.space()
 		}
 		p.line = x.Body.Pos().Line()
@@ -1151,6 +1207,16 @@ func (p *Printer) command(cmd Command, redirs []*Redirect) (startRedirs int) {
 		}
 		p.space()
 		p.stmt(x.Stmt)
+	case *TestDecl:
+		p.spacedString("@test", x.Pos())
+		p.space()
+		p.word(x.Description)
+		p.space()
+		p.stmt(x.Body)
+	case *BadStmt:
+		// a broken statement is printed as it was in the source
+		p.writeLit(NodeText(p.src, x))
+		p.line = x.End().Line()
 	case *LetClause:
 		p.spacedString("let", x.Pos())
 		for _, n := range x.Exprs {
@@ -1217,6 +1283,11 @@ func (p *Printer) stmtList(stmts []*Stmt, last []Comment) {
 	sep := p.wantNewline ||
 		(len(stmts) > 0 && stmts[0].Pos().Line() > p.line)
 	for _, s := range stmts {
+		if b, ok := s.Cmd.(*BadStmt); ok && NodeText(p.src, b) == "" {
+			// a broken statement without its source is dropped
+			p.line = s.End().Line()
+			continue
+		}
 		pos := s.Pos()
 		var midComs, endComs []Comment
 		for _, c := range s.Comments {
@@ -86,7 +86,12 @@ var printTests = []printCase{
 	{"if a; then b\nelse c\nfi", "if a; then\n\tb\nelse\n\tc\nfi"},
 	samePrint("foo >&2 <f bar"),
 	samePrint("foo >&2 bar <f"),
-	{"foo >&2 bar <f bar2", "foo >&2 bar bar2 <f"},
+	samePrint("foo >&2 bar <f bar2"),
+	samePrint("FOO=1 cmd 2>err arg >out"),
+	samePrint("2>err FOO=1 >x cmd a <in b"),
+	samePrint(">f"),
+	samePrint(">f x=y"),
+	samePrint("foo a \\\n\t>bar \\\n\tb"),
 	{"foo <<EOF bar\nl1\nEOF", "foo bar <<EOF\nl1\nEOF"},
 	samePrint("foo <<\\\\\\\\EOF\nbar\n\\\\EOF"),
 	samePrint("foo <<\"\\EOF\"\nbar\n\\EOF"),
@@ -134,14 +139,10 @@ var printTests = []printCase{
 		"a\n\n\n# etc\nb",
 		"a\n\n# etc\nb",
 	},
-	{
-		"a b\\\nc d",
-		"a bc d",
-	},
-	{
-		"a bb\\\ncc d",
-		"a bbcc d",
-	},
+	samePrint("a b\\\nc d"),
+	samePrint("a bb\\\ncc d"),
+	samePrint("{\n\ta \"b\"\\\nc\n}"),
+	samePrint("{\n\ta b\\\n\\\nc\n}"),
 	samePrint("a \\\n\tb \\\n\tc \\\n\t;"),
 	samePrint("a=1 \\\n\tb=2 \\\n\tc=3 \\\n\t;"),
 	samePrint("if a \\\n\t; then b; fi"),
@@ -367,6 +368,8 @@ var printTests = []printCase{
 	},
 	samePrint("case $i in\n1)\n\ta\n\t#b\n\t;;\nesac"),
 	samePrint("case $i in\n1) foo() { bar; } ;;\nesac"),
+	samePrint("function foo\n(bar)"),
+	samePrint("function foo() (bar)"),
 	samePrint("case $i in\n1) ;; #foo\nesac"),
 	samePrint("case $i in\n#foo\nesac"),
 	samePrint("case $i in\n#before\n1) ;;\nesac"),
@@ -715,6 +718,10 @@ func TestPrintBinaryNextLine(t *testing.T) {
 			"a |\nb |\nc",
 			"a \\\n\t| b \\\n\t| c",
 		},
+		{
+			"a |&\nb |&\nc",
+			"a \\\n\t|& b \\\n\t|& c",
+		},
 		{
 			"foo |\n# misplaced\nbar",
 			"foo \\\n\t|\n\t# misplaced\n\tbar",
@@ -782,7 +789,7 @@ func TestPrintFunctionNextLine(t *testing.T) {
 		},
 		{
 			"function foo {\n\tbar\n}",
-			"function foo()\n{\n\tbar\n}",
+			"function foo\n{\n\tbar\n}",
 		},
 		{
 			"{ foo() { bar; }; }",
@@ -1141,6 +1148,42 @@ func TestPrintManyStmts(t *testing.T) {
 	}
 }
 
+func TestPrintBadStmt(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		in, want, dropped string
+	}{
+		{"foo\necho )\nbar", "foo\necho )\nbar\n", "foo\nbar\n"},
+		{"a; b )\nc", "a\nb )\nc\n", "a\nc\n"},
+		{
+			"if true; then\n\techo  )\nfi\nlast",
+			"if true; then\n\techo  )\nfi\nlast\n",
+			"last\n",
+		},
+	}
+	printer := NewPrinter()
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			for _, keep := range []bool{true, false} {
+				parser := NewParser(RecoverErrors(10), KeepSource(keep))
+				f, _ := parser.Parse(strings.NewReader(tc.in), "")
+				var buf bytes.Buffer
+				if err := printer.Print(&buf, f); err != nil {
+					t.Fatal(err)
+				}
+				want := tc.want
+				if !keep {
+					want = tc.dropped
+				}
+				if got := buf.String(); got != want {
+					t.Fatalf("Print mismatch with KeepSource(%t):\nwant:\n%s\ngot:\n%s",
+						keep, want, got)
+				}
+			}
+		})
+	}
+}
+
 func TestKeepPaddingRepeated(t *testing.T) {
 	t.Parallel()
 	parser := NewParser()
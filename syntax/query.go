@@ -0,0 +1,47 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+// QueryCalls returns the statements in a file which call the command name, in
+// the order that they appear in. Calls anywhere in the file are found,
+// including those within functions, command substitutions, and heredocs.
+//
+// The command name must be static to be matched, though it may be quoted or
+// escaped, as in "\rm" or "'rm'". Declaration builtins like "export" are
+// matched too, even though they are represented as DeclClause nodes. Commands
+// run indirectly, such as via "command rm" or "xargs rm", aren't found.
+func QueryCalls(f *File, name string) []*Stmt {
+	return QueryCallsFunc(f, func(s string) bool { return s == name })
+}
+
+// QueryCallsFunc is like QueryCalls, but matches any command name for which
+// match returns true. For example, to find calls to commands matching a
+// regular expression, use its MatchString method.
+func QueryCallsFunc(f *File, match func(name string) bool) []*Stmt {
+	var stmts []*Stmt
+	Walk(f, func(node Node) bool {
+		if s, ok := node.(*Stmt); ok {
+			if name, ok := callName(s); ok && match(name) {
+				stmts = append(stmts, s)
+			}
+		}
+		return true
+	})
+	return stmts
+}
+
+// callName returns the static name of the command run by a statement, if any.
+func callName(s *Stmt) (string, bool) {
+	switch x := s.Cmd.(type) {
+	case *CallExpr:
+		if len(x.Args) > 0 {
+			if name := testArg(x.Args[0]); name != "" {
+				return name, true
+			}
+		}
+	case *DeclClause:
+		return x.Variant.Value, true
+	}
+	return "", false
+}
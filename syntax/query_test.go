@@ -0,0 +1,67 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestQueryCalls(t *testing.T) {
+	t.Parallel()
+	in := `
+rm -f a
+foo() { \rm b; }
+x=$(echo $(rm c))
+cat <<EOF
+$(rm d)
+EOF
+cat <<'EOF'
+$(rm e)
+EOF
+"rm" f; 'r'm g
+FOO=bar rm h | rmdir i
+command rm j
+$rm k
+export l
+`
+	f, err := NewParser().Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := func(stmts []*Stmt) []string {
+		var list []string
+		for _, s := range stmts {
+			switch x := s.Cmd.(type) {
+			case *CallExpr:
+				list = append(list, x.Args[len(x.Args)-1].Lit())
+			case *DeclClause:
+				list = append(list, x.Args[0].Name.Value)
+			}
+		}
+		return list
+	}
+	tests := []struct {
+		stmts []*Stmt
+		want  []string
+	}{
+		{QueryCalls(f, "rm"), []string{"a", "b", "c", "d", "f", "g", "h"}},
+		{QueryCalls(f, "export"), []string{"l"}},
+		{QueryCalls(f, "missing"), nil},
+		{
+			QueryCallsFunc(f, regexp.MustCompile(`^rm`).MatchString),
+			[]string{"a", "b", "c", "d", "f", "g", "h", "i"},
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			if got := args(tc.stmts); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("want %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
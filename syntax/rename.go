@@ -0,0 +1,330 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RenameVar renames the shell variable old to new throughout a file, wherever
+// it's referenced by name. That is, in parameter expansions like "${old[i]}",
+// assignments like "old+=x", the names given to declaration builtins like
+// "local old", loops like "for old in", arithmetic expressions like
+// "$((old + 1))" or "a[old]", and test expressions like "[[ -v old ]]". The
+// names given to unset, read, and "printf -v" are renamed too, as well as the
+// values of namerefs like "declare -n ref=old".
+//
+// To not change what the program does, RenameVar refuses to rename a variable
+// if new is already used in the file, if either name is special to the shell
+// or a well-known environment variable like "IFS" or "HOME", or if the list of
+// variable names expanded by "${!prefix*}" would change. Since variable names
+// can also be given as values, it refuses as well if the file uses indirect
+// expansions like "${!ref}", or if old is given as a value which isn't known
+// to be a name, as in "v=old" or "echo old". Nothing is renamed in that case.
+//
+// Variable names which only appear in strings, such as in "eval 'old=x'", are
+// not renamed. Note that the positions of the renamed nodes aren't updated.
+func RenameVar(f *File, old, new string) error {
+	for _, name := range []string{old, new} {
+		if !ValidName(name) || name == "_" {
+			return fmt.Errorf("invalid variable name: %q", name)
+		}
+		if specialVar(name) {
+			return fmt.Errorf("cannot rename %s to %s: %s is a special variable",
+				old, new, name)
+		}
+	}
+	if old == new {
+		return nil
+	}
+	r := newVarRefs(f)
+	if lits := r.lits[new]; len(lits) > 0 {
+		return fmt.Errorf("cannot rename %s to %s: %s is already used at %s",
+			old, new, new, lits[0].Pos())
+	}
+	for _, pe := range r.prefixes {
+		prefix := pe.Param.Value
+		if strings.HasPrefix(old, prefix) || strings.HasPrefix(new, prefix) {
+			return fmt.Errorf("cannot rename %s to %s: it would change the names expanded at %s",
+				old, new, pe.Pos())
+		}
+	}
+	if len(r.indirects) > 0 {
+		return fmt.Errorf("cannot rename %s to %s: the indirect expansion at %s may use either",
+			old, new, r.indirects[0].Pos())
+	}
+	refs := make(map[*Lit]bool)
+	for _, lit := range r.lits[old] {
+		refs[lit] = true
+	}
+	for _, w := range r.values[old] {
+		if lit, ok := w.Parts[0].(*Lit); !ok || !refs[lit] {
+			return fmt.Errorf("cannot rename %s to %s: %s may be used as a name at %s",
+				old, new, old, w.Pos())
+		}
+	}
+	for _, lit := range r.lits[old] {
+		lit.Value = new
+	}
+	return nil
+}
+
+// varRefs holds the literals referencing variables in a syntax tree.
+type varRefs struct {
+	lits      map[string][]*Lit
+	prefixes  []*ParamExp        // like "${!prefix*}"
+	indirects []*ParamExp        // like "${!ref}"
+	values    map[string][]*Word // literal values like "x" in "v=x"
+
+	assocs      map[string]bool  // associative arrays, whose indexes are strings
+	skipAssigns map[*Assign]bool // like the names in "declare -f name"
+}
+
+func newVarRefs(f *File) *varRefs {
+	r := &varRefs{
+		lits:        make(map[string][]*Lit),
+		values:      make(map[string][]*Word),
+		assocs:      make(map[string]bool),
+		skipAssigns: make(map[*Assign]bool),
+	}
+	Walk(f, func(node Node) bool {
+		if x, ok := node.(*DeclClause); ok && strings.Contains(declFlags(x), "A") {
+			for _, as := range x.Args {
+				if as.Name != nil {
+					r.assocs[as.Name.Value] = true
+				}
+			}
+		}
+		return true
+	})
+	Walk(f, func(node Node) bool {
+		r.node(node)
+		return true
+	})
+	return r
+}
+
+func (r *varRefs) add(lit *Lit) {
+	if lit != nil && ValidName(lit.Value) {
+		r.lits[lit.Value] = append(r.lits[lit.Value], lit)
+	}
+}
+
+// addValue adds a word which may be used as a variable name if it's a literal,
+// quoted or not, such as "old" in "v=old" or "echo 'old'".
+func (r *varRefs) addValue(w *Word) {
+	if w == nil || len(w.Parts) != 1 {
+		return
+	}
+	var val string
+	switch x := w.Parts[0].(type) {
+	case *Lit:
+		val = x.Value
+	case *SglQuoted:
+		val = x.Value
+	case *DblQuoted:
+		if len(x.Parts) != 1 {
+			return
+		}
+		lit, ok := x.Parts[0].(*Lit)
+		if !ok {
+			return
+		}
+		val = lit.Value
+	}
+	if ValidName(val) {
+		r.values[val] = append(r.values[val], w)
+	}
+}
+
+// addWord adds a word which is a variable name if it's a plain literal, such
+// as "old" in "$((old + 1))" or "unset old".
+func (r *varRefs) addWord(x Node) {
+	if w, ok := x.(*Word); ok && len(w.Parts) == 1 {
+		if lit, ok := w.Parts[0].(*Lit); ok {
+			r.add(lit)
+		}
+	}
+}
+
+func (r *varRefs) node(node Node) {
+	switch x := node.(type) {
+	case *ParamExp:
+		if x.Names != 0 {
+			r.prefixes = append(r.prefixes, x)
+			break
+		}
+		if x.Excl {
+			if w, ok := x.Index.(*Word); !ok || (w.Lit() != "@" && w.Lit() != "*") {
+				r.indirects = append(r.indirects, x)
+			}
+		}
+		r.add(x.Param)
+		if !r.assocs[x.Param.Value] {
+			r.addWord(x.Index)
+		}
+		if x.Slice != nil {
+			r.addWord(x.Slice.Offset)
+			r.addWord(x.Slice.Length)
+		}
+	case *Assign:
+		if r.skipAssigns[x] {
+			break
+		}
+		r.add(x.Name)
+		r.addValue(x.Value)
+		if x.Array != nil {
+			for _, elem := range x.Array.Elems {
+				r.addValue(elem.Value)
+			}
+		}
+		if x.Name == nil || r.assocs[x.Name.Value] {
+			break
+		}
+		r.addWord(x.Index)
+		if x.Array != nil {
+			for _, elem := range x.Array.Elems {
+				r.addWord(elem.Index)
+			}
+		}
+	case *DeclClause:
+		flags := declFlags(x)
+		for _, as := range x.Args {
+			switch {
+			case strings.ContainsAny(flags, "fF"):
+				r.skipAssigns[as] = true
+			case strings.Contains(flags, "n") && as.Value != nil:
+				r.addWord(as.Value)
+			}
+		}
+	case *WordIter:
+		r.add(x.Name)
+	case *ArithmExp:
+		r.addWord(x.X)
+	case *ArithmCmd:
+		r.addWord(x.X)
+	case *BinaryArithm:
+		r.addWord(x.X)
+		r.addWord(x.Y)
+	case *UnaryArithm:
+		r.addWord(x.X)
+	case *ParenArithm:
+		r.addWord(x.X)
+	case *LetClause:
+		for _, expr := range x.Exprs {
+			r.addWord(expr)
+		}
+	case *CStyleLoop:
+		r.addWord(x.Init)
+		r.addWord(x.Cond)
+		r.addWord(x.Post)
+	case *UnaryTest:
+		if x.Op == TsVarSet {
+			r.addWord(x.X)
+		}
+	case *BinaryTest:
+		switch x.Op {
+		case TsEql, TsNeq, TsLeq, TsGeq, TsLss, TsGtr:
+			r.addWord(x.X)
+			r.addWord(x.Y)
+		}
+	case *CallExpr:
+		for _, w := range callVarArgs(x) {
+			r.addWord(w)
+		}
+		// the arguments to unset are either names, flags, or functions
+		if len(x.Args) > 0 && x.Args[0].Lit() != "unset" {
+			for _, w := range x.Args[1:] {
+				r.addValue(w)
+			}
+		}
+	}
+}
+
+// specialVar reports whether name is a variable with a special meaning to the
+// shell, or a well-known environment variable, such as "IFS" or "HOME".
+func specialVar(name string) bool {
+	switch name {
+	case "BASH", "BASHOPTS", "BASHPID", "CDPATH", "COLUMNS", "COPROC",
+		"DIRSTACK", "ENV", "EPOCHREALTIME", "EPOCHSECONDS", "EUID",
+		"FCEDIT", "FIGNORE", "FUNCNAME", "GLOBIGNORE", "GROUPS", "HOME",
+		"HOSTNAME", "HOSTTYPE", "IFS", "INPUTRC", "LANG", "LINENO", "LINES",
+		"LOGNAME", "MACHTYPE", "MAIL", "MAILCHECK", "MAILPATH", "MAPFILE",
+		"OLDPWD", "OPTARG", "OPTERR", "OPTIND", "OSTYPE", "PATH",
+		"PIPESTATUS", "POSIXLY_CORRECT", "PPID", "PROMPT_COMMAND", "PS0",
+		"PS1", "PS2", "PS3", "PS4", "PWD", "RANDOM", "REPLY", "SECONDS",
+		"SHELL", "SHELLOPTS", "SHLVL", "SRANDOM", "TERM", "TIMEFORMAT",
+		"TMOUT", "TMPDIR", "TZ", "UID", "USER":
+		return true
+	}
+	for _, prefix := range []string{"BASH_", "COMP_", "HIST", "LC_", "READLINE_"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// declFlags returns the letters of the flags given to a declaration builtin,
+// such as "fx" for "declare -f -x".
+func declFlags(x *DeclClause) string {
+	var flags strings.Builder
+	for _, as := range x.Args {
+		if as.Naked && as.Value != nil {
+			if val := as.Value.Lit(); len(val) > 1 && (val[0] == '-' || val[0] == '+') {
+				flags.WriteString(val[1:])
+			}
+		}
+	}
+	return flags.String()
+}
+
+// callVarArgs returns the arguments in a call to unset, read, or printf which
+// are names of variables.
+func callVarArgs(x *CallExpr) []*Word {
+	if len(x.Args) == 0 {
+		return nil
+	}
+	var names []*Word
+	args := x.Args[1:]
+	switch x.Args[0].Lit() {
+	case "unset":
+		for _, w := range args {
+			switch val := w.Lit(); {
+			case val == "-f":
+				return nil
+			case !strings.HasPrefix(val, "-"):
+				names = append(names, w)
+			}
+		}
+	case "read":
+		for i := 0; i < len(args); i++ {
+			val := args[i].Lit()
+			if !strings.HasPrefix(val, "-") || val == "-" {
+				names = append(names, args[i])
+				continue
+			}
+			// flags taking an argument may be followed by it, as in
+			// "-p prompt" or "-pprompt"; "-a" takes an array name
+			for j, c := range val[1:] {
+				if !strings.ContainsRune("adinNptu", c) {
+					continue
+				}
+				if j+2 == len(val) && i+1 < len(args) {
+					i++
+					if c == 'a' {
+						names = append(names, args[i])
+					}
+				}
+				break
+			}
+		}
+	case "printf":
+		if len(args) > 1 && args[0].Lit() == "-v" {
+			names = append(names, args[1])
+		}
+	}
+	return names
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var renameVarTests = []struct {
+	in, want string
+}{
+	{"echo $x ${x} ${#x} ${x[1]} ${x:-y} \"$x\"", "echo $y ${y} ${#y} ${y[1]} ${y:-y} \"$y\""},
+	{"echo $xx ${x2} $X", "echo $xx ${x2} $X"},
+	{"x=1; x+=2; x[3]=4; x=(5); foo x=6", "y=1; y+=2; y[3]=4; y=(5); foo x=6"},
+	{"local x; export x=1; declare -a x", "local y; export y=1; declare -a y"},
+	{"declare -f x; unset -f x", "declare -f x; unset -f x"},
+	{"declare -n ref=x; unset x; unset -v x", "declare -n ref=y; unset y; unset -v y"},
+	{"for x in a b; do echo $x; done", "for y in a b; do echo $y; done"},
+	{"for ((x = 0; x < 3; x++)); do :; done", "for ((y = 0; y < 3; y++)); do :; done"},
+	{"echo $((x + $x)) ${a[x]} ${a:x:x}; ((x++)); let x=x*2", "echo $((y + $y)) ${a[y]} ${a:y:y}; ((y++)); let y=y*2"},
+	{"a[x]=1; a=([x]=2)", "a[y]=1; a=([y]=2)"},
+	{"declare -A m; m[x]=1; echo ${m[x]}; m=([x]=2)", "declare -A m; m[x]=1; echo ${m[x]}; m=([x]=2)"},
+	{"[[ -v x && x -eq 1 && x == x ]]", "[[ -v y && y -eq 1 && x == x ]]"},
+	{"read -r x; read -p x: -a x; read -px", "read -r y; read -p x: -a y; read -px"},
+	{"printf -v x %s \"$x\"", "printf -v y %s \"$y\""},
+	{"cat <<EOF\n$x\nEOF", "cat <<EOF\n$y\nEOF"},
+	{"echo 'x.' \"x y\" $(echo $x)", "echo 'x.' \"x y\" $(echo $y)"},
+	{"echo ${!a[@]} ${!a[*]} $x", "echo ${!a[@]} ${!a[*]} $y"},
+}
+
+func TestRenameVar(t *testing.T) {
+	t.Parallel()
+	parser := NewParser()
+	printer := NewPrinter()
+	for i, tc := range renameVarTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := RenameVar(f, "x", "y"); err != nil {
+				t.Fatal(err)
+			}
+			want, err := parser.Parse(strings.NewReader(tc.want), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Equal(f, want) {
+				var sb strings.Builder
+				printer.Print(&sb, f)
+				t.Fatalf("RenameVar mismatch:\nwant %q\ngot  %q", tc.want, sb.String())
+			}
+		})
+	}
+}
+
+var renameVarErrTests = []struct {
+	in, old, new, want string
+}{
+	{"echo $x", "x", "1y", `invalid variable name: "1y"`},
+	{"echo $x", "", "y", `invalid variable name: ""`},
+	{"echo $x", "x", "_", `invalid variable name: "_"`},
+	{"echo $x; y=1", "x", "y", "cannot rename x to y: y is already used at 1:10"},
+	{"echo $x $((y))", "x", "y", "cannot rename x to y: y is already used at 1:12"},
+	{"echo $x; echo ${!y*}", "x", "y", "cannot rename x to y: it would change the names expanded at 1:15"},
+	{"echo $x; echo ${!x@}", "x", "y", "cannot rename x to y: it would change the names expanded at 1:15"},
+	{"echo $x", "x", "PATH", "cannot rename x to PATH: PATH is a special variable"},
+	{"echo $IFS", "IFS", "y", "cannot rename IFS to y: IFS is a special variable"},
+	{"echo $x", "x", "BASH_REMATCH", "cannot rename x to BASH_REMATCH: BASH_REMATCH is a special variable"},
+	{"echo $x ${!ref}", "x", "y", "cannot rename x to y: the indirect expansion at 1:9 may use either"},
+	{"echo x $x", "x", "y", "cannot rename x to y: x may be used as a name at 1:6"},
+	{"echo 'x' $x", "x", "y", "cannot rename x to y: x may be used as a name at 1:6"},
+	{"echo \"x\" $x", "x", "y", "cannot rename x to y: x may be used as a name at 1:6"},
+	{"ref=x; echo $x", "x", "y", "cannot rename x to y: x may be used as a name at 1:5"},
+	{"refs=(a x); echo $x", "x", "y", "cannot rename x to y: x may be used as a name at 1:9"},
+}
+
+func TestRenameVarError(t *testing.T) {
+	t.Parallel()
+	parser := NewParser()
+	for i, tc := range renameVarErrTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			orig := Clone(f, false)
+			err = RenameVar(f, tc.old, tc.new)
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("Unexpected error: want %q, got %v", tc.want, err)
+			}
+			if !Equal(f, orig) {
+				t.Fatalf("RenameVar modified the file after an error")
+			}
+		})
+	}
+}
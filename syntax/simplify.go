@@ -16,6 +16,7 @@ import "bytes"
 //     Remove redundant quotes                  [[ "$var" == str ]]
 //     Merge negations with unary operators     [[ ! -n $var ]]
 //     Use single quotes to shorten literals    "\$foo"
+//     Modernize deprecated arithmetic syntax   $[expr]
 func Simplify(n Node) bool {
 	s := simplifier{}
 	Walk(n, s.visit)
@@ -45,6 +46,10 @@ func (s *simplifier) visit(node Node) bool {
 		x.Slice.Length = s.removeParensArithm(x.Slice.Length)
 		x.Slice.Length = s.inlineSimpleParams(x.Slice.Length)
 	case *ArithmExp:
+		if x.Bracket {
+			s.modified = true
+			x.Bracket = false
+		}
 		x.X = s.removeParensArithm(x.X)
 		x.X = s.inlineSimpleParams(x.X)
 	case *ArithmCmd:
@@ -33,6 +33,8 @@ var simplifyTests = [...]simplifyTest{
 	noSimple("a[$b]=2"),
 	noSimple("${a[$b]}"),
 	noSimple("(($3 == $#))"),
+	{"$[a + 1]", "$((a + 1))"},
+	{"\"$[(1)]\"", "\"$((1))\""},
 
 	// test exprs
 	{`[[ "$foo" == "bar" ]]`, `[[ $foo == "bar" ]]`},
@@ -0,0 +1,221 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClassicTest parses the arguments of a call to the "[" or "test" builtins,
+// such as `[ -n "$x" -a "$y" = z ]`, as a test expression made up of the same
+// nodes found in a TestClause. This allows analysing both kinds of conditionals
+// alike. The resulting nodes reuse the call's words, so their positions point
+// to the original source.
+//
+// Operators and parentheses are only recognised as such if their words are
+// static, such as "-f", "=" or "\(". Following Bash, "-a" binds more tightly
+// than "-o", and an operator without the operands it requires, as in "[ -n ]",
+// is a regular word.
+//
+// The obsolescent "-a" and "-o" binary operators are represented with AndTest
+// and OrTest, like "&&" and "||" in a TestClause. They are also returned in
+// obsolete, so that they can be flagged.
+func ClassicTest(call *CallExpr) (x TestExpr, obsolete []*BinaryTest, err error) {
+	if len(call.Args) == 0 {
+		return nil, nil, fmt.Errorf("not a test command")
+	}
+	args := call.Args[1:]
+	switch call.Args[0].Lit() {
+	case "[":
+		if len(args) == 0 || testArg(args[len(args)-1]) != "]" {
+			return nil, nil, testErr(call.Args[0].Pos(), `"[" must be closed with "]"`)
+		}
+		args = args[:len(args)-1]
+	case "test":
+	default:
+		return nil, nil, fmt.Errorf("not a test command")
+	}
+	p := &classicTestParser{args: args}
+	if len(args) > 0 {
+		x = p.orTest()
+		if p.err == nil && p.i < len(args) {
+			p.errf(args[p.i].Pos(), "not a valid test operator: %s", testArg(args[p.i]))
+		}
+	}
+	if p.err != nil {
+		return nil, nil, p.err
+	}
+	return x, p.obsolete, nil
+}
+
+type classicTestParser struct {
+	args     []*Word
+	i        int
+	obsolete []*BinaryTest
+	err      error
+}
+
+func testErr(pos Pos, text string) error {
+	return ParseError{Pos: pos, Text: text}
+}
+
+func (p *classicTestParser) errf(pos Pos, format string, a ...interface{}) {
+	if p.err == nil {
+		p.err = testErr(pos, fmt.Sprintf(format, a...))
+	}
+}
+
+// peek returns the static value of the argument n positions ahead, and whether
+// there is such an argument.
+func (p *classicTestParser) peek(n int) (string, bool) {
+	if p.i+n >= len(p.args) {
+		return "", false
+	}
+	return testArg(p.args[p.i+n]), true
+}
+
+func (p *classicTestParser) orTest() TestExpr {
+	return p.binaryTest("-o", OrTest, p.andTest)
+}
+
+func (p *classicTestParser) andTest() TestExpr {
+	return p.binaryTest("-a", AndTest, p.termTest)
+}
+
+func (p *classicTestParser) binaryTest(val string, op BinTestOperator, operand func() TestExpr) TestExpr {
+	x := operand()
+	for p.err == nil {
+		if next, ok := p.peek(0); !ok || next != val {
+			break
+		}
+		b := &BinaryTest{OpPos: p.args[p.i].Pos(), Op: op, X: x}
+		p.i++
+		if _, ok := p.peek(0); !ok {
+			p.errf(b.OpPos, "%s must be followed by an expression", val)
+			break
+		}
+		b.Y = operand()
+		p.obsolete = append(p.obsolete, b)
+		x = b
+	}
+	return x
+}
+
+func (p *classicTestParser) termTest() TestExpr {
+	cur, _ := p.peek(0)
+	w := p.args[p.i]
+	// As per POSIX, a binary operator in the second argument takes
+	// precedence, so that "[ ! = x ]" and "[ -f = x ]" compare strings.
+	if next, ok := p.peek(1); ok {
+		if op := classicBinaryOp(next); op != 0 {
+			if _, ok := p.peek(2); ok {
+				b := &BinaryTest{
+					OpPos: p.args[p.i+1].Pos(),
+					Op:    op,
+					X:     w,
+					Y:     p.args[p.i+2],
+				}
+				p.i += 3
+				return b
+			}
+		}
+	}
+	_, more := p.peek(1)
+	switch {
+	case !more:
+		// a single argument is always a word, like in "[ -n ]"
+	case cur == "!":
+		p.i++
+		return &UnaryTest{OpPos: w.Pos(), Op: TsNot, X: p.termTest()}
+	case cur == "(":
+		p.i++
+		pt := &ParenTest{Lparen: w.Pos(), X: p.orTest()}
+		if next, ok := p.peek(0); !ok || next != ")" {
+			p.errf(w.Pos(), `reached %s without matching ( with )`, p.endName())
+			return pt
+		}
+		pt.Rparen = p.args[p.i].Pos()
+		p.i++
+		return pt
+	default:
+		if op := testUnaryOp(cur); op != 0 && op != TsNot {
+			p.i += 2
+			return &UnaryTest{OpPos: w.Pos(), Op: op, X: p.args[p.i-1]}
+		}
+	}
+	p.i++
+	return w
+}
+
+// classicBinaryOp is like testBinaryOp, but without "=~", and with "<" and ">"
+// which must be quoted to not be redirections.
+func classicBinaryOp(val string) BinTestOperator {
+	switch val {
+	case "=~":
+		return 0
+	case "<":
+		return TsBefore
+	case ">":
+		return TsAfter
+	}
+	return testBinaryOp(val)
+}
+
+// endName describes the argument being parsed for errors.
+func (p *classicTestParser) endName() string {
+	if next, ok := p.peek(0); ok {
+		return next
+	}
+	return "end of arguments"
+}
+
+// testArg returns the value of a word used as an argument to a classic test
+// command if it's static, like "-f" or "\(". Otherwise, it returns an empty
+// string, so that the word can't be mistaken for an operator.
+func testArg(w *Word) string {
+	var sb strings.Builder
+	for _, part := range w.Parts {
+		switch x := part.(type) {
+		case *Lit:
+			sb.WriteString(unescapeLit(x.Value, false))
+		case *SglQuoted:
+			if x.Dollar {
+				return ""
+			}
+			sb.WriteString(x.Value)
+		case *DblQuoted:
+			if x.Dollar {
+				return ""
+			}
+			for _, part := range x.Parts {
+				lit, ok := part.(*Lit)
+				if !ok {
+					return ""
+				}
+				sb.WriteString(unescapeLit(lit.Value, true))
+			}
+		default:
+			return ""
+		}
+	}
+	return sb.String()
+}
+
+// unescapeLit removes the backslashes escaping characters in a literal. Within
+// double quotes, only a few characters can be escaped.
+func unescapeLit(val string, dblQuoted bool) string {
+	if !strings.Contains(val, `\`) {
+		return val
+	}
+	var sb strings.Builder
+	for i := 0; i < len(val); i++ {
+		if val[i] == '\\' && i+1 < len(val) &&
+			(!dblQuoted || strings.IndexByte("$`\"\\", val[i+1]) >= 0) {
+			i++
+		}
+		sb.WriteByte(val[i])
+	}
+	return sb.String()
+}
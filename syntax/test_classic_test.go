@@ -0,0 +1,104 @@
+// Copyright (c) 2020, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var classicTests = []struct {
+	in, want string
+	obsolete int
+}{
+	{`[ ]`, ``, 0},
+	{`test`, ``, 0},
+	{`[ "$x" ]`, `[[ "$x" ]]`, 0},
+	{`[ -n ]`, `[[ -n ]]`, 0},
+	{`[ ! ]`, `[[ ! ]]`, 0},
+	{`[ -n "$x" ]`, `[[ -n "$x" ]]`, 0},
+	{`test -f foo`, `[[ -f foo ]]`, 0},
+	{`[ "$a" = b ]`, `[[ "$a" = b ]]`, 0},
+	{`[ "$a" != "b" ]`, `[[ "$a" != "b" ]]`, 0},
+	{`[ 1 -lt 2 ]`, `[[ 1 -lt 2 ]]`, 0},
+	{`[ a \< b ]`, `[[ a < b ]]`, 0},
+	{`[ a '>' b ]`, `[[ a > b ]]`, 0},
+	{`[ ! -e x ]`, `[[ ! -e x ]]`, 0},
+	{`[ ! = x ]`, `[[ ! = x ]]`, 0},
+	{`[ -f = x ]`, `[[ -f = x ]]`, 0},
+	{`[ -n "$x" -a "$y" = z ]`, `[[ -n "$x" && "$y" = z ]]`, 1},
+	{`[ a -o b -a c ]`, `[[ a || b && c ]]`, 2},
+	{`[ a -a b -o c ]`, `[[ a && b || c ]]`, 2},
+	{`[ \( a -o b \) -a c ]`, `[[ (a || b) && c ]]`, 2},
+	{`[ "(" a ")" ]`, `[[ (a) ]]`, 0},
+	{`[ ! \( -f a \) ]`, `[[ ! (-f a) ]]`, 0},
+	{`[ -a file ]`, `[[ -e file ]]`, 0},
+}
+
+func TestClassicTest(t *testing.T) {
+	t.Parallel()
+	p := NewParser()
+	printer := NewPrinter()
+	for i, tc := range classicTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			f, err := p.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			call := f.Stmts[0].Cmd.(*CallExpr)
+			x, obsolete, err := ClassicTest(call)
+			if err != nil {
+				t.Fatalf("Unexpected error in %q: %v", tc.in, err)
+			}
+			got := ""
+			if x != nil {
+				var sb strings.Builder
+				clause := &TestClause{Left: call.Pos(), Right: call.End(), X: x}
+				printer.Print(&sb, clause)
+				got = sb.String()
+			}
+			if got != tc.want {
+				t.Fatalf("ClassicTest mismatch in %q:\nwant: %q\ngot:  %q",
+					tc.in, tc.want, got)
+			}
+			if len(obsolete) != tc.obsolete {
+				t.Fatalf("want %d obsolete operators in %q, got %d",
+					tc.obsolete, tc.in, len(obsolete))
+			}
+			for _, b := range obsolete {
+				op := tc.in[b.OpPos.Offset():][:2]
+				if op != "-a" && op != "-o" {
+					t.Fatalf("obsolete operator at %s is %q", b.OpPos, op)
+				}
+			}
+		})
+	}
+}
+
+func TestClassicTestErr(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, want string
+	}{
+		{`echo foo`, `not a test command`},
+		{`[ -n x`, `1:1: "[" must be closed with "]"`},
+		{`[ a -a ]`, `1:5: -a must be followed by an expression`},
+		{`[ a b ]`, `1:5: not a valid test operator: b`},
+		{`[ "$op" x ]`, `1:9: not a valid test operator: x`},
+		{`[ \( a b ]`, `1:3: reached b without matching ( with )`},
+		{`test \( a`, `1:6: reached end of arguments without matching ( with )`},
+	}
+	p := NewParser()
+	for _, tc := range tests {
+		f, err := p.Parse(strings.NewReader(tc.in), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = ClassicTest(f.Stmts[0].Cmd.(*CallExpr))
+		if err == nil || err.Error() != tc.want {
+			t.Fatalf("Error mismatch in %q:\nwant: %s\ngot:  %v", tc.in, tc.want, err)
+		}
+	}
+}
@@ -347,3 +347,112 @@ func (o UnAritOperator) String() string   { return token(o).String() }
 func (o BinAritOperator) String() string  { return token(o).String() }
 func (o UnTestOperator) String() string   { return token(o).String() }
 func (o BinTestOperator) String() string  { return token(o).String() }
+
+// Token is a lexical token found in the input, as reported by Parser.Tokens.
+type Token struct {
+	Kind     TokenKind
+	Pos, End Pos
+
+	// Value is the literal text of a LitToken, the text of a
+	// CommentToken including its "#", or the source text of any other
+	// token, such as "$(", "|" or "\n".
+	Value string
+
+	quote quoteState // the lexer's state when the token was found
+	use   tokenUse   // how the parser used the token, set via markTok
+}
+
+// tokenUse records how the parser used a token, which the lexer alone can't
+// tell, such as "if" being a keyword in "if foo" but not in "echo if".
+type tokenUse uint8
+
+const (
+	useKeyword tokenUse = 1 << iota
+	useUnaryTest
+)
+
+// String returns the token's Value, which is its text in the source.
+func (t Token) String() string { return t.Value }
+
+// IsKeyword reports whether the token is a reserved word such as "if" or
+// "done" which the parser used as such. Reserved words are only keywords in
+// certain positions; "if" and "in" aren't keywords in "echo if" or in the
+// pattern of "case x in in)".
+func (t Token) IsKeyword() bool { return t.use&useKeyword != 0 }
+
+// IsRedirect reports whether the token is a redirection operator, such as
+// ">" or "<<", outside of arithmetic and test expressions.
+func (t Token) IsRedirect() bool {
+	if t.Kind != OpToken || t.quote&(allRegTokens&^testExpr) == 0 {
+		return false
+	}
+	for op := RdrOut; op <= AppAll; op++ {
+		if t.Value == op.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBinaryArithm reports whether the token is a binary operator within an
+// arithmetic expression, such as "+", "<<" or "*=".
+func (t Token) IsBinaryArithm() bool {
+	if t.Kind != OpToken || t.quote&allArithmExpr == 0 {
+		return false
+	}
+	for _, op := range binAritOperators {
+		if t.Value == op.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnaryTest reports whether the token is a unary operator within a test
+// expression, such as "-f" or "!" in "[[ ! -f foo ]]". Operands aren't unary
+// operators even if they look like one, as with "-f" in "[[ a == -f ]]".
+func (t Token) IsUnaryTest() bool { return t.use&useUnaryTest != 0 }
+
+var binAritOperators = [...]BinAritOperator{
+	Add, Sub, Mul, Quo, Rem, Pow, Eql, Gtr, Lss, Neq, Leq, Geq,
+	And, Or, Xor, Shr, Shl, AndArit, OrArit, Comma, TernQuest, TernColon,
+	Assgn, AddAssgn, SubAssgn, MulAssgn, QuoAssgn, RemAssgn, AndAssgn,
+	OrAssgn, XorAssgn, ShlAssgn, ShrAssgn,
+}
+
+// IsKeyword reports whether word is one of the shell's reserved words, such as
+// "if", "done" or "[[", which start or end compound commands where a command
+// is expected.
+func IsKeyword(word string) bool {
+	switch word {
+	case "!", "{", "}", "[[", "]]", "case", "coproc", "do", "done", "elif",
+		"else", "esac", "fi", "for", "function", "if", "in", "select",
+		"then", "time", "until", "while":
+		return true
+	}
+	return false
+}
+
+// TokenKind describes what kind of lexical token a Token is.
+type TokenKind int
+
+const (
+	LitToken     TokenKind = iota + 1 // literal, such as a word or "if"
+	OpToken                           // operator or delimiter, such as "|" or "$("
+	NewlineToken                      // newline separating statements
+	CommentToken                      // comment, such as "# foo"
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case LitToken:
+		return "literal"
+	case OpToken:
+		return "operator"
+	case NewlineToken:
+		return "newline"
+	case CommentToken:
+		return "comment"
+	}
+	return "invalid token kind"
+}
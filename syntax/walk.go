@@ -13,8 +13,8 @@ func walkStmts(stmts []*Stmt, last []Comment, f func(Node) bool) {
 	for _, s := range stmts {
 		Walk(s, f)
 	}
-	for _, c := range last {
-		Walk(&c, f)
+	for i := range last {
+		Walk(&last[i], f)
 	}
 }
 
@@ -28,6 +28,12 @@ func walkWords(words []*Word, f func(Node) bool) {
 // f(node); node must not be nil. If f returns true, Walk invokes f
 // recursively for each of the non-nil children of node, followed by
 // f(nil).
+//
+// All nodes are pointers into the syntax tree, including the *Comment nodes
+// for the comments held in slices, so f may modify them in place.
+//
+// Like Inspect in go/ast, Walk reaches every node in the tree, so f only needs
+// to handle the node types it's interested in.
 func Walk(node Node, f func(Node) bool) {
 	if !f(node) {
 		return
@@ -35,15 +41,19 @@ func Walk(node Node, f func(Node) bool) {
 
 	switch x := node.(type) {
 	case *File:
+		if x.Shebang != nil {
+			Walk(x.Shebang, f)
+		}
 		walkStmts(x.Stmts, x.Last, f)
-	case *Comment:
+	case *Comment, *Shebang:
 	case *Stmt:
-		for _, c := range x.Comments {
+		for i := range x.Comments {
+			c := &x.Comments[i]
 			if !x.End().After(c.Pos()) {
-				defer Walk(&c, f)
+				defer Walk(c, f)
 				break
 			}
-			Walk(&c, f)
+			Walk(c, f)
 		}
 		if x.Cmd != nil {
 			Walk(x.Cmd, f)
@@ -117,6 +127,7 @@ func Walk(node Node, f func(Node) bool) {
 			Walk(wp, f)
 		}
 	case *Lit:
+	case *Tilde:
 	case *SglQuoted:
 	case *DblQuoted:
 		for _, wp := range x.Parts {
@@ -129,6 +140,14 @@ func Walk(node Node, f func(Node) bool) {
 		if x.Index != nil {
 			Walk(x.Index, f)
 		}
+		if x.Slice != nil {
+			if x.Slice.Offset != nil {
+				Walk(x.Slice.Offset, f)
+			}
+			if x.Slice.Length != nil {
+				Walk(x.Slice.Length, f)
+			}
+		}
 		if x.Repl != nil {
 			if x.Repl.Orig != nil {
 				Walk(x.Repl.Orig, f)
@@ -163,22 +182,24 @@ func Walk(node Node, f func(Node) bool) {
 		for _, ci := range x.Items {
 			Walk(ci, f)
 		}
-		for _, c := range x.Last {
-			Walk(&c, f)
+		for i := range x.Last {
+			Walk(&x.Last[i], f)
 		}
 	case *CaseItem:
-		for _, c := range x.Comments {
+		for i := range x.Comments {
+			c := &x.Comments[i]
 			if c.Pos().After(x.Pos()) {
-				defer Walk(&c, f)
+				defer Walk(c, f)
 				break
 			}
-			Walk(&c, f)
+			Walk(c, f)
 		}
 		walkWords(x.Patterns, f)
 		walkStmts(x.Stmts, x.Last, f)
 	case *TestClause:
 		Walk(x.X, f)
 	case *DeclClause:
+		Walk(x.Variant, f)
 		for _, a := range x.Args {
 			Walk(a, f)
 		}
@@ -186,16 +207,17 @@ func Walk(node Node, f func(Node) bool) {
 		for _, el := range x.Elems {
 			Walk(el, f)
 		}
-		for _, c := range x.Last {
-			Walk(&c, f)
+		for i := range x.Last {
+			Walk(&x.Last[i], f)
 		}
 	case *ArrayElem:
-		for _, c := range x.Comments {
+		for i := range x.Comments {
+			c := &x.Comments[i]
 			if c.Pos().After(x.Pos()) {
-				defer Walk(&c, f)
+				defer Walk(c, f)
 				break
 			}
-			Walk(&c, f)
+			Walk(c, f)
 		}
 		if x.Index != nil {
 			Walk(x.Index, f)
@@ -205,6 +227,8 @@ func Walk(node Node, f func(Node) bool) {
 		}
 	case *ExtGlob:
 		Walk(x.Pattern, f)
+	case *BraceExp:
+		walkWords(x.Elems, f)
 	case *ProcSubst:
 		walkStmts(x.Stmts, x.Last, f)
 	case *TimeClause:
@@ -216,6 +240,10 @@ func Walk(node Node, f func(Node) bool) {
 			Walk(x.Name, f)
 		}
 		Walk(x.Stmt, f)
+	case *TestDecl:
+		Walk(x.Description, f)
+		Walk(x.Body, f)
+	case *BadStmt:
 	case *LetClause:
 		for _, expr := range x.Exprs {
 			Walk(expr, f)
@@ -227,6 +255,175 @@ func Walk(node Node, f func(Node) bool) {
 	f(nil)
 }
 
+// Parents maps each node in a syntax tree to its parent node, allowing to
+// navigate the tree upwards. For example, it can tell whether a word is
+// within double quotes or a test clause.
+type Parents map[Node]Node
+
+// NewParents walks the syntax tree rooted at root once, recording the parent
+// of each node. The root node has no parent.
+func NewParents(root Node) Parents {
+	parents := make(Parents)
+	var stack []Node
+	Walk(root, func(node Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		// Walk visits the comments after some nodes once it's done with
+		// them, so record the parents of those comments upfront.
+		var comments []Comment
+		switch x := node.(type) {
+		case *Stmt:
+			comments = x.Comments
+		case *CaseItem:
+			comments = x.Comments
+		case *ArrayElem:
+			comments = x.Comments
+		}
+		for i := range comments {
+			parents[&comments[i]] = node
+		}
+		if _, ok := parents[node]; !ok && len(stack) > 0 {
+			parents[node] = stack[len(stack)-1]
+		}
+		stack = append(stack, node)
+		return true
+	})
+	return parents
+}
+
+// Stack returns the ancestors of node, starting with its parent and ending
+// with the root node. It is empty if node has no parent, such as the root.
+func (p Parents) Stack(node Node) []Node {
+	var stack []Node
+	for parent := p[node]; parent != nil; parent = p[parent] {
+		stack = append(stack, parent)
+	}
+	return stack
+}
+
+// Clone returns a deep copy of the syntax tree rooted at node, so that either
+// of them can be modified without affecting the other. If clearPos is true,
+// all positions in the copy are zero, so that it is printed as if it had been
+// built by hand, such as when placing it into another syntax tree.
+func Clone(node Node, clearPos bool) Node {
+	if node == nil {
+		return nil
+	}
+	c := cloneValue(reflect.ValueOf(node), clearPos).Interface().(Node)
+	if f, ok := c.(*File); ok {
+		// the unexported slices are shared with the original file
+		f.lines = append(f.lines[:0:0], f.lines...)
+		f.src = append(f.src[:0:0], f.src...)
+		if clearPos {
+			f.lines = nil
+		}
+	}
+	return c
+}
+
+var (
+	nodeType = reflect.TypeOf((*Node)(nil)).Elem()
+	posType  = reflect.TypeOf(Pos{})
+)
+
+func cloneValue(x reflect.Value, clearPos bool) reflect.Value {
+	switch x.Kind() {
+	case reflect.Ptr:
+		if x.IsNil() {
+			return x
+		}
+		c := reflect.New(x.Type().Elem())
+		c.Elem().Set(cloneValue(x.Elem(), clearPos))
+		return c
+	case reflect.Interface:
+		// only nodes are cloned; other values like errors are kept
+		if x.IsNil() || !x.Elem().Type().Implements(nodeType) {
+			return x
+		}
+		c := reflect.New(x.Type()).Elem()
+		c.Set(cloneValue(x.Elem(), clearPos))
+		return c
+	case reflect.Slice:
+		if x.IsNil() {
+			return x
+		}
+		c := reflect.MakeSlice(x.Type(), x.Len(), x.Len())
+		for i := 0; i < x.Len(); i++ {
+			c.Index(i).Set(cloneValue(x.Index(i), clearPos))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(x.Type()).Elem()
+		if x.Type() == posType {
+			if !clearPos {
+				c.Set(x)
+			}
+			return c
+		}
+		c.Set(x) // copies unexported fields, like the line offsets in File
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).PkgPath == "" {
+				c.Field(i).Set(cloneValue(x.Field(i), clearPos))
+			}
+		}
+		return c
+	}
+	return x
+}
+
+// Equal reports whether two syntax trees are structurally equal, ignoring all
+// positions. Unlike reflect.DeepEqual, this means that a tree is equal to one
+// parsed from the same program formatted differently, as long as the nodes
+// and their values such as literals and comments are the same.
+func Equal(a, b Node) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return equalValue(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+func equalValue(x, y reflect.Value) bool {
+	if x.Type() != y.Type() {
+		return false
+	}
+	switch x.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if x.IsNil() || y.IsNil() {
+			return x.IsNil() == y.IsNil()
+		}
+		if x.Kind() == reflect.Interface && !x.Elem().Type().Implements(nodeType) {
+			return reflect.DeepEqual(x.Interface(), y.Interface())
+		}
+		return equalValue(x.Elem(), y.Elem())
+	case reflect.Slice:
+		if x.Len() != y.Len() {
+			return false
+		}
+		for i := 0; i < x.Len(); i++ {
+			if !equalValue(x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if x.Type() == posType {
+			return true
+		}
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).PkgPath != "" {
+				continue // unexported fields, like the line offsets in File
+			}
+			if !equalValue(x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return x.Interface() == y.Interface()
+}
+
 // DebugPrint prints the provided syntax tree, spanning multiple lines and with
 // indentation. Can be useful to investigate the content of a syntax tree.
 func DebugPrint(w io.Writer, node Node) error {
@@ -295,10 +492,17 @@ func (p *debugPrinter) print(x reflect.Value) {
 		p.printf("%s {", t)
 		p.level++
 		p.newline()
+		// skip unexported fields, like the line offsets in File
+		var fields []int
 		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" {
+				fields = append(fields, i)
+			}
+		}
+		for j, i := range fields {
 			p.printf("%s: ", t.Field(i).Name)
 			p.print(x.Field(i))
-			if i == x.NumField()-1 {
+			if j == len(fields)-1 {
 				p.level--
 			}
 			p.newline()
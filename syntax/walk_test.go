@@ -30,6 +30,7 @@ func TestWalk(t *testing.T) {
 		"*syntax.FuncDecl":     false,
 		"*syntax.Word":         false,
 		"*syntax.Lit":          false,
+		"*syntax.Tilde":        false,
 		"*syntax.SglQuoted":    false,
 		"*syntax.DblQuoted":    false,
 		"*syntax.CmdSubst":     false,
@@ -122,3 +123,245 @@ func TestWalkUnexpectedType(t *testing.T) {
 		return true
 	})
 }
+
+func TestWalkModifyComments(t *testing.T) {
+	t.Parallel()
+	in := "# a\nfoo # b\ncase x in\n# c\ny) ;; # d\nesac\narr=(\n\t# e\n\tz\n)\n{\n\tbar\n\t# f\n}\n# g\n"
+	f, err := NewParser(KeepComments(true)).Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Walk(f, func(node Node) bool {
+		if c, ok := node.(*Comment); ok {
+			c.Text = strings.ToUpper(c.Text)
+		}
+		return true
+	})
+	var sb strings.Builder
+	if err := NewPrinter().Print(&sb, f); err != nil {
+		t.Fatal(err)
+	}
+	got := sb.String()
+	for _, text := range []string{"A", "B", "C", "D", "E", "F", "G"} {
+		if !strings.Contains(got, "# "+text+"\n") {
+			t.Fatalf("comment %q not modified in place:\n%s", text, got)
+		}
+	}
+}
+
+func TestParents(t *testing.T) {
+	t.Parallel()
+	in := "[[ $a ]] && echo \"${b}\" # c\n"
+	f, err := NewParser(KeepComments(true)).Parse(strings.NewReader(in), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parents := NewParents(f)
+	stackTypes := func(node Node) string {
+		var types []string
+		for _, parent := range parents.Stack(node) {
+			types = append(types, fmt.Sprintf("%T", parent))
+		}
+		return strings.Join(types, " ")
+	}
+	Walk(f, func(node Node) bool {
+		var want string
+		switch x := node.(type) {
+		case *File:
+			want = ""
+		case *ParamExp:
+			switch x.Param.Value {
+			case "a":
+				want = "*syntax.Word *syntax.TestClause *syntax.Stmt *syntax.BinaryCmd *syntax.Stmt *syntax.File"
+			case "b":
+				want = "*syntax.DblQuoted *syntax.Word *syntax.CallExpr *syntax.Stmt *syntax.BinaryCmd *syntax.Stmt *syntax.File"
+			}
+		case *Comment:
+			want = "*syntax.Stmt *syntax.File"
+		case nil:
+		default:
+			if parents[node] == nil {
+				t.Errorf("%T has no parent", node)
+			}
+			return true
+		}
+		if got := stackTypes(node); got != want {
+			t.Errorf("Parents of %T mismatch:\nwant: %s\ngot:  %s", node, want, got)
+		}
+		return true
+	})
+}
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	printer := NewPrinter()
+	for i, c := range fileTests {
+		in := c.Strs[0]
+		orig, err := parser.Parse(strings.NewReader(in), "")
+		if err != nil {
+			continue // not valid Bash
+		}
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			var before strings.Builder
+			if err := printer.Print(&before, orig); err != nil {
+				t.Fatal(err)
+			}
+			clone := Clone(orig, false).(*File)
+			if !reflect.DeepEqual(orig, clone) {
+				t.Fatalf("Clone differs from the original in %q", in)
+			}
+			Walk(clone, func(node Node) bool {
+				if lit, ok := node.(*Lit); ok {
+					lit.Value = "changed"
+				}
+				return true
+			})
+			var after strings.Builder
+			if err := printer.Print(&after, orig); err != nil {
+				t.Fatal(err)
+			}
+			if before.String() != after.String() {
+				t.Fatalf("Modifying the clone changed the original in %q: %q", in, after.String())
+			}
+
+			lines := orig.LineCount()
+			offsets := make([]Pos, lines)
+			for line := range offsets {
+				offsets[line] = orig.LineStart(uint(line) + 1)
+			}
+			if len(clone.Stmts) > 0 {
+				if err := DeleteStmt(clone, clone.Stmts[0]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := orig.LineCount(); got != lines {
+				t.Fatalf("Editing the clone changed the line count of %q: %d", in, got)
+			}
+			for line, want := range offsets {
+				if got := orig.LineStart(uint(line) + 1); got != want {
+					t.Fatalf("Editing the clone changed line %d of %q: %s", line+1, in, got)
+				}
+			}
+
+			Walk(Clone(orig, true), func(node Node) bool {
+				if node != nil && node.Pos() != (Pos{}) {
+					t.Fatalf("Unexpected position in %T in a clone of %q: %s", node, in, node.Pos())
+				}
+				return true
+			})
+		})
+	}
+	if Clone(nil, false) != nil {
+		t.Fatalf("Clone of nil is not nil")
+	}
+}
+
+var equalTests = [...]struct {
+	a, b  string
+	equal bool
+}{
+	{"foo", "foo", true},
+	{"foo bar", "foo    bar", true},
+	{"foo; bar", "foo\nbar", true},
+	{"if a; then b; fi", "if a\nthen\n\tb\nfi", true},
+	{"$((1+2))", "$(( 1 + 2 ))", true},
+	{"a=(x y)", "a=( x\n\ty )", true},
+	{"foo # bar", "foo   # bar", true},
+	{"foo", "bar", false},
+	{"foo bar", "foo", false},
+	{"foo; bar", "foo && bar", false},
+	{"foo # bar", "foo # baz", false},
+	{"foo # bar", "foo", false},
+	{"$((1+2))", "$((1-2))", false},
+	{"'foo'", `"foo"`, false},
+	{"foo >bar", "foo <bar", false},
+}
+
+func TestEqual(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	for i, tc := range equalTests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			a, err := parser.Parse(strings.NewReader(tc.a), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := parser.Parse(strings.NewReader(tc.b), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Equal(a, b); got != tc.equal {
+				t.Fatalf("Equal(%q, %q) got %t, want %t", tc.a, tc.b, got, tc.equal)
+			}
+			if got := Equal(b, a); got != tc.equal {
+				t.Fatalf("Equal(%q, %q) got %t, want %t", tc.b, tc.a, got, tc.equal)
+			}
+			if !Equal(a, Clone(a, true)) {
+				t.Fatalf("Equal(%q, Clone) got false", tc.a)
+			}
+		})
+	}
+	if !Equal(nil, nil) || Equal(&Lit{}, nil) || Equal(&Lit{}, &Word{}) {
+		t.Fatalf("Equal mishandled nil or mismatched nodes")
+	}
+}
+
+// reachableNodes finds all the nodes in a syntax tree via reflection, without
+// relying on Walk.
+func reachableNodes(x reflect.Value, found map[Node]bool) {
+	switch x.Kind() {
+	case reflect.Ptr:
+		if x.IsNil() {
+			return
+		}
+		if node, ok := x.Interface().(Node); ok {
+			found[node] = true
+		}
+		reachableNodes(x.Elem(), found)
+	case reflect.Interface:
+		if !x.IsNil() && x.Elem().Type().Implements(nodeType) {
+			reachableNodes(x.Elem(), found)
+		}
+	case reflect.Slice:
+		for i := 0; i < x.Len(); i++ {
+			if x.Index(i).Kind() == reflect.Struct {
+				reachableNodes(x.Index(i).Addr(), found)
+			} else {
+				reachableNodes(x.Index(i), found)
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).PkgPath == "" {
+				reachableNodes(x.Field(i), found)
+			}
+		}
+	}
+}
+
+func TestWalkReachesAllNodes(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	ins := []string{"#!/bin/bash\necho foo"}
+	for _, c := range fileTests {
+		ins = append(ins, c.Strs[0])
+	}
+	for i, in := range ins {
+		f, err := parser.Parse(strings.NewReader(in), "")
+		if err != nil {
+			continue // not valid Bash
+		}
+		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
+			want := make(map[Node]bool)
+			reachableNodes(reflect.ValueOf(f), want)
+			Walk(f, func(node Node) bool {
+				delete(want, node)
+				return true
+			})
+			for node := range want {
+				t.Errorf("Walk did not reach %T at %s in %q", node, node.Pos(), in)
+			}
+		})
+	}
+}